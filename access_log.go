@@ -0,0 +1,104 @@
+package typhon
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// AccessLogFilter is a Filter which logs a line for each request once its response has completed (ie. once its body
+// has been closed), with structured fields describing the method, path, status, duration, body size and request ID. For
+// requests received by HttpHandler, the size is the number of bytes actually written to the client (see
+// Request.BytesWritten); otherwise it is the size of the body as read by the caller. It should be placed outside all
+// other filters (ErrorFilter in particular) so that the status and size reflect what the client receives.
+func AccessLogFilter(req Request, svc Service) Response {
+	return accessLogFilter(req, svc, nil)
+}
+
+// AccessLogFilterWithFields is like AccessLogFilter, but additional fields (for example, from the request's context)
+// are added to each line from those returned by fields.
+func AccessLogFilterWithFields(fields func(req Request, rsp Response) map[string]string) Filter {
+	return func(req Request, svc Service) Response {
+		return accessLogFilter(req, svc, fields)
+	}
+}
+
+func accessLogFilter(req Request, svc Service, fields func(Request, Response) map[string]string) Response {
+	start := time.Now()
+	rsp := svc(req)
+
+	// The body is counted here too because BytesWritten is only known when serving through HttpHandler
+	log := func(n int64) {
+		if written, ok := req.BytesWritten(); ok {
+			n = written
+		}
+		status := responseStatus(rsp)
+		path := ""
+		if req.URL != nil {
+			path = req.URL.Path
+		}
+		metadata := map[string]string{
+			"method":      req.Method,
+			"path":        path,
+			"status":      strconv.Itoa(status),
+			"duration_ms": strconv.FormatFloat(float64(time.Since(start))/float64(time.Millisecond), 'f', 3, 64),
+			"bytes":       strconv.FormatInt(n, 10),
+			"remote_addr": req.RemoteAddr}
+		if id := RequestID(req.Context); id != "" {
+			metadata["request_id"] = id
+		}
+		if fields != nil {
+			for k, v := range fields(req, rsp) {
+				metadata[k] = v
+			}
+		}
+		slog.Info(req, "%s %s %d", req.Method, path, status, metadata)
+	}
+
+	if rsp.Response == nil || rsp.Body == nil {
+		log(0)
+		return rsp
+	}
+	if buf, ok := rsp.Body.(*bufCloser); ok {
+		// Buffered bodies aren't wrapped, so outer filters can still see that they are buffered
+		n, next := int64(buf.Len()), buf.onClose
+		buf.onClose = func() {
+			log(n)
+			if next != nil {
+				next()
+			}
+		}
+		return rsp
+	}
+	rsp.Body = &countingBody{
+		ReadCloser: rsp.Body,
+		done:       log}
+	return rsp
+}
+
+// countingBody wraps a body, counting the bytes read from it, and calls done with the count once it has been closed
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
+func (b *countingBody) unwrapBody() io.ReadCloser {
+	return b.ReadCloser
+}
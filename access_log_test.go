@@ -0,0 +1,107 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturingLogger struct {
+	sync.Mutex
+	events []slog.Event
+}
+
+func (l *capturingLogger) Log(evs ...slog.Event) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, evs...)
+}
+
+func (l *capturingLogger) Flush() error {
+	return nil
+}
+
+func captureLogs(t *testing.T) *capturingLogger {
+	l := &capturingLogger{}
+	prev := slog.DefaultLogger()
+	slog.SetDefaultLogger(l)
+	t.Cleanup(func() { slog.SetDefaultLogger(prev) })
+	return l
+}
+
+func TestAccessLogFilter(t *testing.T) {
+	logs := captureLogs(t)
+	svc := Service(func(req Request) Response {
+		return req.Response("hello")
+	}).Filter(AccessLogFilter)
+
+	ctx := WithRequestID(context.Background(), "abc")
+	req := NewRequest(ctx, "GET", "/foo", nil)
+	rsp := svc(req)
+	_, buffered := rsp.Body.(*bufCloser)
+	assert.True(t, buffered, "buffered bodies shouldn't be wrapped")
+	_, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Empty(t, logs.events) // the body hasn't been closed yet
+	require.NoError(t, rsp.Body.Close())
+	require.NoError(t, rsp.Body.Close())
+
+	require.Len(t, logs.events, 1)
+	ev := logs.events[0]
+	assert.Equal(t, slog.InfoSeverity, ev.Severity)
+	assert.Equal(t, "GET /foo 200", ev.Message)
+	assert.Equal(t, "GET", ev.Metadata["method"])
+	assert.Equal(t, "/foo", ev.Metadata["path"])
+	assert.Equal(t, "200", ev.Metadata["status"])
+	assert.Equal(t, "abc", ev.Metadata["request_id"])
+	assert.Contains(t, ev.Metadata, "duration_ms")
+	assert.Equal(t, "8", ev.Metadata["bytes"])
+}
+
+func TestAccessLogFilterStreaming(t *testing.T) {
+	logs := captureLogs(t)
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for i := 0; i < 3; i++ {
+				s.Write([]byte("chunk"))
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	}).Filter(AccessLogFilterWithFields(func(req Request, rsp Response) map[string]string {
+		return map[string]string{"custom": "value"}
+	}))
+
+	rsp := svc(NewRequest(nil, "GET", "/stream", nil))
+	assert.True(t, isStreamingRsp(rsp))
+	_, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Empty(t, logs.events) // the body hasn't been closed yet
+	require.NoError(t, rsp.Body.Close())
+
+	require.Len(t, logs.events, 1)
+	ev := logs.events[0]
+	assert.Equal(t, "15", ev.Metadata["bytes"])
+	assert.Equal(t, "value", ev.Metadata["custom"])
+}
+
+func TestAccessLogFilterError(t *testing.T) {
+	logs := captureLogs(t)
+	svc := Service(func(req Request) Response {
+		return Response{Error: terrors.NotFound("thing", "Not found", nil)}
+	}).Filter(AccessLogFilter)
+
+	svc(NewRequest(nil, "DELETE", "/thing", nil))
+	require.Len(t, logs.events, 1)
+	assert.Equal(t, "404", logs.events[0].Metadata["status"])
+	assert.Equal(t, "0", logs.events[0].Metadata["bytes"])
+}
@@ -0,0 +1,39 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicAuthFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		user, _, _ := req.BasicAuth()
+		return req.Response(user)
+	})
+	svc = svc.Filter(BasicAuthFilter(BasicAuthCredentials("admin", "s3cret")))
+	svc = svc.Filter(ErrorFilter)
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "admin", body)
+
+	for _, creds := range [][2]string{{"admin", "wrong"}, {"wrong", "s3cret"}, {"admin", ""}} {
+		req = NewRequest(nil, "GET", "/", nil)
+		req.SetBasicAuth(creds[0], creds[1])
+		rsp = svc(req)
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode, creds)
+		assert.Contains(t, rsp.Header.Get("WWW-Authenticate"), "Basic")
+	}
+
+	rsp = svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	assert.Contains(t, rsp.Header.Get("WWW-Authenticate"), "Basic")
+}
@@ -0,0 +1,145 @@
+package typhon
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeValues maps the passed url.Values onto the fields of the struct pointed to by v, using the given struct tag to
+// name the value for each field. A tag may be suffixed with ",required", in which case a missing value is an error.
+// Fields of anonymous (embedded) structs are decoded as if they were fields of the outer struct.
+func decodeValues(values url.Values, v interface{}, tag string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return terrors.InternalService("invalid_target", fmt.Sprintf("Cannot decode %s values into %T", tag, v), nil)
+	}
+	return decodeStruct(values, rv.Elem(), tag)
+}
+
+func decodeStruct(values url.Values, rv reflect.Value, tag string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+		name, opts := f.Tag.Get(tag), ""
+		if idx := strings.Index(name, ","); idx >= 0 {
+			name, opts = name[:idx], name[idx+1:]
+		}
+		switch {
+		case name == "-" || f.PkgPath != "" && !f.Anonymous:
+			continue
+		case name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct:
+			if err := decodeStruct(values, fv, tag); err != nil {
+				return err
+			}
+			continue
+		case name == "":
+			continue
+		}
+
+		vs, ok := values[name]
+		if !ok || len(vs) == 0 {
+			if opts == "required" {
+				return terrors.BadRequest("missing_param", fmt.Sprintf("Missing %s parameter %s", tag, name), map[string]string{
+					"param": name,
+					"field": f.Name})
+			}
+			continue
+		}
+		if err := decodeField(fv, vs); err != nil {
+			msg := fmt.Sprintf("Invalid %s parameter %s: %v", tag, name, err)
+			return terrors.BadRequest("invalid_param", msg, map[string]string{
+				"param": name,
+				"field": f.Name,
+				"value": strings.Join(vs, ",")})
+		}
+	}
+	return nil
+}
+
+// decodeField sets fv from the passed values. Slices receive every value; all other types receive the first.
+func decodeField(fv reflect.Value, vs []string) error {
+	if fv.Kind() == reflect.Slice && !fv.Addr().Type().Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+		for i, v := range vs {
+			if err := decodeScalar(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+		return nil
+	}
+	return decodeScalar(fv, vs[0])
+}
+
+func decodeScalar(fv reflect.Value, v string) error {
+	if fv.Kind() == reflect.Ptr {
+		p := reflect.New(fv.Type().Elem())
+		if err := decodeScalar(p.Elem(), v); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+	if tu, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(v))
+	}
+
+	// time.Time is handled as a TextUnmarshaler (RFC 3339)
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(v)
+	case reflect.Bool:
+		if v == "on" { // the value sent for a checked HTML checkbox
+			fv.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(v, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(v, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(v, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/slog"
+)
+
+const (
+	// DefaultBodyLogMaxSize is the default limit on the size of bodies logged by BodyLogFilter
+	DefaultBodyLogMaxSize = 64 * 1024
+	// redactedValue replaces the values of redacted fields in logged bodies
+	redactedValue = "[REDACTED]"
+)
+
+// BodyLogOptions configures BodyLogFilter. Zero values are replaced with defaults.
+type BodyLogOptions struct {
+	// MaxSize is the size in bytes beyond which bodies are not logged (default DefaultBodyLogMaxSize). Only this much
+	// of a body is held in memory for logging.
+	MaxSize int64
+	// Redact lists the fields whose values are replaced before logging. Each is a dot-separated path of object keys,
+	// such as "password" or "card.number"; a path segment of * matches any key, and arrays are traversed implicitly.
+	// For form-encoded bodies, only the first segment of each path is used, as a form key.
+	Redact []string
+	// ContentTypes are the media types (or ranges, such as text/*) of bodies which are logged (default JSON, form
+	// encoding and text/*). Bodies without a content type are never logged.
+	ContentTypes []string
+	// ExcludeContentTypes are media types (or ranges) of bodies which are not logged, even if included by ContentTypes
+	ExcludeContentTypes []string
+}
+
+var defaultBodyLogContentTypes = []string{"application/json", "application/x-www-form-urlencoded", "text/*"}
+
+// BodyLogFilter returns a Filter which logs the bodies of requests and responses at debug level, for debugging.
+// Bodies are logged only if their content type is included by the options, and if they are no larger than the
+// maximum size (larger bodies are noted, but not logged, as a truncated body can't be reliably redacted). Streaming
+// responses are never logged. Logging doesn't consume bodies: the service and the caller see them as normal.
+func BodyLogFilter(opts BodyLogOptions) Filter {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultBodyLogMaxSize
+	}
+	if opts.ContentTypes == nil {
+		opts.ContentTypes = defaultBodyLogContentTypes
+	}
+	redact := make([][]string, len(opts.Redact))
+	for i, path := range opts.Redact {
+		redact[i] = strings.Split(path, ".")
+	}
+	return func(req Request, svc Service) Response {
+		path := ""
+		if req.URL != nil {
+			path = req.URL.Path
+		}
+		if req.Body != nil && opts.logs(req.Header.Get("Content-Type")) {
+			var body string
+			body, req.Body = opts.capture(req.Body, req.Header.Get("Content-Type"), redact)
+			slog.Debug(req, "Request body for %s %s", req.Method, path, map[string]string{
+				"method": req.Method,
+				"path":   path,
+				"body":   body})
+		}
+
+		rsp := svc(req)
+		if rsp.Response != nil && rsp.Body != nil && !isStreamingRsp(rsp) && opts.logs(rsp.Header.Get("Content-Type")) {
+			var body string
+			body, rsp.Body = opts.capture(rsp.Body, rsp.Header.Get("Content-Type"), redact)
+			slog.Debug(req, "Response body for %s %s (%d)", req.Method, path, rsp.StatusCode, map[string]string{
+				"method": req.Method,
+				"path":   path,
+				"status": strconv.Itoa(rsp.StatusCode),
+				"body":   body})
+		}
+		return rsp
+	}
+}
+
+// logs returns whether bodies of the given content type should be logged
+func (o BodyLogOptions) logs(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, r := range o.ExcludeContentTypes {
+		if mediaRangeMatches(r, mediaType) {
+			return false
+		}
+	}
+	for _, r := range o.ContentTypes {
+		if mediaRangeMatches(r, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// capture reads up to MaxSize bytes of the body, returning its redacted form for logging, along with a body to
+// replace the original which yields the same content
+func (o BodyLogOptions) capture(body io.ReadCloser, contentType string, redact [][]string) (string, io.ReadCloser) {
+	var b []byte
+	if buf, ok := body.(*bufCloser); ok {
+		b = buf.Bytes()
+	} else {
+		var err error
+		b, err = ioutil.ReadAll(io.LimitReader(body, o.MaxSize+1))
+		replacement := io.MultiReader(bytes.NewReader(b), body)
+		if err != nil {
+			replacement = io.MultiReader(bytes.NewReader(b), errorReader{err})
+		}
+		body = &readCloser{
+			Reader: replacement,
+			Closer: body}
+		if err != nil {
+			return "[unreadable body: " + err.Error() + "]", body
+		}
+	}
+	if int64(len(b)) > o.MaxSize {
+		return "[body larger than " + strconv.FormatInt(o.MaxSize, 10) + " bytes not logged]", body
+	}
+	return redactBody(b, contentType, redact), body
+}
+
+// redactBody returns the body with the values of the given fields redacted. If the body can't be parsed, and there
+// are fields to redact, a placeholder is returned rather than risk logging them.
+func redactBody(b []byte, contentType string, redact [][]string) string {
+	if len(redact) == 0 {
+		return string(b)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return "[unparseable body not logged]"
+		}
+		for _, path := range redact {
+			redactJSON(v, path)
+		}
+		redacted, err := json.Marshal(v)
+		if err != nil {
+			return "[unparseable body not logged]"
+		}
+		return string(redacted)
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return "[unparseable body not logged]"
+		}
+		for _, path := range redact {
+			if vs, ok := values[path[0]]; ok {
+				for i := range vs {
+					vs[i] = redactedValue
+				}
+			}
+		}
+		return values.Encode()
+	default:
+		return string(b)
+	}
+}
+
+// redactJSON replaces the values at path within v (a decoded JSON value) with redactedValue
+func redactJSON(v interface{}, path []string) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			redactJSON(e, path)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				v[k] = redactedValue
+			} else {
+				redactJSON(e, path[1:])
+			}
+		}
+	}
+}
+
+// readCloser combines a Reader and a Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errorReader is a reader which always fails with err
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
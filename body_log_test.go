@@ -0,0 +1,119 @@
+package typhon
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyLogFilter(t *testing.T) {
+	logs := captureLogs(t)
+	svc := Service(func(req Request) Response {
+		body := map[string]interface{}{}
+		if err := req.Decode(&body); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(map[string]interface{}{
+			"token": "s3cret",
+			"user": map[string]interface{}{
+				"name": body["name"]}})
+	}).Filter(BodyLogFilter(BodyLogOptions{
+		Redact: []string{"password", "token", "cards.number"}}))
+
+	req := NewRequest(context.Background(), "POST", "/login", map[string]interface{}{
+		"name":     "alice",
+		"password": "hunter2",
+		"cards": []map[string]interface{}{
+			{"number": "4111111111111111", "expiry": "12/30"}}})
+	// Use a body which isn't already buffered, as it would be on a server
+	b, err := req.BodyBytes(true)
+	require.NoError(t, err)
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	// The bodies are unaffected
+	out := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&out))
+	assert.Equal(t, "s3cret", out["token"])
+	assert.Equal(t, "alice", out["user"].(map[string]interface{})["name"])
+
+	require.Len(t, logs.events, 2)
+	reqBody := logs.events[0].Metadata["body"]
+	assert.Contains(t, reqBody, `"password":"[REDACTED]"`)
+	assert.Contains(t, reqBody, `"number":"[REDACTED]"`)
+	assert.Contains(t, reqBody, `"expiry":"12/30"`)
+	assert.NotContains(t, reqBody, "hunter2")
+	rspBody := logs.events[1].Metadata["body"]
+	assert.Contains(t, rspBody, `"token":"[REDACTED]"`)
+	assert.Contains(t, rspBody, `"name":"alice"`)
+	assert.Equal(t, "200", logs.events[1].Metadata["status"])
+}
+
+func TestBodyLogFilterSkips(t *testing.T) {
+	logs := captureLogs(t)
+	var rspBody func(req Request) Response
+	svc := Service(func(req Request) Response {
+		return rspBody(req)
+	}).Filter(BodyLogFilter(BodyLogOptions{
+		MaxSize:             10,
+		Redact:              []string{"password"},
+		ExcludeContentTypes: []string{"text/html"}}))
+
+	// Binary bodies aren't logged
+	rspBody = func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("Content-Type", "application/octet-stream")
+		rsp.Write([]byte{0, 1, 2})
+		return rsp
+	}
+	svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Empty(t, logs.events)
+
+	// Nor are excluded types
+	rspBody = func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("Content-Type", "text/html; charset=utf-8")
+		rsp.Write([]byte("<p>hi</p>"))
+		return rsp
+	}
+	svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Empty(t, logs.events)
+
+	// Nor are streaming responses, which are left intact
+	rspBody = func(req Request) Response {
+		s := Streamer()
+		go func() {
+			s.Write([]byte("line\n"))
+			s.Close()
+		}()
+		rsp := NewResponse(req)
+		rsp.Header.Set("Content-Type", "text/plain")
+		rsp.Body = s
+		return rsp
+	}
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "line\n", string(b))
+	assert.Empty(t, logs.events)
+
+	// Oversized bodies are noted without their content, and remain intact
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"password": "hunter2hunter2"}`))
+	rspBody = func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, `{"password": "hunter2hunter2"}`, string(b))
+		return NewResponse(req)
+	}
+	svc(req)
+	require.Len(t, logs.events, 1)
+	assert.Equal(t, "[body larger than 10 bytes not logged]", logs.events[0].Metadata["body"])
+}
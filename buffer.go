@@ -2,25 +2,99 @@ package typhon
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"sync"
 )
 
+// maxPooledBufferSize is the capacity beyond which buffers aren't returned to bufferPool, so that an occasional large
+// body doesn't keep a large allocation alive
+const maxPooledBufferSize = 256 * 1024
+
+// bufferPool holds the backing arrays of closed bufClosers, for reuse by new ones
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	}}
+
 type bufCloser struct {
 	bytes.Buffer
+	pooled   *[]byte // if set, the backing array is returned to bufferPool on Close
+	escaped  bool    // whether Bytes() has exposed the backing array, which then can't be reused
+	consumed bool    // whether any content has been read (or discarded), so the buffer no longer holds all of it
+	onClose  func()  // if set, called the first time the buffer is closed
+}
+
+// newBufCloser returns an empty bufCloser whose backing array comes from (and on Close, returns to) bufferPool
+func newBufCloser() *bufCloser {
+	p := bufferPool.Get().(*[]byte)
+	b := &bufCloser{
+		pooled: p}
+	b.Buffer = *bytes.NewBuffer((*p)[:0])
+	return b
+}
+
+// Bytes is as bytes.Buffer's, but as the returned slice aliases the buffer, it is never reused after Close
+func (b *bufCloser) Bytes() []byte {
+	b.escaped = true
+	return b.Buffer.Bytes()
+}
+
+func (b *bufCloser) Read(p []byte) (int, error) {
+	n, err := b.Buffer.Read(p)
+	b.consumed = b.consumed || n > 0
+	return n, err
+}
+
+func (b *bufCloser) ReadByte() (byte, error) {
+	c, err := b.Buffer.ReadByte()
+	b.consumed = b.consumed || err == nil
+	return c, err
+}
+
+func (b *bufCloser) ReadRune() (rune, int, error) {
+	r, n, err := b.Buffer.ReadRune()
+	b.consumed = b.consumed || n > 0
+	return r, n, err
+}
+
+func (b *bufCloser) WriteTo(w io.Writer) (int64, error) {
+	n, err := b.Buffer.WriteTo(w)
+	b.consumed = b.consumed || n > 0
+	return n, err
 }
 
+// Close releases the buffer for reuse, if it came from the pool and hasn't been exposed by Bytes. Any unread content
+// is discarded.
 func (b *bufCloser) Close() error {
-	return nil // No-op
+	if f := b.onClose; f != nil {
+		b.onClose = nil
+		defer f()
+	}
+	if p := b.pooled; p != nil {
+		b.pooled = nil
+		if !b.escaped {
+			b.consumed = b.consumed || b.Buffer.Len() > 0
+			b.Buffer.Reset()
+			if buf := b.Buffer.Bytes(); cap(buf) <= maxPooledBufferSize {
+				*p = buf
+				b.Buffer = bytes.Buffer{}
+				bufferPool.Put(p)
+			}
+		}
+	}
+	return nil
 }
 
 type streamer struct {
 	pipeR *io.PipeReader
 	pipeW *io.PipeWriter
+	b     *streamBuffer // if set, used instead of the pipe
 }
 
 // Streamer returns a reader/writer/closer that can be used to stream service responses. It does not necessarily
-// perform internal buffering, so users should take care not to depend on such behaviour.
+// perform internal buffering, so users should take care not to depend on such behaviour. It also implements
+// ErrorCloser, so that a stream which fails part-way through can be ended with an error.
 func Streamer() io.ReadWriteCloser {
 	pipeR, pipeW := io.Pipe()
 	return &streamer{
@@ -28,16 +102,159 @@ func Streamer() io.ReadWriteCloser {
 		pipeW: pipeW}
 }
 
+// A BoundedStreamer is a Streamer with an internal buffer of bounded size
+type BoundedStreamer interface {
+	io.ReadWriteCloser
+	ErrorCloser
+	// Buffered returns the number of bytes currently buffered
+	Buffered() int
+	// HighWaterMark returns the largest number of bytes which have been buffered at once
+	HighWaterMark() int
+}
+
+// NewBoundedStreamer returns a Streamer which buffers up to size bytes written ahead of its reader. Once the buffer is
+// full, writes block until the reader catches up, which applies backpressure to producers rather than letting memory
+// use grow with a slow consumer. When ctx is done, blocked reads and writes are unblocked and fail (as do subsequent
+// ones) with the context's error, so producers don't leak if the consumer goes away.
+func NewBoundedStreamer(ctx context.Context, size int) BoundedStreamer {
+	if size <= 0 {
+		size = 1
+	}
+	b := &streamBuffer{
+		size: size}
+	b.cond = sync.NewCond(&b.m)
+	if ctx != nil && ctx.Done() != nil {
+		b.stop = context.AfterFunc(ctx, func() {
+			b.m.Lock()
+			defer b.m.Unlock()
+			b.ctxErr = ctx.Err()
+			b.cond.Broadcast()
+		})
+	}
+	return &streamer{
+		b: b}
+}
+
 func (s *streamer) Read(p []byte) (int, error) {
+	if s.b != nil {
+		return s.b.read(p)
+	}
 	return s.pipeR.Read(p)
 }
 
 func (s *streamer) Write(p []byte) (int, error) {
+	if s.b != nil {
+		return s.b.write(p)
+	}
 	return s.pipeW.Write(p)
 }
 
 func (s *streamer) Close() error {
-	return s.pipeW.Close()
+	return s.CloseWithError(nil)
+}
+
+// CloseWithError ends the stream with an error, which is returned to readers after any data already written. The
+// error is sent to clients in the StreamErrorTrailer trailer, so they can tell that the stream is incomplete.
+func (s *streamer) CloseWithError(err error) error {
+	if s.b != nil {
+		s.b.close(err)
+		return nil
+	}
+	return s.pipeW.CloseWithError(err)
+}
+
+// Buffered returns the number of bytes currently buffered
+func (s *streamer) Buffered() int {
+	if s.b == nil {
+		return 0
+	}
+	s.b.m.Lock()
+	defer s.b.m.Unlock()
+	return s.b.buf.Len()
+}
+
+// HighWaterMark returns the largest number of bytes which have been buffered at once
+func (s *streamer) HighWaterMark() int {
+	if s.b == nil {
+		return 0
+	}
+	s.b.m.Lock()
+	defer s.b.m.Unlock()
+	return s.b.highWater
+}
+
+// streamBuffer is the bounded buffer underlying a streamer constructed by NewBoundedStreamer
+type streamBuffer struct {
+	m         sync.Mutex
+	cond      *sync.Cond // broadcast whenever the buffer is read from, written to, or closed
+	buf       bytes.Buffer
+	size      int
+	highWater int
+	closed    bool
+	closeErr  error
+	ctxErr    error
+	stop      func() bool // stops watching the context
+}
+
+func (b *streamBuffer) read(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for b.buf.Len() == 0 && !b.closed && b.ctxErr == nil {
+		b.cond.Wait()
+	}
+	switch {
+	case b.ctxErr != nil:
+		return 0, b.ctxErr
+	case b.buf.Len() > 0:
+		n, _ := b.buf.Read(p)
+		b.cond.Broadcast()
+		return n, nil
+	case b.closeErr != nil:
+		return 0, b.closeErr
+	default:
+		return 0, io.EOF
+	}
+}
+
+func (b *streamBuffer) write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	n := 0
+	for n < len(p) {
+		for b.buf.Len() >= b.size && !b.closed && b.ctxErr == nil {
+			b.cond.Wait()
+		}
+		switch {
+		case b.ctxErr != nil:
+			return n, b.ctxErr
+		case b.closed:
+			return n, io.ErrClosedPipe
+		}
+		chunk := p[n:]
+		if space := b.size - b.buf.Len(); len(chunk) > space {
+			chunk = chunk[:space]
+		}
+		b.buf.Write(chunk)
+		n += len(chunk)
+		if l := b.buf.Len(); l > b.highWater {
+			b.highWater = l
+		}
+		b.cond.Broadcast()
+	}
+	return n, nil
+}
+
+func (b *streamBuffer) close(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed, b.closeErr = true, err
+	if b.stop != nil {
+		b.stop()
+	}
+	b.cond.Broadcast()
 }
 
 // countingWriter is a writer which proxies writes to an underlying io.Writer, keeping track of how many bytes have
@@ -87,3 +304,35 @@ func (r *doneReader) Read(p []byte) (int, error) {
 	}
 	return n, err
 }
+
+// limitReader is a wrapper around a ReadCloser which returns an error if more than a given number of bytes are read
+// from the underlying reader
+type limitReader struct {
+	remaining int64
+	err       error // Returned once the limit is exceeded
+	io.ReadCloser
+}
+
+func newLimitReader(r io.ReadCloser, limit int64, err error) *limitReader {
+	return &limitReader{
+		remaining:  limit,
+		err:        err,
+		ReadCloser: r}
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, r.err
+	}
+	// Read one byte more than allowed so that a body of exactly the limit is permitted, but anything larger is caught
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		n += int(r.remaining)
+		err = r.err
+	}
+	return n, err
+}
@@ -0,0 +1,151 @@
+package typhon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundedStreamer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Backpressure", func(t *testing.T) {
+		t.Parallel()
+		s := NewBoundedStreamer(context.Background(), 4)
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			n, err := s.Write([]byte("abcdefgh"))
+			assert.NoError(t, err)
+			assert.Equal(t, 8, n)
+			s.Close()
+		}()
+
+		// The write can't complete until the reader makes space
+		select {
+		case <-written:
+			t.Fatal("write completed without being read")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.Equal(t, 4, s.Buffered())
+
+		b, err := ioutil.ReadAll(s)
+		require.NoError(t, err)
+		assert.Equal(t, "abcdefgh", string(b))
+		<-written
+		assert.Equal(t, 4, s.HighWaterMark())
+		assert.Equal(t, 0, s.Buffered())
+	})
+
+	t.Run("ContextCancellation", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		s := NewBoundedStreamer(ctx, 2)
+		errs := make(chan error)
+		go func() {
+			_, err := s.Write([]byte("abc"))
+			errs <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errs:
+			assert.Equal(t, context.Canceled, err)
+		case <-time.After(time.Second):
+			t.Fatal("write was not unblocked by cancellation")
+		}
+		_, err := s.Read(make([]byte, 10))
+		assert.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("ReaderClose", func(t *testing.T) {
+		t.Parallel()
+		s := NewBoundedStreamer(context.Background(), 2)
+		errs := make(chan error)
+		go func() {
+			_, err := s.Write([]byte("abc"))
+			errs <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		s.Close()
+		assert.Equal(t, io.ErrClosedPipe, <-errs)
+	})
+
+	t.Run("CloseWithError", func(t *testing.T) {
+		t.Parallel()
+		s := NewBoundedStreamer(context.Background(), 10)
+		_, err := s.Write([]byte("abc"))
+		require.NoError(t, err)
+		s.CloseWithError(io.ErrUnexpectedEOF)
+		b, err := ioutil.ReadAll(s)
+		assert.Equal(t, "abc", string(b))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func TestBoundedStreamerResponse(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		s := NewBoundedStreamer(req, 16)
+		go func() {
+			defer s.Close()
+			for i := 0; i < 100; i++ {
+				if _, err := s.Write([]byte("0123456789")); err != nil {
+					return
+				}
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.True(t, isStreamingRsp(rsp))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Len(t, b, 1000)
+}
+
+func TestBufCloserPooling(t *testing.T) {
+	t.Parallel()
+	b := newBufCloser()
+	b.WriteString("pooled")
+	got, err := ioutil.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "pooled", string(got))
+	require.NoError(t, b.Close())
+	assert.Nil(t, b.pooled)
+	assert.Equal(t, 0, b.Cap()) // the backing array has been released
+	require.NoError(t, b.Close())
+
+	// A buffer whose contents have been exposed is never reused
+	b = newBufCloser()
+	b.WriteString("escaped")
+	exposed := b.Bytes()
+	require.NoError(t, b.Close())
+	for i := 0; i < 10; i++ {
+		other := newBufCloser()
+		other.WriteString("overwritten")
+		other.Close()
+	}
+	assert.Equal(t, "escaped", string(exposed))
+}
+
+func BenchmarkResponseBody(b *testing.B) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 512) // 8 KiB
+	req := NewRequest(nil, "GET", "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rsp := NewResponse(req)
+		rsp.Write(payload)
+		io.Copy(ioutil.Discard, rsp.Body)
+		rsp.Body.Close()
+	}
+}
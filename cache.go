@@ -0,0 +1,243 @@
+package typhon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A CacheStore stores serialised responses for CacheFilter. Implementations (for example, backed by Redis) MUST be
+// safe for concurrent use.
+type CacheStore interface {
+	// Get returns the value stored under key, or false if there is none (or it has expired)
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value under key, to expire after ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// MemoryCacheStore is a CacheStore which holds values in memory. Expired values are removed as they are looked up,
+// and periodically as others are stored.
+type MemoryCacheStore struct {
+	mtx     sync.Mutex
+	entries map[string]memoryCacheEntry
+	sets    int // since expired entries were last swept
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryCacheSweepInterval is the number of values stored between sweeps of expired entries
+const memoryCacheSweepInterval = 1000
+
+// NewMemoryCacheStore returns an empty MemoryCacheStore
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return &MemoryCacheStore{
+		entries: make(map[string]memoryCacheEntry)}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !time.Now().Before(e.expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.sets++; s.sets >= memoryCacheSweepInterval {
+		s.sets = 0
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[key] = memoryCacheEntry{
+		value:   value,
+		expires: now.Add(ttl)}
+	return nil
+}
+
+// cachedResponse is the form in which CacheFilter stores responses
+type cachedResponse struct {
+	StatusCode int               `json:"status"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	Vary       map[string]string `json:"vary,omitempty"` // values of the request headers named by the Vary header
+	Stored     time.Time         `json:"stored"`
+}
+
+// DefaultCacheKey is the key used by CacheFilter if none is given: the request's method, host and URI. Request headers
+// named by a response's Vary header are also taken into account, separately.
+func DefaultCacheKey(req Request) string {
+	return req.Method + " " + req.Host + req.URL.RequestURI()
+}
+
+// CacheFilter returns a Filter which caches responses to GET requests in store, under the key given by keyFn (or
+// DefaultCacheKey if nil), and serves them while they are younger than ttl. Only successful, non-streaming responses
+// are cached, and not if they set cookies, carry a Cache-Control of no-store or private, or a Vary of *. Responses to
+// requests with an Authorization header are only cached if their Cache-Control explicitly allows it, with public,
+// s-maxage or must-revalidate. Requests with a Cache-Control of no-store bypass the cache, and those with no-cache
+// aren't served from it.
+//
+// When many requests miss the cache for the same key at once, only one is passed to the service; the others wait for
+// its response and are served a copy of it, if it is cacheable. Errors from the store are treated as misses.
+func CacheFilter(store CacheStore, keyFn func(Request) string, ttl time.Duration) Filter {
+	if keyFn == nil {
+		keyFn = DefaultCacheKey
+	}
+	flights := &flightGroup{}
+	return func(req Request, svc Service) Response {
+		reqCC := req.Header.Get("Cache-Control")
+		if req.Method != http.MethodGet || hasCacheDirective(reqCC, "no-store") {
+			return svc(req)
+		}
+		if req.Context == nil {
+			req.Context = context.Background()
+		}
+		key := keyFn(req)
+		if !hasCacheDirective(reqCC, "no-cache") {
+			if b, ok, err := store.Get(req, key); err == nil && ok {
+				if rsp, ok := cachedResponseFor(req, b); ok {
+					return rsp
+				}
+			}
+		}
+
+		f, leader := flights.join(key)
+		if !leader {
+			select {
+			case <-f.done:
+			case <-req.Done():
+				return Response{
+					Error: terrors.Wrap(req.Err(), nil)}
+			}
+			if b, ok := f.val.([]byte); ok {
+				if rsp, ok := cachedResponseFor(req, b); ok {
+					return rsp
+				}
+			}
+			return svc(req)
+		}
+
+		var stored []byte
+		defer func() {
+			flights.finish(key, f, stored)
+		}()
+		rsp := svc(req)
+		if b, ok := cacheableResponse(req, &rsp); ok {
+			if err := store.Set(req, key, b, ttl); err == nil {
+				stored = b
+			}
+		}
+		return rsp
+	}
+}
+
+// cacheableResponse returns the serialised form of rsp, or false if it may not be cached. Its body is buffered as a
+// result, but remains readable.
+func cacheableResponse(req Request, rsp *Response) ([]byte, bool) {
+	if rsp.Error != nil || rsp.Response == nil || isStreamingRsp(*rsp) {
+		return nil, false
+	}
+	switch rsp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent:
+	default:
+		return nil, false
+	}
+	cc := rsp.Header.Get("Cache-Control")
+	if hasCacheDirective(cc, "no-store") || hasCacheDirective(cc, "private") || len(rsp.Header["Set-Cookie"]) > 0 {
+		return nil, false
+	}
+	// Responses to authenticated requests are specific to the caller unless they say otherwise (RFC 9111 section 3.5)
+	if req.Header.Get("Authorization") != "" && !hasCacheDirective(cc, "public") &&
+		!hasCacheDirective(cc, "s-maxage") && !hasCacheDirective(cc, "must-revalidate") {
+		return nil, false
+	}
+	var vary map[string]string
+	for _, v := range rsp.Header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			} else if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = req.Header.Get(name)
+		}
+	}
+	var body []byte
+	if rsp.Body != nil {
+		var err error
+		if body, err = rsp.BodyBytes(false); err != nil {
+			rsp.Error = err
+			return nil, false
+		}
+	}
+	b, err := json.Marshal(cachedResponse{
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header,
+		Body:       body,
+		Vary:       vary,
+		Stored:     time.Now()})
+	return b, err == nil
+}
+
+// cachedResponseFor reconstructs a cached response to the request, or returns false if it can't be used (because it
+// varies on a request header with a different value, or is corrupt)
+func cachedResponseFor(req Request, b []byte) (Response, bool) {
+	c := cachedResponse{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Response{}, false
+	}
+	for name, v := range c.Vary {
+		if req.Header.Get(name) != v {
+			return Response{}, false
+		}
+	}
+	rsp := NewResponse(req)
+	rsp.StatusCode = c.StatusCode
+	rsp.Header = cloneHeader(c.Header)
+	age := time.Since(c.Stored)
+	if age < 0 {
+		age = 0
+	}
+	rsp.Header.Set("Age", strconv.Itoa(int(age/time.Second)))
+	rsp.Write(c.Body)
+	rsp.ContentLength = int64(len(c.Body))
+	return rsp, true
+}
+
+// hasCacheDirective returns whether the Cache-Control header value includes the named directive
+func hasCacheDirective(cc, directive string) bool {
+	for _, d := range strings.Split(cc, ",") {
+		d = strings.TrimSpace(d)
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			d = d[:i]
+		}
+		if strings.EqualFold(d, directive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheFilter(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	svc := Service(func(req Request) Response {
+		n := atomic.AddInt32(&calls, 1)
+		rsp := req.Response(n)
+		switch req.URL.Path {
+		case "/no-store":
+			rsp.Header.Set("Cache-Control", "no-store")
+		case "/vary":
+			rsp.Header.Set("Vary", "Accept-Language")
+		case "/error":
+			rsp.StatusCode = http.StatusInternalServerError
+		}
+		return rsp
+	}).Filter(CacheFilter(NewMemoryCacheStore(), nil, 50*time.Millisecond))
+
+	get := func(path string, header ...string) int32 {
+		req := NewRequest(context.Background(), "GET", path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rsp := svc(req)
+		require.NoError(t, rsp.Error)
+		n := int32(0)
+		require.NoError(t, rsp.Decode(&n))
+		return n
+	}
+
+	assert.Equal(t, int32(1), get("/a"))
+	assert.Equal(t, int32(1), get("/a"))
+	assert.Equal(t, int32(2), get("/a?page=2"))
+	assert.Equal(t, int32(3), get("/a", "Cache-Control", "no-cache")) // refreshes the cache
+	assert.Equal(t, int32(3), get("/a"))
+	assert.Equal(t, int32(4), get("/a", "Cache-Control", "no-store"))
+	assert.Equal(t, int32(3), get("/a"))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, int32(5), get("/a"))
+
+	assert.Equal(t, int32(6), get("/no-store"))
+	assert.Equal(t, int32(7), get("/no-store"))
+
+	assert.Equal(t, int32(8), get("/vary", "Accept-Language", "en"))
+	assert.Equal(t, int32(8), get("/vary", "Accept-Language", "en"))
+	assert.Equal(t, int32(9), get("/vary", "Accept-Language", "fr"))
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/error", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, int32(11), get("/error"))
+}
+
+func TestCacheFilterAuthorization(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(req.Header.Get("Authorization"))
+		if req.URL.Path == "/public" {
+			rsp.Header.Set("Cache-Control", "public, max-age=60")
+		}
+		return rsp
+	}).Filter(CacheFilter(NewMemoryCacheStore(), nil, time.Minute))
+
+	get := func(path, auth string) string {
+		req := NewRequest(context.Background(), "GET", path, nil)
+		req.Header.Set("Authorization", auth)
+		rsp := svc(req)
+		require.NoError(t, rsp.Error)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		return body
+	}
+
+	// Different callers never share an entry...
+	assert.Equal(t, "Bearer alice", get("/me", "Bearer alice"))
+	assert.Equal(t, "Bearer bob", get("/me", "Bearer bob"))
+	assert.Equal(t, "Bearer alice", get("/me", "Bearer alice"))
+
+	// ...unless the response says that it may be shared
+	assert.Equal(t, "Bearer alice", get("/public", "Bearer alice"))
+	assert.Equal(t, "Bearer alice", get("/public", "Bearer bob"))
+}
+
+func TestCacheFilterNilContext(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		<-release
+		return req.Response("ok")
+	}).Filter(CacheFilter(NewMemoryCacheStore(), nil, time.Minute))
+
+	// The second request waits on the first's flight, which needs its context
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := NewRequest(nil, "GET", "/nil", nil)
+			req.Context = nil
+			rsp := svc(req)
+			assert.NoError(t, rsp.Error)
+			body := ""
+			assert.NoError(t, rsp.Decode(&body))
+			assert.Equal(t, "ok", body)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestCacheFilterSingleFlight(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return req.Response("ok")
+	}).Filter(CacheFilter(NewMemoryCacheStore(), nil, time.Minute))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/slow", nil))
+			assert.NoError(t, rsp.Error)
+			body := ""
+			assert.NoError(t, rsp.Decode(&body))
+			assert.Equal(t, "ok", body)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
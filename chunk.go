@@ -3,16 +3,31 @@ package typhon
 import (
 	"io"
 	"net/http"
+	"sync"
 )
 
+// copyBufferSize is the size of the buffers through which copyChunked copies
+const copyBufferSize = 32 * 1024
+
+// copyBufferPool holds buffers for copyChunked, so that each streamed (or proxied) response doesn't allocate its own
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	}}
+
+// copyChunked copies src to dst, flushing dst (if it is an http.Flusher) after each read so that data reaches the
+// client as soon as it's available. Data is copied directly from each read to dst, through a pooled buffer.
 func copyChunked(dst io.Writer, src io.Reader) (written int64, err error) {
+	bp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bp)
+	buf := *bp
 	flusher, flusherOk := dst.(http.Flusher)
 	if !flusherOk {
-		return io.Copy(dst, src)
+		return io.CopyBuffer(dst, src, buf)
 	}
 
 	// This is taken and lightly adapted from the source of io.Copy
-	buf := make([]byte, 32*1024)
 	for {
 		nr, er := src.Read(buf)
 		if nr > 0 {
@@ -0,0 +1,41 @@
+package typhon
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyChunked(t *testing.T) {
+	t.Parallel()
+	payload := strings.Repeat("0123456789", 10000)
+
+	rw := httptest.NewRecorder()
+	n, err := copyChunked(rw, strings.NewReader(payload))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), n)
+	assert.Equal(t, payload, rw.Body.String())
+	assert.True(t, rw.Flushed)
+
+	// A writer which can't be flushed is copied to as normal
+	buf := &bytes.Buffer{}
+	n, err = copyChunked(struct{ *bytes.Buffer }{buf}, strings.NewReader(payload))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), n)
+	assert.Equal(t, payload, buf.String())
+}
+
+func BenchmarkCopyChunked(b *testing.B) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4096) // 64 KiB
+	rw := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rw.Body.Reset()
+		copyChunked(rw, bytes.NewReader(payload))
+	}
+}
@@ -0,0 +1,331 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// CircuitState is the state of a circuit breaker for a target
+type CircuitState int
+
+const (
+	// CircuitClosed permits requests to flow normally
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests without attempting them
+	CircuitOpen
+	// CircuitHalfOpen permits a single probe request; its outcome decides whether the circuit closes or re-opens
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerOptions configures the behaviour of CircuitBreakerFilter. Zero values are replaced with defaults.
+type CircuitBreakerOptions struct {
+	// Window is the period over which the failure rate is measured (default 10s). It is divided into buckets, so is
+	// rounded up to at least one nanosecond per bucket.
+	Window time.Duration
+	// MinRequests is the number of requests which must be seen within the window before the circuit may trip
+	// (default 20)
+	MinRequests int
+	// FailureThreshold is the proportion of requests within the window which must fail to trip the circuit
+	// (default 0.5)
+	FailureThreshold float64
+	// OpenDuration is how long a tripped circuit stays open before a probe is permitted (default 5s)
+	OpenDuration time.Duration
+	// ProbeTimeout is how long a half-open probe may go without reporting its outcome (for a streaming response, until
+	// its body has been read or closed) before another request is permitted to probe in its place (default
+	// OpenDuration)
+	ProbeTimeout time.Duration
+	// Target identifies the downstream a request is destined for; each target has its own circuit. If nil, the
+	// request's host is used.
+	Target func(req Request) string
+	// IsFailure classifies responses. If nil, transport errors and 5xx responses are failures.
+	IsFailure func(req Request, rsp Response) bool
+	// OnStateChange, if non-nil, is called (synchronously) whenever a target's circuit changes state
+	OnStateChange func(target string, from, to CircuitState)
+}
+
+const circuitBuckets = 10
+
+type circuitBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+type circuit struct {
+	state       CircuitState
+	openedAt    time.Time
+	probe       uint64    // identifies the half-open probe in flight, if non-zero
+	probeExpiry time.Time // when the probe in flight gives up its slot
+	probes      uint64    // the number of probes sent, from which their identifiers are assigned
+	buckets     [circuitBuckets]circuitBucket
+}
+
+type circuitBreaker struct {
+	CircuitBreakerOptions
+	m        sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+func defaultIsFailure(req Request, rsp Response) bool {
+	if rsp.Response == nil {
+		return rsp.Error != nil
+	}
+	return rsp.StatusCode >= 500
+}
+
+// CircuitBreakerFilter returns a Filter which stops sending requests to a target whose failure rate over a sliding
+// window exceeds a threshold. While the circuit is open, requests fail immediately with a service unavailable error,
+// with a Retry-After header giving the time until a probe request will be permitted. Requests cancelled by the caller
+// are not counted, and streaming responses are classified only once their body has been fully read (or has failed).
+func CircuitBreakerFilter(opts CircuitBreakerOptions) Filter {
+	return newCircuitBreaker(opts).filter
+}
+
+func newCircuitBreaker(opts CircuitBreakerOptions) *circuitBreaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	} else if opts.Window < circuitBuckets {
+		opts.Window = circuitBuckets
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 0.5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 5 * time.Second
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = opts.OpenDuration
+	}
+	if opts.Target == nil {
+		opts.Target = func(req Request) string {
+			if req.URL != nil && req.URL.Host != "" {
+				return req.URL.Host
+			}
+			return req.Host
+		}
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = defaultIsFailure
+	}
+	return &circuitBreaker{
+		CircuitBreakerOptions: opts,
+		circuits:              make(map[string]*circuit),
+		now:                   time.Now}
+}
+
+func (b *circuitBreaker) filter(req Request, svc Service) Response {
+	target := b.Target(req)
+	probe, ok, wait := b.allow(target)
+	if !ok {
+		rsp := NewResponse(req)
+		rsp.Error = terrors.New(ErrServiceUnavailable+".circuit_open", "Circuit breaker is open", map[string]string{
+			"target": target})
+		if wait > 0 {
+			rsp.SetRetryAfter(wait)
+		}
+		return rsp
+	}
+
+	reported := false
+	defer func() {
+		if !reported {
+			b.record(target, probe, nil) // The service panicked; free the probe slot (if held)
+		}
+	}()
+	rsp := svc(req)
+	reported = true
+	switch {
+	case req.Context != nil && req.Err() == context.Canceled:
+		b.record(target, probe, nil)
+	case b.IsFailure(req, rsp):
+		failed := true
+		b.record(target, probe, &failed)
+	case rsp.Response != nil && rsp.Body != nil && isStreamingRsp(rsp):
+		rsp.Body = &circuitBody{
+			ReadCloser: rsp.Body,
+			done: func(err error) {
+				if err != nil && req.Context != nil && req.Err() == context.Canceled {
+					b.record(target, probe, nil)
+					return
+				}
+				failed := err != nil
+				b.record(target, probe, &failed)
+			}}
+	default:
+		failed := false
+		b.record(target, probe, &failed)
+	}
+	return rsp
+}
+
+// allow returns whether a request to the target may proceed, and if it is a half-open probe, a non-zero identifier for
+// it. If the request may not proceed, it also returns how long it will be until one might.
+func (b *circuitBreaker) allow(target string) (probe uint64, ok bool, wait time.Duration) {
+	b.m.Lock()
+	c := b.circuit(target)
+	now := b.now()
+	from := c.state
+	ok = true
+	switch c.state {
+	case CircuitOpen:
+		if open := now.Sub(c.openedAt); open < b.OpenDuration {
+			ok, wait = false, b.OpenDuration-open
+			break
+		}
+		c.state = CircuitHalfOpen
+		probe = b.startProbe(c, now)
+	case CircuitHalfOpen:
+		// A probe which hasn't reported back in time (eg. because its body was never closed) gives up its slot
+		if c.probe != 0 && now.Before(c.probeExpiry) {
+			ok, wait = false, c.probeExpiry.Sub(now)
+		} else {
+			probe = b.startProbe(c, now)
+		}
+	}
+	b.m.Unlock()
+	if from == CircuitOpen && probe != 0 {
+		b.notify(target, CircuitOpen, CircuitHalfOpen)
+	}
+	return probe, ok, wait
+}
+
+// startProbe allocates the circuit's probe slot to a new probe, returning its identifier. b.m must be held.
+func (b *circuitBreaker) startProbe(c *circuit, now time.Time) uint64 {
+	c.probes++
+	c.probe, c.probeExpiry = c.probes, now.Add(b.ProbeTimeout)
+	return c.probe
+}
+
+// record registers the outcome of a request, which is a half-open probe if probe is non-zero. A nil outcome means it
+// should not be counted either way. The outcome of a probe which has since lost its slot is ignored.
+func (b *circuitBreaker) record(target string, probe uint64, failed *bool) {
+	b.m.Lock()
+	c := b.circuit(target)
+	from := c.state
+	switch {
+	case probe != 0:
+		if probe != c.probe {
+			break
+		}
+		c.probe = 0
+		if failed == nil {
+			break // The probe didn't tell us anything; let another request try
+		}
+		if *failed {
+			c.state = CircuitOpen
+			c.openedAt = b.now()
+		} else {
+			c.state = CircuitClosed
+			c.buckets = [circuitBuckets]circuitBucket{}
+		}
+	case failed != nil && c.state == CircuitClosed:
+		bucket := b.bucket(c)
+		if *failed {
+			bucket.failures++
+		} else {
+			bucket.successes++
+		}
+		if b.shouldTrip(c) {
+			c.state = CircuitOpen
+			c.openedAt = b.now()
+		}
+	}
+	to := c.state
+	b.m.Unlock()
+	if from != to {
+		b.notify(target, from, to)
+	}
+}
+
+func (b *circuitBreaker) notify(target string, from, to CircuitState) {
+	if b.OnStateChange != nil {
+		b.OnStateChange(target, from, to)
+	}
+}
+
+// circuit returns the circuit for the target, creating it if needed. b.m must be held.
+func (b *circuitBreaker) circuit(target string) *circuit {
+	c, ok := b.circuits[target]
+	if !ok {
+		c = &circuit{}
+		b.circuits[target] = c
+	}
+	return c
+}
+
+// bucket returns the current bucket of the circuit's sliding window, resetting it if it is stale. b.m must be held.
+func (b *circuitBreaker) bucket(c *circuit) *circuitBucket {
+	width := b.Window / circuitBuckets
+	now := b.now()
+	start := now.Truncate(width)
+	bucket := &c.buckets[(start.UnixNano()/int64(width))%circuitBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{
+			start: start}
+	}
+	return bucket
+}
+
+// shouldTrip returns whether the failure rate within the window warrants opening the circuit. b.m must be held.
+func (b *circuitBreaker) shouldTrip(c *circuit) bool {
+	cutoff := b.now().Add(-b.Window)
+	successes, failures := 0, 0
+	for _, bucket := range c.buckets {
+		if bucket.start.After(cutoff) {
+			successes += bucket.successes
+			failures += bucket.failures
+		}
+	}
+	total := successes + failures
+	return total >= b.MinRequests && float64(failures)/float64(total) >= b.FailureThreshold
+}
+
+// circuitBody wraps a streaming response body, reporting its outcome once it has been fully read, has failed, or has
+// been closed
+type circuitBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func(err error)
+}
+
+func (r *circuitBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.once.Do(func() { r.done(nil) })
+	} else if err != nil {
+		r.once.Do(func() { r.done(err) })
+	}
+	return n, err
+}
+
+func (r *circuitBody) unwrapBody() io.ReadCloser {
+	return r.ReadCloser
+}
+
+func (r *circuitBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(func() { r.done(nil) })
+	return err
+}
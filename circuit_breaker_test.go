@@ -0,0 +1,165 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	transitions := []string{}
+	b := newCircuitBreaker(CircuitBreakerOptions{
+		Window:       10 * time.Second,
+		MinRequests:  4,
+		OpenDuration: time.Second,
+		OnStateChange: func(target string, from, to CircuitState) {
+			transitions = append(transitions, target+":"+to.String())
+		}})
+	b.now = func() time.Time { return now }
+
+	failing, calls := true, 0
+	svc := Service(func(req Request) Response {
+		calls++
+		rsp := req.Response(nil)
+		if failing {
+			rsp.StatusCode = http.StatusInternalServerError
+		}
+		return rsp
+	}).Filter(b.filter)
+
+	req := NewRequest(nil, "GET", "http://downstream/", nil)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusInternalServerError, svc(req).StatusCode)
+	}
+	assert.Equal(t, []string{"downstream:open"}, transitions)
+
+	// Open: fail fast without calling the service
+	rsp := svc(req)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorStatusCode(rsp.Error))
+	assert.Equal(t, "1", rsp.Header.Get("Retry-After"))
+	assert.Equal(t, 4, calls)
+	// Other targets are unaffected
+	assert.Equal(t, http.StatusInternalServerError, svc(NewRequest(nil, "GET", "http://other/", nil)).StatusCode)
+
+	// After the open duration, a failed probe re-opens the circuit
+	now = now.Add(time.Second)
+	svc(req)
+	assert.Equal(t, []string{"downstream:open", "downstream:half-open", "downstream:open"}, transitions)
+	assert.Error(t, svc(req).Error)
+
+	// A successful probe closes it
+	now = now.Add(time.Second)
+	failing = false
+	require.NoError(t, svc(req).Error)
+	assert.Equal(t, "downstream:closed", transitions[len(transitions)-1])
+	require.NoError(t, svc(req).Error)
+}
+
+func TestCircuitBreakerCancellationAndStreaming(t *testing.T) {
+	t.Parallel()
+	b := newCircuitBreaker(CircuitBreakerOptions{
+		MinRequests: 2})
+	opened := false
+	b.OnStateChange = func(target string, from, to CircuitState) {
+		opened = opened || to == CircuitOpen
+	}
+
+	// Requests cancelled by the caller don't count
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc := Service(func(req Request) Response {
+		return Response{
+			Error: req.Err()}
+	}).Filter(b.filter)
+	for i := 0; i < 5; i++ {
+		svc(NewRequest(ctx, "GET", "http://downstream/", nil))
+	}
+	assert.False(t, opened)
+
+	// Streams which fail part way through do
+	svc = Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		s := Streamer()
+		rsp.Body = s
+		go func() {
+			s.Write([]byte("partial"))
+			s.(*streamer).pipeW.CloseWithError(terrors.InternalService("", "stream broke", nil))
+		}()
+		return rsp
+	}).Filter(b.filter)
+	for i := 0; i < 2; i++ {
+		rsp := svc(NewRequest(nil, "GET", "http://downstream/", nil))
+		require.NoError(t, rsp.Error)
+		assert.False(t, opened) // not until the body is read
+		_, err := ioutil.ReadAll(rsp.Body)
+		assert.Error(t, err)
+	}
+	assert.True(t, opened)
+}
+
+func TestCircuitBreakerProbeSlot(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b := newCircuitBreaker(CircuitBreakerOptions{
+		MinRequests:  1,
+		OpenDuration: time.Second,
+		ProbeTimeout: 2 * time.Second})
+	b.now = func() time.Time { return now }
+	behaviour := "fail"
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		switch behaviour {
+		case "fail":
+			rsp.StatusCode = http.StatusInternalServerError
+		case "panic":
+			panic("boom")
+		case "stream":
+			rsp.Body = Streamer()
+		}
+		return rsp
+	}).Filter(b.filter)
+	req := NewRequest(nil, "GET", "http://downstream/", nil)
+	svc(req)
+
+	// A probe which panics gives up its slot straight away
+	now = now.Add(time.Second)
+	behaviour = "panic"
+	assert.Panics(t, func() { svc(req) })
+	behaviour = "stream"
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	// One whose body is never closed holds its slot only until the probe timeout
+	behaviour = "ok"
+	assert.True(t, terrors.PrefixMatches(svc(req).Error, ErrServiceUnavailable))
+	now = now.Add(2 * time.Second)
+	require.NoError(t, svc(req).Error)
+	assert.Equal(t, CircuitClosed, b.circuits["downstream"].state)
+
+	// The abandoned probe's outcome no longer counts
+	rsp.Body.(*circuitBody).done(terrors.InternalService("", "stream broke", nil))
+	assert.Equal(t, CircuitClosed, b.circuits["downstream"].state)
+}
+
+func TestCircuitBreakerTinyWindow(t *testing.T) {
+	t.Parallel()
+	b := newCircuitBreaker(CircuitBreakerOptions{
+		Window: 5})
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(b.filter)
+
+	assert.NotPanics(t, func() {
+		rsp := svc(NewRequest(nil, "GET", "http://downstream/", nil))
+		assert.NoError(t, rsp.Error)
+	})
+}
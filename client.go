@@ -1,7 +1,15 @@
 package typhon
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/facebookgo/httpcontrol"
@@ -22,6 +30,110 @@ var (
 		MaxTries:            6}
 )
 
+// ClientConfig configures the connection pool of a RoundTripper constructed by NewRoundTripper. Zero values mean no
+// limit (or for timeouts, no timeout).
+type ClientConfig struct {
+	// MaxIdleConns limits the number of idle (keep-alive) connections across all hosts
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the number of idle (keep-alive) connections to each host. If zero,
+	// http.DefaultMaxIdleConnsPerHost is used.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the total number of connections (idle, active, or being dialled) to each host
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before being closed
+	IdleConnTimeout time.Duration
+	// DialTimeout limits how long establishing a connection may take
+	DialTimeout time.Duration
+	// DialKeepAlive is the TCP keep-alive period for connections. If zero, keep-alives are disabled.
+	DialKeepAlive time.Duration
+	// UnixSocket, if set, is the path of a unix domain socket to which all connections are made, regardless of the
+	// host in the request URL (which is still sent in the Host header)
+	UnixSocket string
+	// TLSConfig, if set, configures TLS connections: for example to trust a private CA, or to present a client
+	// certificate (see NewClientTLSConfig). If its ServerName is empty, the host being connected to is used for SNI and
+	// certificate verification.
+	TLSConfig *tls.Config
+	// Resolver, if set, is used to resolve hostnames (caching the results) rather than resolving for each connection
+	Resolver *CachingResolver
+}
+
+// DefaultClientConfig returns a ClientConfig matching the connection pool settings of the default RoundTripper
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		MaxIdleConnsPerHost: 10,
+		DialKeepAlive:       10 * time.Minute}
+}
+
+// UnixSocketService returns a Service which sends requests via HTTP over the unix domain socket at the given path. It
+// may be used to target a single request at a socket with Request.SendVia.
+func UnixSocketService(socketPath string) Service {
+	cfg := DefaultClientConfig()
+	cfg.UnixSocket = socketPath
+	return HttpService(NewRoundTripper(cfg))
+}
+
+// NewRoundTripper constructs a RoundTripper with the given connection pool configuration. Unlike the default
+// RoundTripper, it does not itself retry failed requests: use RetryFilter for that. It may be used with HttpService,
+// or installed as RoundTripper to configure the default Client.
+func NewRoundTripper(cfg ClientConfig) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.DialKeepAlive}
+	dial := dialer.DialContext
+	proxy := http.ProxyFromEnvironment
+	if cfg.Resolver != nil {
+		dial = cfg.Resolver.dialContext(dial)
+	}
+	if cfg.UnixSocket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", cfg.UnixSocket)
+		}
+		proxy = nil
+	}
+	// Settings which the config doesn't cover (eg. HTTP/2 and the TLS handshake timeout) are those of net/http's default
+	t := &http.Transport{}
+	if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+		t = dt.Clone()
+	}
+	t.Proxy = proxy
+	t.DialContext = dial
+	t.MaxIdleConns = cfg.MaxIdleConns
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	t.IdleConnTimeout = cfg.IdleConnTimeout
+	t.TLSClientConfig = cfg.TLSConfig
+	return t
+}
+
+// NewClientTLSConfig constructs a TLS configuration for clients from PEM-encoded files. If caFile is non-empty, the
+// certificates within it are trusted instead of the system roots. If certFile and keyFile are non-empty, the
+// certificate is presented to servers which ask for one.
+func NewClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, terrors.Wrap(err, map[string]string{
+				"file": caFile})
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, terrors.InternalService("invalid_ca", "No certificates found in CA file", map[string]string{
+				"file": caFile})
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, terrors.Wrap(err, map[string]string{
+				"cert_file": certFile,
+				"key_file":  keyFile})
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
 // A ResponseFuture is a container for a Response which will materialise at some point.
 type ResponseFuture struct {
 	done <-chan struct{} // guards access to r
@@ -44,7 +156,44 @@ func (f *ResponseFuture) Response() Response {
 func HttpService(rt http.RoundTripper) Service {
 	return func(req Request) Response {
 		ctx := req.unwrappedContext()
-		httpRsp, err := rt.RoundTrip(req.Request.WithContext(ctx))
+		httpReq := req.Request.WithContext(ctx)
+		// Unless the caller has expressed their own preference, ask for a compressed response and decompress it
+		// transparently
+		requestedGzip := false
+		if httpReq.Header.Get("Accept-Encoding") == "" && httpReq.Header.Get("Range") == "" &&
+			httpReq.Method != http.MethodHead {
+			httpReq.Header = cloneHeader(httpReq.Header)
+			httpReq.Header.Set("Accept-Encoding", "gzip")
+			requestedGzip = true
+		}
+		// Making the body replayable allows net/http to transparently retry a request on a fresh connection if a
+		// reused connection turns out to have been closed by the server before anything could be written to it
+		if buf, ok := httpReq.Body.(*bufCloser); ok && httpReq.GetBody == nil {
+			if b := buf.Bytes(); len(b) == 0 && httpReq.ContentLength <= 0 {
+				httpReq.Body = http.NoBody
+				httpReq.ContentLength = 0
+			} else {
+				httpReq.GetBody = func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewReader(b)), nil
+				}
+			}
+		}
+		httpRsp, err := rt.RoundTrip(httpReq)
+		// The error which ended a streaming response is sent in a trailer; surface it to readers of the body
+		if httpRsp != nil && httpRsp.Body != nil && httpRsp.ContentLength < 0 {
+			httpRsp.Body = &trailerErrorReader{
+				ReadCloser: httpRsp.Body,
+				rsp:        httpRsp}
+		}
+		if requestedGzip && httpRsp != nil && httpRsp.Body != nil &&
+			strings.EqualFold(httpRsp.Header.Get("Content-Encoding"), "gzip") {
+			httpRsp.Body = &gzipReadCloser{
+				body: httpRsp.Body}
+			httpRsp.Header.Del("Content-Encoding")
+			httpRsp.Header.Del("Content-Length")
+			httpRsp.ContentLength = -1
+			httpRsp.Uncompressed = true
+		}
 		// When the calling context is cancelled, close the response body
 		// This protects callers that forget to call Close(), or those which proxy responses upstream
 		//
@@ -61,12 +210,23 @@ func HttpService(rt http.RoundTripper) Service {
 				}
 			}()
 		}
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = terrors.WrapWithCode(err, nil, terrors.ErrTimeout)
+		}
 		return Response{
 			Response: httpRsp,
 			Error:    terrors.Wrap(err, nil)}
 	}
 }
 
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h)+1)
+	for k, v := range h {
+		h2[k] = v
+	}
+	return h2
+}
+
 // BareClient is the most basic way to send a request, using the default http RoundTripper
 func BareClient(req Request) Response {
 	return HttpService(RoundTripper)(req)
@@ -80,10 +240,37 @@ func SendVia(req Request, svc Service) *ResponseFuture {
 	go func() {
 		defer close(done) // makes the response available to waiters
 		f.r = svc(req)
+		if req.cancel != nil {
+			releaseWithBody(req.Context, f.r, req.cancel)
+		}
 	}()
 	return f
 }
 
+// releaseWithBody arranges for cancel to be called once the body of rsp has been consumed (or ctx is done), as a
+// streamed body may still depend on the context it was produced under. If the body is already buffered in memory (or
+// there is none), it is called immediately.
+func releaseWithBody(ctx context.Context, rsp Response, cancel context.CancelFunc) {
+	if rsp.Response == nil || rsp.Body == nil {
+		cancel()
+		return
+	}
+	if _, ok := rsp.Body.(*bufCloser); ok {
+		cancel()
+		return
+	}
+	body := newDoneReader(rsp.Body, rsp.ContentLength)
+	rsp.Body = body
+	go func() {
+		defer cancel()
+		select {
+		case <-body.closed:
+		case <-ctx.Done():
+			body.Close()
+		}
+	}()
+}
+
 // Send is equivalent to SendVia(req, Client)
 func Send(req Request) *ResponseFuture {
 	return SendVia(req, Client)
@@ -0,0 +1,185 @@
+package typhon
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// ClientHooks are callbacks invoked around requests sent by a client, for observability (or ad hoc debugging) without
+// writing a Filter. Any of them may be nil.
+type ClientHooks struct {
+	// BeforeRequest is called just before a request is sent
+	BeforeRequest func(req Request)
+	// AfterResponse is called as soon as the response headers have arrived (or the request has failed), with the time
+	// elapsed since the request was sent. It must not read or close the response body.
+	AfterResponse func(req Request, rsp Response, elapsed time.Duration)
+	// Timings, if set, is called after AfterResponse with a breakdown of the time the request took on the wire,
+	// captured with net/http/httptrace. Requests are only traced if some hook sets this.
+	Timings func(req Request, rsp Response, timings ClientTimings)
+}
+
+// ClientTimings breaks down the time taken to obtain a response from a server. Phases which didn't take place (for
+// example, dialling when a kept-alive connection was reused) are zero, as are all of them for requests which weren't
+// sent over HTTP.
+type ClientTimings struct {
+	// DNS is the time taken to resolve the server's hostname
+	DNS time.Duration
+	// Connect is the time taken to establish a TCP connection to the server
+	Connect time.Duration
+	// TLSHandshake is the time taken to perform the TLS handshake once connected
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the time from the request being sent until the first byte of the response arrived, including
+	// any of the above
+	TimeToFirstByte time.Duration
+	// ReusedConn is whether the request was sent over a connection kept alive from an earlier request
+	ReusedConn bool
+}
+
+// ClientHooksFilter returns a Filter which calls the given hooks for each request passing through it. It is intended
+// to be applied to a client, for example:
+//
+//	typhon.Client = typhon.BareClient.Filter(typhon.ClientHooksFilter(hooks))
+//
+// Multiple hooks compose like filters: their BeforeRequest funcs are called in the order given, and their
+// AfterResponse and Timings funcs in the reverse order.
+func ClientHooksFilter(hooks ...ClientHooks) Filter {
+	hooks = append([]ClientHooks(nil), hooks...)
+	traced := false
+	for _, h := range hooks {
+		traced = traced || h.Timings != nil
+	}
+	return func(req Request, svc Service) Response {
+		for _, h := range hooks {
+			if h.BeforeRequest != nil {
+				h.BeforeRequest(req)
+			}
+		}
+		start := time.Now()
+		var tt *timingTrace
+		if traced {
+			tt = &timingTrace{
+				start: start}
+			ctx := req.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			req.Context = httptrace.WithClientTrace(ctx, tt.clientTrace())
+		}
+		rsp := svc(req)
+		elapsed := time.Since(start)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if h := hooks[i]; h.AfterResponse != nil {
+				h.AfterResponse(req, rsp, elapsed)
+			}
+		}
+		if tt != nil {
+			timings := tt.timings()
+			for i := len(hooks) - 1; i >= 0; i-- {
+				if h := hooks[i]; h.Timings != nil {
+					h.Timings(req, rsp, timings)
+				}
+			}
+		}
+		return rsp
+	}
+}
+
+// LogClientTimings returns ClientHooks which log the ClientTimings of each request at debug level
+func LogClientTimings() ClientHooks {
+	return ClientHooks{
+		Timings: func(req Request, rsp Response, t ClientTimings) {
+			url := ""
+			if req.URL != nil {
+				url = req.URL.String()
+			}
+			slog.Debug(req, "Timings for %s %s", req.Method, url, map[string]string{
+				"dns_ms":      formatMillis(t.DNS),
+				"connect_ms":  formatMillis(t.Connect),
+				"tls_ms":      formatMillis(t.TLSHandshake),
+				"ttfb_ms":     formatMillis(t.TimeToFirstByte),
+				"reused_conn": strconv.FormatBool(t.ReusedConn)})
+		}}
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// timingTrace accumulates ClientTimings from httptrace callbacks, which may be made from other goroutines (and, for
+// dials which are abandoned, after the round trip has finished)
+type timingTrace struct {
+	mtx                        sync.Mutex
+	start, dnsStart, connStart time.Time
+	tlsStart                   time.Time
+	t                          ClientTimings
+}
+
+func (tt *timingTrace) timings() ClientTimings {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	return tt.t
+}
+
+// update calls f with the lock held, and the current time
+func (tt *timingTrace) update(f func(now time.Time)) {
+	now := time.Now()
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	f(now)
+}
+
+func (tt *timingTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			tt.update(func(now time.Time) {
+				tt.t.ReusedConn = info.Reused
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tt.update(func(now time.Time) {
+				tt.dnsStart = now
+			})
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tt.update(func(now time.Time) {
+				tt.t.DNS = now.Sub(tt.dnsStart)
+			})
+		},
+		// With multiple addresses, connections may be attempted to several of them; the time is measured from the
+		// first attempt until one succeeds
+		ConnectStart: func(_, _ string) {
+			tt.update(func(now time.Time) {
+				if tt.connStart.IsZero() {
+					tt.connStart = now
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			tt.update(func(now time.Time) {
+				if err == nil && tt.t.Connect == 0 {
+					tt.t.Connect = now.Sub(tt.connStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			tt.update(func(now time.Time) {
+				tt.tlsStart = now
+			})
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tt.update(func(now time.Time) {
+				tt.t.TLSHandshake = now.Sub(tt.tlsStart)
+			})
+		},
+		GotFirstResponseByte: func() {
+			tt.update(func(now time.Time) {
+				tt.t.TimeToFirstByte = now.Sub(tt.start)
+			})
+		}}
+}
@@ -0,0 +1,115 @@
+package typhon
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientHooksFilter(t *testing.T) {
+	t.Parallel()
+	calls := []string{}
+	var elapsed time.Duration
+	hook := func(name string) ClientHooks {
+		return ClientHooks{
+			BeforeRequest: func(req Request) {
+				calls = append(calls, "before "+name+" "+req.URL.Path)
+			},
+			AfterResponse: func(req Request, rsp Response, d time.Duration) {
+				calls = append(calls, "after "+name)
+				assert.Equal(t, http.StatusTeapot, rsp.StatusCode)
+				elapsed = d
+			}}
+	}
+	svc := Service(func(req Request) Response {
+		calls = append(calls, "service")
+		time.Sleep(5 * time.Millisecond)
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusTeapot
+		return rsp
+	}).Filter(ClientHooksFilter(hook("a"), ClientHooks{}, hook("b")))
+
+	rsp := svc(NewRequest(nil, "GET", "/foo", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{
+		"before a /foo",
+		"before b /foo",
+		"service",
+		"after b",
+		"after a"}, calls)
+	assert.True(t, elapsed >= 5*time.Millisecond, "elapsed %v", elapsed)
+}
+
+func TestClientHooksFilterError(t *testing.T) {
+	t.Parallel()
+	var got error
+	svc := Service(func(req Request) Response {
+		return Response{
+			Error: terrors.Timeout("", "Timed out", nil)}
+	}).Filter(ClientHooksFilter(ClientHooks{
+		AfterResponse: func(req Request, rsp Response, d time.Duration) {
+			got = rsp.Error
+		}}))
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.Error(t, rsp.Error)
+	assert.Equal(t, rsp.Error, got)
+}
+
+func TestClientHooksFilterTimings(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		time.Sleep(5 * time.Millisecond)
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	_, port, _ := net.SplitHostPort(s.Listener().Addr().String())
+	rt := NewRoundTripper(DefaultClientConfig())
+	defer rt.(*http.Transport).CloseIdleConnections()
+
+	var timings []ClientTimings
+	client := HttpService(rt).Filter(ClientHooksFilter(ClientHooks{
+		Timings: func(req Request, rsp Response, t ClientTimings) {
+			timings = append(timings, t)
+		}})).Filter(ErrorFilter)
+	for i := 0; i < 2; i++ {
+		rsp := NewRequest(nil, "GET", fmt.Sprintf("http://localhost:%s/", port), nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		_, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, timings, 2)
+	first, second := timings[0], timings[1]
+	assert.False(t, first.ReusedConn)
+	assert.True(t, first.Connect > 0)
+	assert.True(t, first.TimeToFirstByte >= 5*time.Millisecond, "ttfb %v", first.TimeToFirstByte)
+	assert.Zero(t, first.TLSHandshake)
+	assert.True(t, second.ReusedConn)
+	assert.Zero(t, second.DNS)
+	assert.Zero(t, second.Connect)
+	assert.True(t, second.TimeToFirstByte >= 5*time.Millisecond, "ttfb %v", second.TimeToFirstByte)
+}
+
+func TestLogClientTimings(t *testing.T) {
+	logs := captureLogs(t)
+	svc := Service(func(req Request) Response {
+		return NewResponse(req)
+	}).Filter(ClientHooksFilter(LogClientTimings()))
+	rsp := svc(NewRequest(nil, "GET", "http://example.com/foo", nil))
+	require.NoError(t, rsp.Error)
+
+	require.Len(t, logs.events, 1)
+	ev := logs.events[0]
+	assert.Equal(t, slog.DebugSeverity, ev.Severity)
+	assert.Equal(t, "Timings for GET http://example.com/foo", ev.Message)
+	assert.Equal(t, "0.000", ev.Metadata["connect_ms"])
+	assert.Equal(t, "false", ev.Metadata["reused_conn"])
+}
@@ -0,0 +1,134 @@
+package typhon
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ClientTarget configures how requests to a downstream are sent by a ClientRegistry
+type ClientTarget struct {
+	// Timeout, if non-zero, bounds the total time taken by requests to the target (including any retries). It only
+	// ever shortens a deadline already on the request's context.
+	Timeout time.Duration
+	// Retry, if non-nil, retries failed requests to the target (see RetryFilter)
+	Retry *RetryOptions
+	// Transport, if non-nil, sends requests to the target instead of the registry's transport: for example,
+	// HttpService(NewRoundTripper(cfg)) with a ClientConfig trusting a private CA
+	Transport Service
+}
+
+// override returns t with the non-zero fields of o in place of its own
+func (t ClientTarget) override(o ClientTarget) ClientTarget {
+	if o.Timeout != 0 {
+		t.Timeout = o.Timeout
+	}
+	if o.Retry != nil {
+		t.Retry = o.Retry
+	}
+	if o.Transport != nil {
+		t.Transport = o.Transport
+	}
+	return t
+}
+
+type clientTargetContextKeyType struct{}
+
+var clientTargetContextKey = clientTargetContextKeyType{}
+
+// WithClientTarget returns a context with which requests sent via a ClientRegistry use the non-zero fields of t in
+// place of those registered for their target. This allows the defaults to be overridden for individual calls.
+func WithClientTarget(ctx context.Context, t ClientTarget) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, clientTargetContextKey, t)
+}
+
+// A ClientRegistry sends requests according to configuration registered for their target, centralising per-downstream
+// policy (timeouts, retries and transports) rather than having it applied at every call site. It is typically
+// installed as the default client:
+//
+//	registry := typhon.NewClientRegistry(nil)
+//	registry.Register("payments.internal", typhon.ClientTarget{
+//		Timeout: 2 * time.Second,
+//		Retry:   &typhon.RetryOptions{MaxAttempts: 2}})
+//	typhon.Client = registry.Client
+//
+// It is safe for concurrent use.
+type ClientRegistry struct {
+	transport Service
+	m         sync.RWMutex
+	targets   map[string]ClientTarget
+}
+
+// NewClientRegistry returns a ClientRegistry with no targets registered, which sends requests via transport (or
+// BareClient, if nil) unless their target's configuration says otherwise
+func NewClientRegistry(transport Service) *ClientRegistry {
+	if transport == nil {
+		transport = BareClient
+	}
+	return &ClientRegistry{
+		transport: transport,
+		targets:   make(map[string]ClientTarget)}
+}
+
+// Register sets the configuration for requests to target, replacing any already registered. The target is a host
+// (matching requests to it on any port) or a host:port (matching only that port, and preferred over the host alone).
+func (r *ClientRegistry) Register(target string, cfg ClientTarget) {
+	if target == "" {
+		panic("typhon: client target must not be empty")
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.targets[target] = cfg
+}
+
+// Target returns the configuration which applies to the request, and whether one is registered for its target. Any
+// override in the request's context (see WithClientTarget) is not taken into account.
+func (r *ClientRegistry) Target(req Request) (ClientTarget, bool) {
+	host := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	if t, ok := r.targets[host]; ok {
+		return t, true
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		t, ok := r.targets[hostname]
+		return t, ok
+	}
+	return ClientTarget{}, false
+}
+
+// Client is a Service which sends the request according to the configuration for its target
+func (r *ClientRegistry) Client(req Request) Response {
+	t, _ := r.Target(req)
+	if req.Context != nil {
+		if o, ok := req.Value(clientTargetContextKey).(ClientTarget); ok {
+			t = t.override(o)
+		}
+	}
+	svc := t.Transport
+	if svc == nil {
+		svc = r.transport
+	}
+	if t.Retry != nil {
+		svc = svc.Filter(RetryFilter(*t.Retry))
+	}
+	if t.Timeout <= 0 {
+		return svc(req)
+	}
+	parent := req.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, t.Timeout)
+	req.Context = ctx
+	rsp := svc(req)
+	releaseWithBody(ctx, rsp, cancel)
+	return rsp
+}
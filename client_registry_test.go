@@ -0,0 +1,89 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRegistry(t *testing.T) {
+	t.Parallel()
+	transport := func(name string) Service {
+		return func(req Request) Response {
+			if req.URL.Path == "/slow" {
+				<-req.Done()
+				return Response{
+					Error: terrors.Wrap(req.Err(), nil)}
+			}
+			return req.Response(name)
+		}
+	}
+	var failures int32
+	flaky := Service(func(req Request) Response {
+		if atomic.AddInt32(&failures, 1) <= 2 {
+			rsp := NewResponse(req)
+			rsp.StatusCode = http.StatusServiceUnavailable
+			return rsp
+		}
+		return req.Response("flaky")
+	})
+
+	registry := NewClientRegistry(transport("default"))
+	registry.Register("a.internal", ClientTarget{
+		Timeout:   20 * time.Millisecond,
+		Transport: transport("a")})
+	registry.Register("a.internal:8443", ClientTarget{
+		Transport: transport("a:8443")})
+	registry.Register("b.internal", ClientTarget{
+		Retry: &RetryOptions{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond},
+		Transport: flaky})
+
+	body := func(rsp Response) string {
+		require.NoError(t, rsp.Error)
+		var s string
+		require.NoError(t, rsp.Decode(&s))
+		return s
+	}
+	client := Service(registry.Client)
+	assert.Equal(t, "a", body(client(NewRequest(nil, "GET", "http://a.internal/", nil))))
+	assert.Equal(t, "a", body(client(NewRequest(nil, "GET", "http://a.internal:8080/", nil))))
+	assert.Equal(t, "a:8443", body(client(NewRequest(nil, "GET", "https://a.internal:8443/", nil))))
+	assert.Equal(t, "default", body(client(NewRequest(nil, "GET", "http://c.internal/", nil))))
+	assert.Equal(t, "flaky", body(client(NewRequest(nil, "GET", "http://b.internal/", nil))))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&failures))
+
+	// The target's timeout applies...
+	start := time.Now()
+	rsp := client(NewRequest(nil, "GET", "http://a.internal/slow", nil))
+	assert.Error(t, rsp.Error)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// ...unless it's overridden for the call
+	ctx := WithClientTarget(context.Background(), ClientTarget{
+		Transport: transport("override")})
+	assert.Equal(t, "override", body(client(NewRequest(ctx, "GET", "http://a.internal/", nil))))
+	ctx, cancel := context.WithTimeout(WithClientTarget(context.Background(), ClientTarget{
+		Timeout: time.Hour}), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	rsp = client(NewRequest(ctx, "GET", "http://a.internal/slow", nil))
+	assert.Error(t, rsp.Error)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	target, ok := registry.Target(NewRequest(nil, "GET", "http://b.internal/", nil))
+	assert.True(t, ok)
+	assert.Equal(t, 3, target.Retry.MaxAttempts)
+	_, ok = registry.Target(NewRequest(nil, "GET", "http://c.internal/", nil))
+	assert.False(t, ok)
+	assert.Panics(t, func() {
+		registry.Register("", ClientTarget{})
+	})
+}
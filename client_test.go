@@ -0,0 +1,243 @@
+package typhon
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRoundTripper(t *testing.T) {
+	t.Parallel()
+	rt := NewRoundTripper(ClientConfig{
+		MaxIdleConns:        50,
+		MaxIdleConnsPerHost: 5,
+		MaxConnsPerHost:     1,
+		IdleConnTimeout:     time.Minute})
+	transport := rt.(*http.Transport)
+	assert.Equal(t, 50, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 1, transport.MaxConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	// Settings not covered by the config match net/http's default transport
+	assert.True(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	assert.NotZero(t, transport.TLSHandshakeTimeout)
+	defer transport.CloseIdleConnections()
+
+	// With one connection per host, concurrent requests are serialised
+	inFlight, maxInFlight := int32(0), int32(0)
+	s, err := Listen(Service(func(req Request) Response {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	client := HttpService(rt).Filter(ErrorFilter)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil).SendVia(client).Response()
+			assert.NoError(t, rsp.Error)
+			rsp.BodyBytes(true)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestUnixSocketService(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "typhon-sock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sockPath := filepath.Join(dir, "app.sock")
+
+	l, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+	s, err := Serve(Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"path":   req.URL.Path,
+			"header": req.Header.Get("X-Foo")})
+	}), l)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	req := NewRequest(nil, "GET", "http://app/foo/bar", nil)
+	req.Header.Set("X-Foo", "bar")
+	rsp := req.SendVia(UnixSocketService(sockPath).Filter(ErrorFilter)).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "/foo/bar", body["path"])
+	assert.Equal(t, "bar", body["header"])
+
+	missing := filepath.Join(dir, "missing.sock")
+	rsp = NewRequest(nil, "GET", "http://app/", nil).SendVia(UnixSocketService(missing)).Response()
+	require.Error(t, rsp.Error)
+	assert.Contains(t, rsp.Error.Error(), missing)
+}
+
+// testCert generates a certificate (signed by parent, or self-signed if parent is nil) and writes it and its key as
+// PEM files into dir
+func testCert(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := tmpl, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestClientTLS(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "typhon-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := testCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Typhon Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign}, nil)
+	serverCert := testCert(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+	testCert(t, dir, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	l, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert})
+	require.NoError(t, err)
+	s, err := Serve(Service(func(req Request) Response {
+		return req.Response(req.TLS.PeerCertificates[0].Subject.CommonName)
+	}), l)
+	require.NoError(t, err)
+	defer s.Stop()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	tlsCfg, err := NewClientTLSConfig(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"),
+		filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	cfg := DefaultClientConfig()
+	cfg.TLSConfig = tlsCfg
+	client := HttpService(NewRoundTripper(cfg)).Filter(ErrorFilter)
+
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("https://localhost:%s/", port), nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "client", body)
+
+	// The hostname must match the server's certificate
+	rsp = NewRequest(nil, "GET", fmt.Sprintf("https://127.0.0.1:%s/", port), nil).SendVia(client).Response()
+	assert.Error(t, rsp.Error)
+
+	// Without the client certificate, the server rejects the connection
+	tlsCfg, err = NewClientTLSConfig("", "", filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	cfg.TLSConfig = tlsCfg
+	client = HttpService(NewRoundTripper(cfg))
+	rsp = NewRequest(nil, "GET", fmt.Sprintf("https://localhost:%s/", port), nil).SendVia(client).Response()
+	assert.Error(t, rsp.Error)
+}
+
+// TestClientReconnects verifies that a request is retried on a fresh connection when a pooled connection is closed by
+// the server, but only when doing so cannot duplicate side effects
+func TestClientReconnects(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// Respond to the first request on each connection, then hang up on the second without responding
+				for i := 0; i < 2; i++ {
+					req, err := http.ReadRequest(r)
+					if err != nil || i == 1 {
+						return
+					}
+					ioutil.ReadAll(req.Body)
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}(conn)
+		}
+	}()
+
+	for _, c := range []struct {
+		method  string
+		success bool
+	}{
+		{"GET", true},
+		{"POST", false}} {
+		client := HttpService(NewRoundTripper(DefaultClientConfig())).Filter(ErrorFilter)
+		url := fmt.Sprintf("http://%s/", l.Addr())
+		rsp := NewRequest(nil, c.method, url, "body").SendVia(client).Response()
+		require.NoError(t, rsp.Error, c.method)
+		rsp.BodyBytes(true)
+		rsp = NewRequest(nil, c.method, url, "body").SendVia(client).Response()
+		if c.success {
+			assert.NoError(t, rsp.Error, c.method)
+		} else {
+			assert.Error(t, rsp.Error, c.method)
+		}
+	}
+}
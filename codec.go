@@ -0,0 +1,166 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A Codec serialises and de-serialises bodies of a particular media type. Codecs MUST be safe for concurrent use.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+// A JSONEncoder writes JSON values to a stream, each followed by a newline. *json.Encoder satisfies it, as do the
+// encoders of most other JSON libraries (such as jsoniter).
+type JSONEncoder interface {
+	Encode(v interface{}) error
+}
+
+// JSONEscapeHTML is whether the default NewJSONEncoder escapes the characters <, > and & in strings (as \u003c, \u003e
+// and \u0026), so that the JSON can be embedded in HTML safely. This may trip up consumers which don't expect it.
+//
+// It can be overridden globally but MUST only be done before use takes place; access is not synchronised.
+var JSONEscapeHTML = true
+
+// NewJSONEncoder returns the JSONEncoder used to write JSON bodies, by Request.Encode and Response.Encode among others.
+// By default it uses encoding/json, respecting JSONEscapeHTML. Replacing it allows a different JSON library to be
+// used, for example:
+//
+//	typhon.NewJSONEncoder = func(w io.Writer) typhon.JSONEncoder {
+//		return jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(w)
+//	}
+//
+// Like JSONEscapeHTML, it can be overridden globally but MUST only be done before use takes place.
+var NewJSONEncoder = func(w io.Writer) JSONEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(JSONEscapeHTML)
+	return enc
+}
+
+// defaultCodecMediaType is the media type used to encode and decode bodies which have no Content-Type
+const defaultCodecMediaType = "application/json"
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{
+		"application/json":       jsonCodec{},
+		"application/xml":        xmlCodec{},
+		"text/xml":               xmlCodec{},
+		"application/protobuf":   protobufCodec{},
+		"application/x-protobuf": protobufCodec{}}}
+
+// RegisterCodec registers the Codec used by Request.Encode, Request.Decode, Response.Encode and Response.Decode for
+// bodies of the given media type (for example "application/msgpack"). JSON, XML and protobuf are registered by
+// default. It panics if the media type is invalid, or if a codec is already registered for it.
+func RegisterCodec(mediaType string, codec Codec) {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil || codec == nil {
+		panic(fmt.Sprintf("typhon: cannot register codec for media type %q", mediaType))
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if existing, ok := codecs.m[parsed]; ok {
+		panic(fmt.Sprintf("typhon: codec %T is already registered for media type %s", existing, parsed))
+	}
+	codecs.m[parsed] = codec
+}
+
+// codecFor returns the Codec registered for the media type of the given Content-Type header, and whether one is. A
+// media type with a structured syntax suffix (such as application/problem+json) falls back to the codec for its
+// suffix. Bodies without a Content-Type, or whose Content-Type has no codec (or can't be parsed), are treated as JSON,
+// as all bodies were before codecs could be registered: this keeps peers which mislabel JSON working.
+func codecFor(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return jsonCodec{}, false
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	if c, ok := codecs.m[mediaType]; ok {
+		return withContentType(c, contentType), true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		if c, ok := codecs.m["application/"+mediaType[i+1:]]; ok {
+			return withContentType(c, contentType), true
+		}
+	}
+	return jsonCodec{}, false
+}
+
+// withContentType binds the full Content-Type to codecs whose decoding depends on its parameters (the charset of XML)
+func withContentType(c Codec, contentType string) Codec {
+	if _, ok := c.(xmlCodec); ok {
+		return xmlCodec{
+			contentType: contentType}
+	}
+	return c
+}
+
+// codecEncoder returns an encoderFunc for the Content-Type, along with the Content-Type to set on the body: the given
+// one if it has a codec, or otherwise the default (as the body is then encoded as JSON)
+func codecEncoder(contentType string) (encoderFunc, string) {
+	c, ok := codecFor(contentType)
+	if !ok {
+		contentType = defaultCodecMediaType
+	}
+	return func(w io.Writer, v interface{}) error {
+		b, err := c.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}, contentType
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	// Encoded the same way as encodeJSON, with a trailing newline
+	buf := &bytes.Buffer{}
+	err := encodeJSON(buf, v)
+	return buf.Bytes(), err
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+type xmlCodec struct {
+	contentType string
+}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (c xmlCodec) Unmarshal(b []byte, v interface{}) error {
+	return decodeXML(b, c.contentType, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := encodeProto(buf, v)
+	return buf.Bytes(), err
+}
+
+func (protobufCodec) Unmarshal(b []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(b, msg)
+}
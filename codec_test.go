@@ -0,0 +1,165 @@
+package typhon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// csvTestCodec encodes a []string as a single comma-separated line
+type csvTestCodec struct{}
+
+func (csvTestCodec) Marshal(v interface{}) ([]byte, error) {
+	fields, ok := v.([]string)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as CSV", v)
+	}
+	return []byte(strings.Join(fields, ",")), nil
+}
+
+func (csvTestCodec) Unmarshal(b []byte, v interface{}) error {
+	fields, ok := v.(*[]string)
+	if !ok {
+		return fmt.Errorf("cannot decode CSV into %T", v)
+	}
+	*fields = strings.Split(string(b), ",")
+	return nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	t.Parallel()
+	RegisterCodec("text/x-test-csv", csvTestCodec{})
+	assert.Panics(t, func() {
+		RegisterCodec("text/x-test-csv; charset=utf-8", csvTestCodec{})
+	})
+	assert.Panics(t, func() {
+		RegisterCodec("application/json", csvTestCodec{})
+	})
+	assert.Panics(t, func() {
+		RegisterCodec("not a media type", csvTestCodec{})
+	})
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Header.Set("Content-Type", "text/x-test-csv")
+	req.Encode([]string{"a", "b"})
+	b, err := req.BodyBytes(false)
+	require.NoError(t, err)
+	assert.Equal(t, "a,b", string(b))
+	fields := []string{}
+	require.NoError(t, req.Decode(&fields))
+	assert.Equal(t, []string{"a", "b"}, fields)
+
+	rsp := NewResponse(req)
+	rsp.Header.Set("Content-Type", "text/x-test-csv; charset=utf-8")
+	rsp.Encode([]string{"c"})
+	assert.Equal(t, "text/x-test-csv; charset=utf-8", rsp.Header.Get("Content-Type"))
+	require.NoError(t, rsp.Decode(&fields))
+	assert.Equal(t, []string{"c"}, fields)
+}
+
+func TestCodecDispatch(t *testing.T) {
+	t.Parallel()
+	// JSON is the default, including for structured syntax suffixes
+	req := NewRequest(nil, "POST", "/", map[string]string{"a": "b"})
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	m := map[string]string{}
+	require.NoError(t, req.Decode(&m))
+	assert.Equal(t, "b", m["a"])
+
+	// XML is registered by default
+	req = NewRequest(nil, "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/xml")
+	req.Encode(xmlTestPayment{ID: "p_1"})
+	p := xmlTestPayment{}
+	require.NoError(t, req.Decode(&p))
+	assert.Equal(t, "p_1", p.ID)
+
+	// Bodies of unknown types are treated as JSON, as they were before codecs were registered
+	rsp := NewResponse(req)
+	rsp.Header.Set("Content-Type", "text/plain")
+	rsp.Encode(m)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	m = map[string]string{}
+	rsp.Header.Set("Content-Type", "text/plain")
+	require.NoError(t, rsp.Decode(&m))
+	assert.Equal(t, "b", m["a"])
+
+	rsp = NewResponse(req)
+	rsp.Write([]byte("<html></html>"))
+	rsp.Header.Set("Content-Type", "text/html")
+	err := rsp.Decode(&m)
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadResponse))
+}
+
+func TestDecodeFallsBackToJSON(t *testing.T) {
+	t.Parallel()
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded", "not a/type;;"} {
+		req := NewRequest(nil, "POST", "/", nil)
+		req.Write([]byte(`{"a":"b"}`))
+		req.Header.Set("Content-Type", contentType)
+		m := map[string]string{}
+		require.NoError(t, req.Decode(&m), contentType)
+		assert.Equal(t, "b", m["a"], contentType)
+
+		rsp := NewResponse(req)
+		rsp.Write([]byte(`{"a":"c"}`))
+		rsp.Header.Set("Content-Type", contentType)
+		require.NoError(t, rsp.Decode(&m), contentType)
+		assert.Equal(t, "c", m["a"], contentType)
+	}
+}
+
+// upperJSONEncoder is a JSONEncoder which upper-cases strings, to show that it is used
+type upperJSONEncoder struct {
+	w io.Writer
+}
+
+func (e upperJSONEncoder) Encode(v interface{}) error {
+	_, err := fmt.Fprintf(e.w, "%q\n", strings.ToUpper(v.(string)))
+	return err
+}
+
+// Not parallel: these change package-level configuration
+func TestJSONEncoderConfiguration(t *testing.T) {
+	req := NewRequest(nil, "GET", "/", nil)
+	body := func(v interface{}) string {
+		rsp := req.Response(v)
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "\"a \\u003cb\\u003e \\u0026 c\"\n", body("a <b> & c"))
+
+	JSONEscapeHTML = false
+	defer func() { JSONEscapeHTML = true }()
+	assert.Equal(t, "\"a <b> & c\"\n", body("a <b> & c"))
+	r := NewRequest(nil, "POST", "/", "<b>")
+	b, err := r.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "\"<b>\"\n", string(b))
+
+	defaultEncoder := NewJSONEncoder
+	NewJSONEncoder = func(w io.Writer) JSONEncoder {
+		return upperJSONEncoder{w}
+	}
+	defer func() { NewJSONEncoder = defaultEncoder }()
+	assert.Equal(t, "\"HELLO\"\n", body("hello"))
+	rsp := NewResponse(req)
+	stream := rsp.NDJSON()
+	go func() {
+		defer stream.Close()
+		assert.NoError(t, stream.Encode("a"))
+	}()
+	b, err = ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "\"A\"\n", string(b))
+}
@@ -0,0 +1,212 @@
+package typhon
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/monzo/terrors"
+)
+
+// An EncodingWriter compresses the data written to it into the underlying writer. Flush writes out any data which
+// has been buffered, so that the reader can decode everything written so far; Close writes any remaining data and
+// ends the stream, but doesn't close the underlying writer.
+type EncodingWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// A ContentEncoding is a content coding (as named in Accept-Encoding and Content-Encoding headers) in which
+// CompressFilter can compress response bodies
+type ContentEncoding struct {
+	Name      string
+	NewWriter func(io.Writer) EncodingWriter
+}
+
+var (
+	gzipEncoding = ContentEncoding{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) EncodingWriter {
+			return gzip.NewWriter(w)
+		}}
+	brotliEncoding = ContentEncoding{
+		Name: "br",
+		NewWriter: func(w io.Writer) EncodingWriter {
+			return brotli.NewWriter(w)
+		}}
+
+	// encodings are the codings available to CompressFilter, in order of preference
+	encodings  = []ContentEncoding{brotliEncoding, gzipEncoding}
+	encodingsM sync.RWMutex
+)
+
+// RegisterEncoding makes a content coding available to CompressFilter, with a lower preference than those already
+// registered: when a client accepts several codings equally, the one registered first is used. Brotli ("br") and
+// gzip are registered by default, in that order. Registering a name which is already registered panics. Encodings are
+// typically registered at init time.
+func RegisterEncoding(enc ContentEncoding) {
+	name := strings.ToLower(enc.Name)
+	if name == "" || name == "identity" || name == "*" || enc.NewWriter == nil {
+		panic("typhon: invalid content encoding " + enc.Name)
+	}
+	encodingsM.Lock()
+	defer encodingsM.Unlock()
+	for _, e := range encodings {
+		if e.Name == name {
+			panic("typhon: content encoding " + name + " is already registered")
+		}
+	}
+	enc.Name = name
+	encodings = append(encodings[:len(encodings):len(encodings)], enc)
+}
+
+// CompressFilter is like GzipFilter, but compresses response bodies with whichever registered content coding (see
+// RegisterEncoding) the client prefers, according to the quality values in its Accept-Encoding header. Where the
+// client accepts several equally, brotli is preferred, then gzip. Bodies are sent uncompressed if the client accepts
+// none of them, or prefers identity.
+func CompressFilter(req Request, svc Service) Response {
+	return CompressFilterWithMinSize(DefaultGzipMinSize)(req, svc)
+}
+
+// CompressFilterWithMinSize returns a Filter like CompressFilter, but which will not compress buffered bodies smaller
+// than minSize bytes.
+func CompressFilterWithMinSize(minSize int) Filter {
+	return func(req Request, svc Service) Response {
+		encodingsM.RLock()
+		offered := encodings
+		encodingsM.RUnlock()
+		return compressFilter(req, svc, minSize, offered)
+	}
+}
+
+func compressFilter(req Request, svc Service, minSize int, offered []ContentEncoding) Response {
+	rsp := svc(req)
+	if rsp.Error != nil || rsp.Response == nil || rsp.Body == nil ||
+		!compressibleContentType(rsp.Header.Get("Content-Type")) {
+		return rsp
+	}
+	if rsp.Header.Get("Content-Encoding") != "" {
+		return rsp
+	}
+	rsp.Header.Add("Vary", "Accept-Encoding")
+	enc, ok := negotiateEncoding(req.Header.Get("Accept-Encoding"), offered)
+	if !ok || req.Method == http.MethodHead || rsp.StatusCode == http.StatusNoContent ||
+		rsp.StatusCode == http.StatusNotModified {
+		return rsp
+	}
+
+	if !isStreamingRsp(rsp) {
+		size := rsp.ContentLength
+		if buf, ok := rsp.Body.(*bufCloser); ok {
+			size = int64(buf.Len())
+		}
+		if size >= 0 && size < int64(minSize) {
+			return rsp
+		}
+		if size >= 0 {
+			body := rsp.Body
+			buf := &bufCloser{}
+			w := enc.NewWriter(buf)
+			_, err := io.Copy(w, body)
+			body.Close()
+			if err == nil {
+				err = w.Close()
+			}
+			if err != nil {
+				rsp.Error = terrors.Wrap(err, nil)
+				return rsp
+			}
+			rsp.Body = buf
+			rsp.ContentLength = int64(buf.Len())
+			rsp.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+			rsp.Header.Set("Content-Encoding", enc.Name)
+			return rsp
+		}
+	}
+
+	// Streaming (or unknown-length) bodies are compressed as they are read, flushing after each chunk so that the
+	// client sees data as soon as the service produces it
+	body := rsp.Body
+	out := Streamer()
+	go func() {
+		defer out.Close()
+		defer body.Close()
+		w := enc.NewWriter(out)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					return
+				}
+				if werr := w.Flush(); werr != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				out.(*streamer).CloseWithError(err) // propagate the failure to the client
+				return
+			}
+		}
+		w.Close()
+	}()
+	rsp.Body = out
+	rsp.ContentLength = -1
+	rsp.Header.Del("Content-Length")
+	rsp.Header.Set("Content-Encoding", enc.Name)
+	return rsp
+}
+
+// negotiateEncoding returns the offered coding which the given Accept-Encoding header prefers, or false if it accepts
+// none of them (or prefers identity). A coding with a quality of zero is never chosen, whether it is refused by name
+// or by a wildcard; codings not mentioned by name take the wildcard's quality, as does identity. Ties go to the coding
+// offered first.
+func negotiateEncoding(acceptEncoding string, offered []ContentEncoding) (ContentEncoding, bool) {
+	qs := make(map[string]float64)
+parts:
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(k), "q") {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				continue parts // A malformed quality can't be relied upon, so the coding is ignored
+			}
+		}
+		qs[name] = q
+	}
+
+	best, bestQ := ContentEncoding{}, 0.0
+	for _, enc := range offered {
+		// An explicit mention of the coding takes precedence over a wildcard
+		q, ok := qs[enc.Name]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	identityQ, ok := qs["identity"]
+	if !ok {
+		identityQ, ok = qs["*"]
+	}
+	if bestQ <= 0 || (ok && identityQ > bestQ) {
+		return ContentEncoding{}, false
+	}
+	return best, true
+}
@@ -0,0 +1,131 @@
+package typhon
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+	offered := []ContentEncoding{brotliEncoding, gzipEncoding}
+	cases := []struct {
+		acceptEncoding, expected string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"gzip;q=0.8, br;q=0.9", "br"},
+		{"gzip", "gzip"},
+		{"*", "br"},
+		{"br;q=0, *", "gzip"},
+		{"deflate", ""},
+		{"", ""},
+		{"br;q=0, gzip;q=0", ""},
+		{"gzip;q=0.5, identity", ""},
+		{"gzip, identity;q=0.5", "gzip"},
+		{"gzip; Q=0, br;q=0.5", "br"},
+		{"gzip;q = 0", ""},
+		{"gzip;q=high", ""},
+		{"*;q=0", ""},
+		{"gzip;q=0, *;q=0.5", "br"},
+		{"br;q=0.5, gzip;q=0.5, *", ""},
+		{"identity;q=0, *;q=0", ""},
+		{"identity;q=0, gzip;q=0.1", "gzip"}}
+	for _, c := range cases {
+		enc, ok := negotiateEncoding(c.acceptEncoding, offered)
+		assert.Equal(t, c.expected != "", ok, c.acceptEncoding)
+		assert.Equal(t, c.expected, enc.Name, c.acceptEncoding)
+	}
+}
+
+func TestCompressFilter(t *testing.T) {
+	t.Parallel()
+	large := strings.Repeat("typhon ", 1000)
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("Content-Type", "text/plain")
+		if req.URL.Path == "/stream" {
+			s := Streamer()
+			go func() {
+				defer s.Close()
+				s.Write([]byte(large))
+			}()
+			rsp.Body = s
+			return rsp
+		}
+		rsp.Write([]byte(large))
+		return rsp
+	}).Filter(CompressFilter)
+
+	for _, path := range []string{"/", "/stream"} {
+		req := NewRequest(nil, "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+		rsp := svc(req)
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, "br", rsp.Header.Get("Content-Encoding"), path)
+		assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"), path)
+		b, err := ioutil.ReadAll(brotli.NewReader(rsp.Body))
+		require.NoError(t, err)
+		assert.Equal(t, large, string(b), path)
+	}
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0.1, gzip")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "gzip", rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, large, gunzip(t, rsp.Body))
+
+	req = NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	rsp = svc(req)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"))
+}
+
+type passthroughEncodingWriter struct {
+	io.Writer
+}
+
+func (passthroughEncodingWriter) Flush() error { return nil }
+func (passthroughEncodingWriter) Close() error { return nil }
+
+func TestRegisterEncoding(t *testing.T) {
+	t.Parallel()
+	RegisterEncoding(ContentEncoding{
+		Name: "X-Test-Passthrough",
+		NewWriter: func(w io.Writer) EncodingWriter {
+			return passthroughEncodingWriter{w}
+		}})
+	assert.Panics(t, func() {
+		RegisterEncoding(ContentEncoding{
+			Name:      "gzip",
+			NewWriter: gzipEncoding.NewWriter})
+	})
+	assert.Panics(t, func() {
+		RegisterEncoding(ContentEncoding{
+			Name: "identity",
+			NewWriter: func(w io.Writer) EncodingWriter {
+				return passthroughEncodingWriter{w}
+			}})
+	})
+
+	large := strings.Repeat("typhon ", 1000)
+	svc := Service(func(req Request) Response {
+		return req.Response(large)
+	}).Filter(CompressFilter)
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "x-test-passthrough")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "x-test-passthrough", rsp.Header.Get("Content-Encoding"))
+	rsp.Header.Del("Content-Encoding")
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, large, body)
+}
@@ -0,0 +1,102 @@
+package typhon
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// MaxConcurrencyOptions configures the behaviour of a ConcurrencyLimiter
+type MaxConcurrencyOptions struct {
+	// Reject, if true, fails requests immediately when the limit is reached, rather than waiting for a slot
+	Reject bool
+}
+
+// A ConcurrencyLimiter limits the number of requests being handled at once by services it filters
+type ConcurrencyLimiter struct {
+	sem      chan struct{}
+	inFlight int64
+	avg      int64 // moving average of the time requests are handled for, in nanoseconds
+	opts     MaxConcurrencyOptions
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter permitting at most n requests at once. It panics if n isn't
+// positive.
+func NewConcurrencyLimiter(n int, opts MaxConcurrencyOptions) *ConcurrencyLimiter {
+	if n <= 0 {
+		panic("typhon: concurrency limit must be positive")
+	}
+	return &ConcurrencyLimiter{
+		sem:  make(chan struct{}, n),
+		opts: opts}
+}
+
+// MaxConcurrencyFilter returns a Filter which permits at most n requests to be handled at once. Further requests wait
+// for a slot, and fail with a service unavailable error if their context is done first. Rejected responses carry a
+// Retry-After header based on how long requests typically take to be handled.
+func MaxConcurrencyFilter(n int) Filter {
+	return NewConcurrencyLimiter(n, MaxConcurrencyOptions{}).Filter
+}
+
+// InFlight returns the number of requests currently being handled
+func (l *ConcurrencyLimiter) InFlight() int {
+	return int(atomic.LoadInt64(&l.inFlight))
+}
+
+// Filter is a Filter which applies the limit. The slot held by a request is released when the service returns (or
+// panics).
+func (l *ConcurrencyLimiter) Filter(req Request, svc Service) Response {
+	var done <-chan struct{}
+	if req.Context != nil {
+		done = req.Done()
+	}
+	if l.opts.Reject {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			return l.reject(req)
+		}
+	} else {
+		select {
+		case l.sem <- struct{}{}:
+		case <-done:
+			return l.reject(req)
+		}
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	start := time.Now()
+	defer func() {
+		l.observe(time.Since(start))
+		atomic.AddInt64(&l.inFlight, -1)
+		<-l.sem
+	}()
+	return svc(req)
+}
+
+// observe updates the moving average of handling time with that of a request
+func (l *ConcurrencyLimiter) observe(d time.Duration) {
+	for {
+		prev := atomic.LoadInt64(&l.avg)
+		next := int64(d)
+		if prev != 0 {
+			next = prev + (int64(d)-prev)/8
+		}
+		if atomic.CompareAndSwapInt64(&l.avg, prev, next) {
+			return
+		}
+	}
+}
+
+func (l *ConcurrencyLimiter) reject(req Request) Response {
+	rsp := NewResponse(req)
+	rsp.Error = terrors.New(ErrServiceUnavailable+".concurrency_limit", "Too many concurrent requests", nil)
+	// A slot is likely to free up within the time a request typically takes
+	d := time.Duration(atomic.LoadInt64(&l.avg))
+	if d <= 0 {
+		d = time.Second
+	}
+	rsp.SetRetryAfter(d)
+	return rsp
+}
@@ -0,0 +1,81 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/panic" {
+			panic("boom")
+		}
+		<-release
+		return req.Response(nil)
+	})
+
+	l := NewConcurrencyLimiter(1, MaxConcurrencyOptions{
+		Reject: true})
+	limited := svc.Filter(l.Filter)
+	f := NewRequest(nil, "GET", "/", nil).SendVia(limited)
+	for l.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	rsp := limited(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorStatusCode(rsp.Error))
+	assert.Equal(t, "1", rsp.Header.Get("Retry-After"))
+	close(release)
+	require.NoError(t, f.Response().Error)
+	assert.Equal(t, 0, l.InFlight())
+
+	// Panics release the slot
+	recovering := svc.Filter(l.Filter).Filter(RecoverFilter(nil))
+	recovering(NewRequest(nil, "GET", "/panic", nil))
+	assert.Equal(t, 0, l.InFlight())
+	require.NoError(t, limited(NewRequest(nil, "GET", "/", nil)).Error)
+}
+
+func TestMaxConcurrencyFilterBlocks(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		<-release
+		return req.Response(nil)
+	}).Filter(MaxConcurrencyFilter(1))
+
+	f1 := NewRequest(nil, "GET", "/", nil).SendVia(svc)
+	time.Sleep(5 * time.Millisecond)
+
+	// A request which can't get a slot before its deadline fails
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rsp := svc(NewRequest(ctx, "GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorStatusCode(rsp.Error))
+
+	// One that waits long enough succeeds
+	f2 := NewRequest(nil, "GET", "/", nil).SendVia(svc)
+	select {
+	case <-f2.WaitC():
+		assert.Fail(t, "request did not wait for a slot")
+	case <-time.After(5 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, f1.Response().Error)
+	require.NoError(t, f2.Response().Error)
+}
+
+func TestConcurrencyLimiterInvalidLimit(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int{0, -1} {
+		assert.Panics(t, func() {
+			MaxConcurrencyFilter(n)
+		}, "limit %d", n)
+	}
+}
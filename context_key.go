@@ -0,0 +1,40 @@
+package typhon
+
+import (
+	"context"
+)
+
+// A ContextKey identifies a request-scoped value, such as the authenticated subject of a request or its tenant. Each
+// key created by NewContextKey is distinct from every other (even one with the same name), so packages can't collide.
+// Typically, a filter's package exports a key, the filter sets its value with Request.WithValue, and handlers read it
+// with the key's Value method.
+type ContextKey struct {
+	name string
+}
+
+// NewContextKey returns a new, distinct ContextKey. The name is only used to describe the key.
+func NewContextKey(name string) *ContextKey {
+	return &ContextKey{
+		name: name}
+}
+
+func (k *ContextKey) String() string {
+	return "typhon.ContextKey(" + k.name + ")"
+}
+
+// Value returns the key's value in the context, and whether it is set
+func (k *ContextKey) Value(ctx context.Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	v := ctx.Value(k)
+	return v, v != nil
+}
+
+// WithValue sets the value of key in the request's context, for the remainder of its processing
+func (r *Request) WithValue(key *ContextKey, v interface{}) {
+	if r.Context == nil {
+		r.Context = context.Background()
+	}
+	r.Context = context.WithValue(r.Context, key, v)
+}
@@ -0,0 +1,41 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextKey(t *testing.T) {
+	t.Parallel()
+	tenant := NewContextKey("tenant")
+	other := NewContextKey("tenant")
+	assert.Equal(t, "typhon.ContextKey(tenant)", tenant.String())
+
+	filter := func(req Request, svc Service) Response {
+		req.WithValue(tenant, "acme")
+		return svc(req)
+	}
+	svc := Service(func(req Request) Response {
+		v, ok := tenant.Value(req)
+		require.True(t, ok)
+		assert.Equal(t, "acme", v)
+		_, ok = other.Value(req)
+		assert.False(t, ok, "keys with the same name should be distinct")
+		return NewResponse(req)
+	}).Filter(filter)
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+
+	_, ok := tenant.Value(context.Background())
+	assert.False(t, ok)
+	_, ok = tenant.Value(nil)
+	assert.False(t, ok)
+
+	req := Request{}
+	req.WithValue(tenant, 1)
+	v, _ := tenant.Value(req.Context)
+	assert.Equal(t, 1, v)
+}
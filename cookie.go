@@ -0,0 +1,29 @@
+package typhon
+
+import "net/http"
+
+// Cookies sent with a request are read with the methods of the embedded http.Request: Request.Cookie(name) and
+// Request.Cookies().
+
+// SetCookie adds a Set-Cookie header to the response, as http.SetCookie does. Invalid cookies are silently dropped.
+func (r *Response) SetCookie(cookie *http.Cookie) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	if v := cookie.String(); v != "" {
+		r.Header.Add("Set-Cookie", v)
+	}
+}
+
+// NewSessionCookie returns a cookie with defaults suitable for a session identifier: it is scoped to the whole site,
+// inaccessible to scripts (HttpOnly), only sent over HTTPS (Secure), not sent with cross-site subrequests
+// (SameSite=Lax), and expires when the browser session ends. The returned cookie may be modified before use.
+func NewSessionCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode}
+}
@@ -0,0 +1,40 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookies(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		c, err := req.Cookie("theme")
+		if err != nil {
+			rsp.Error = err
+			return rsp
+		}
+		rsp.SetCookie(NewSessionCookie("session", "abc"))
+		rsp.SetCookie(&http.Cookie{Name: "theme", Value: c.Value, MaxAge: 3600})
+		rsp.SetCookie(&http.Cookie{Name: "invalid name"})
+		return rsp
+	})
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{
+		"session=abc; Path=/; HttpOnly; Secure; SameSite=Lax",
+		"theme=dark; Max-Age=3600"}, rsp.Header["Set-Cookie"])
+	cookies := rsp.Cookies()
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "abc", cookies[0].Value)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+	rsp = svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.ErrNoCookie, rsp.Error)
+}
@@ -0,0 +1,119 @@
+package typhon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the behaviour of CORSFilter
+type CORSOptions struct {
+	// AllowedOrigins are the origins permitted to make cross-origin requests. "*" permits any origin.
+	AllowedOrigins []string
+	// AllowOrigin, if non-nil, is consulted for origins not in AllowedOrigins
+	AllowOrigin func(origin string) bool
+	// AllowedMethods are the methods permitted in cross-origin requests. If empty, GET, HEAD, POST, PUT, PATCH and
+	// DELETE are.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers permitted in cross-origin requests. If empty, whichever headers are
+	// requested in a preflight are permitted.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers which browsers may make available to the requesting script
+	ExposedHeaders []string
+	// AllowCredentials permits requests with credentials (cookies or HTTP authentication). With this enabled, the
+	// request's origin is always reflected rather than responding with a wildcard.
+	AllowCredentials bool
+	// MaxAge, if non-zero, is how long browsers may cache the result of a preflight request
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete}
+
+// CORSFilter returns a Filter which implements Cross-Origin Resource Sharing. Preflight requests are answered
+// directly; other cross-origin requests are passed to the service and the appropriate headers added to its response.
+// Requests from origins which are not allowed are not rejected, but their responses lack the headers which would
+// permit a browser to use them.
+func CORSFilter(opts CORSOptions) Filter {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	anyOrigin := false
+	origins := make(map[string]bool, len(opts.AllowedOrigins))
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(o)] = true
+	}
+	allowed := func(origin string) bool {
+		return anyOrigin || origins[strings.ToLower(origin)] || (opts.AllowOrigin != nil && opts.AllowOrigin(origin))
+	}
+	// Setting an origin-specific header means caches must take the origin into account
+	setOrigin := func(h http.Header, origin string) {
+		if anyOrigin && !opts.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		if opts.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+
+	return func(req Request, svc Service) Response {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			return svc(req)
+		}
+
+		// Preflight
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			rsp := NewResponse(req)
+			rsp.StatusCode = http.StatusNoContent
+			rsp.Header.Add("Vary", "Origin")
+			rsp.Header.Add("Vary", "Access-Control-Request-Method")
+			rsp.Header.Add("Vary", "Access-Control-Request-Headers")
+			if !allowed(origin) {
+				return rsp
+			}
+			setOrigin(rsp.Header, origin)
+			rsp.Header.Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				rsp.Header.Set("Access-Control-Allow-Headers", headers)
+			} else if requested := req.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				rsp.Header.Set("Access-Control-Allow-Headers", requested)
+			}
+			if opts.MaxAge > 0 {
+				rsp.Header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+			}
+			return rsp
+		}
+
+		rsp := svc(req)
+		if rsp.Response == nil {
+			rsp.Response = newHTTPResponse(req)
+		}
+		if !allowed(origin) {
+			if !anyOrigin {
+				rsp.Header.Add("Vary", "Origin")
+			}
+			return rsp
+		}
+		setOrigin(rsp.Header, origin)
+		if exposed != "" {
+			rsp.Header.Set("Access-Control-Expose-Headers", exposed)
+		}
+		return rsp
+	}
+}
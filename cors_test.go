@@ -0,0 +1,72 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSFilter(t *testing.T) {
+	t.Parallel()
+	called := 0
+	svc := Service(func(req Request) Response {
+		called++
+		return req.Response("ok")
+	})
+	cors := svc.Filter(CORSFilter(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute}))
+
+	preflight := func(svc Service, origin string) Response {
+		req := NewRequest(nil, "OPTIONS", "/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+		return svc(req)
+	}
+
+	rsp := preflight(cors, "https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Equal(t, "https://app.example.com", rsp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rsp.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, HEAD, POST, PUT, PATCH, DELETE", rsp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, Authorization", rsp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rsp.Header.Get("Access-Control-Max-Age"))
+	assert.Equal(t, 0, called)
+
+	// Disallowed origins get a response, but without the allow headers
+	rsp = preflight(cors, "https://evil.example.com")
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Empty(t, rsp.Header.Get("Access-Control-Allow-Origin"))
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rsp = cors(req)
+	assert.Equal(t, 1, called)
+	assert.Equal(t, "https://app.example.com", rsp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", rsp.Header.Get("Access-Control-Expose-Headers"))
+	assert.Contains(t, rsp.Header["Vary"], "Origin")
+
+	req = NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rsp = cors(req)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Empty(t, rsp.Header.Get("Access-Control-Allow-Origin"))
+
+	// Wildcards, reflecting requested headers
+	cors = svc.Filter(CORSFilter(CORSOptions{
+		AllowedOrigins: []string{"*"}}))
+	rsp = preflight(cors, "https://anywhere.example.com")
+	assert.Equal(t, "*", rsp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Custom", rsp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, rsp.Header.Get("Access-Control-Allow-Credentials"))
+
+	// Requests without an Origin are untouched
+	rsp = cors(NewRequest(nil, "GET", "/", nil))
+	assert.Empty(t, rsp.Header.Get("Access-Control-Allow-Origin"))
+}
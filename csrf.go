@@ -0,0 +1,166 @@
+package typhon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/monzo/terrors"
+)
+
+const (
+	// DefaultCSRFCookieName is the cookie in which CSRFFilter stores the token by default
+	DefaultCSRFCookieName = "csrf_token"
+	// DefaultCSRFHeader is the request header from which CSRFFilter reads the submitted token by default
+	DefaultCSRFHeader = "X-CSRF-Token"
+	// DefaultCSRFFormField is the form field from which CSRFFilter reads the submitted token by default, if the header
+	// is absent
+	DefaultCSRFFormField = "csrf_token"
+	// csrfTokenLength is the number of random bytes in a token
+	csrfTokenLength = 32
+	// csrfMaxFormSize is the largest form body from which CSRFFilter will read a token
+	csrfMaxFormSize = 1 << 20
+)
+
+// CSRFOptions configures CSRFFilter. Zero values are replaced with defaults.
+type CSRFOptions struct {
+	CookieName string
+	Header     string
+	FormField  string
+	// ExemptPaths are patterns (as path.Match, eg. "/webhooks/*") of paths which are not checked, for endpoints which
+	// don't authenticate with cookies
+	ExemptPaths []string
+	// Insecure omits the Secure attribute from the cookie, so it can be used over plain HTTP during development
+	Insecure bool
+}
+
+type csrfTokenContextKeyType struct{}
+
+var csrfTokenContextKey = csrfTokenContextKeyType{}
+
+// CSRFToken returns the token which must be submitted with state-changing requests, as set in the context by
+// CSRFFilter. It is typically rendered into a page, as a hidden form field or a <meta> tag read by scripts.
+func CSRFToken(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	token, _ := ctx.Value(csrfTokenContextKey).(string)
+	return token
+}
+
+// CSRFFilter returns a Filter which protects cookie-authenticated services from cross-site request forgery, using
+// the double-submit cookie pattern. Each client is issued a random token in a cookie; requests with state-changing
+// methods (anything but GET, HEAD, OPTIONS and TRACE) are rejected with a 403 unless they also submit the token in a
+// header, or in a field of a form-encoded body. A cross-site page can make the browser send the cookie, but can't
+// read it to submit the token.
+//
+// The cookie is a session cookie, so the token lasts until the browser is closed. To rotate it (for example, when a
+// user logs in), the service can expire the cookie in its response; a new token is issued with the next request.
+//
+// The cookie is SameSite=Lax, which by itself stops most browsers sending it with cross-site POSTs. The token check
+// still matters: for older browsers, for state-changing GETs (which should be avoided), and because SameSite treats
+// sibling subdomains as the same site.
+func CSRFFilter(opts CSRFOptions) Filter {
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCSRFCookieName
+	}
+	if opts.Header == "" {
+		opts.Header = DefaultCSRFHeader
+	}
+	if opts.FormField == "" {
+		opts.FormField = DefaultCSRFFormField
+	}
+	return func(req Request, svc Service) Response {
+		token, issue := "", false
+		if c, err := req.Cookie(opts.CookieName); err == nil && validCSRFToken(c.Value) {
+			token = c.Value
+		} else if token, err = newCSRFToken(); err != nil {
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		} else {
+			issue = true
+		}
+
+		if !IsSafe(req) && !opts.exempt(req) {
+			// Without a cookie, there is nothing to check a submitted token against
+			if issue || subtle.ConstantTimeCompare([]byte(opts.submitted(&req)), []byte(token)) != 1 {
+				rsp := Response{
+					Error: terrors.Forbidden("csrf", "Missing or invalid CSRF token", nil)}
+				if issue {
+					rsp.SetCookie(opts.cookie(token))
+				}
+				return rsp
+			}
+		}
+
+		if req.Context == nil {
+			req.Context = context.Background()
+		}
+		req.Context = context.WithValue(req.Context, csrfTokenContextKey, token)
+		rsp := svc(req)
+		if issue {
+			rsp.SetCookie(opts.cookie(token))
+		}
+		return rsp
+	}
+}
+
+func (o CSRFOptions) cookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     o.CookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !o.Insecure,
+		SameSite: http.SameSiteLaxMode}
+}
+
+func (o CSRFOptions) exempt(req Request) bool {
+	if req.URL == nil {
+		return false
+	}
+	for _, pattern := range o.ExemptPaths {
+		if ok, _ := path.Match(pattern, req.URL.Path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// submitted returns the token submitted with the request, from its header or form body. The body is left intact.
+func (o CSRFOptions) submitted(req *Request) string {
+	if token := req.Header.Get(o.Header); token != "" {
+		return token
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	b, err := req.PeekBody(csrfMaxFormSize)
+	if err != nil {
+		return ""
+	}
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return ""
+	}
+	return values.Get(o.FormField)
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == csrfTokenLength
+}
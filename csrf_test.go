@@ -0,0 +1,89 @@
+package typhon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		if req.Method == http.MethodPost {
+			// The form body is still intact
+			b, err := req.BodyBytes(true)
+			require.NoError(t, err)
+			values, _ := url.ParseQuery(string(b))
+			return req.Response(values.Get("amount"))
+		}
+		return req.Response(CSRFToken(req))
+	}).Filter(CSRFFilter(CSRFOptions{
+		ExemptPaths: []string{"/webhooks/*"}})).Filter(ErrorFilter)
+
+	// A safe request is issued a token
+	rsp := svc(NewRequest(nil, "GET", "/form", nil))
+	require.NoError(t, rsp.Error)
+	cookies := rsp.Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, "csrf_token", cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	token := ""
+	require.NoError(t, rsp.Decode(&token))
+	assert.Equal(t, cookie.Value, token)
+
+	// Even to a request without a context
+	bare := NewRequest(nil, "GET", "/form", nil)
+	bare.Context = nil
+	rsp = svc(bare)
+	require.NoError(t, rsp.Error)
+	assert.Len(t, rsp.Cookies(), 1)
+
+	post := func(body, header string, withCookie bool) Response {
+		req := NewRequest(nil, "POST", "/transfer", nil)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		if withCookie {
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+		}
+		return svc(req)
+	}
+
+	// Submitted in the header or the form
+	amount := ""
+	rsp = post("amount=10", token, true)
+	require.NoError(t, rsp.Error)
+	require.NoError(t, rsp.Decode(&amount))
+	assert.Equal(t, "10", amount)
+	assert.Empty(t, rsp.Cookies()) // already issued
+	rsp = post("amount=20&csrf_token="+token, "", true)
+	require.NoError(t, rsp.Error)
+	require.NoError(t, rsp.Decode(&amount))
+	assert.Equal(t, "20", amount)
+
+	// Missing or mismatched
+	for _, rsp := range []Response{
+		post("amount=10", "", true),
+		post("amount=10", "wrong", true),
+		post("amount=10", token, false)} {
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		assert.True(t, terrors.PrefixMatches(rsp.Error, "forbidden.csrf"))
+	}
+
+	// Exempt paths aren't checked
+	req := NewRequest(nil, "POST", "/webhooks/stripe", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("amount=30"))
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+}
@@ -0,0 +1,105 @@
+package typhon
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// DefaultDeadlineHeader is the header used to carry deadlines by DeadlineFilter and PropagateDeadlineFilter. Its
+// value is the remaining time budget of the request, in whole milliseconds; a relative budget (rather than an
+// absolute time) means the deadline isn't affected by clock skew between hosts.
+const DefaultDeadlineHeader = "X-Request-Deadline"
+
+// DeadlineOptions configures DeadlineFilterWithOptions and PropagateDeadlineFilterWithOptions. Zero values are
+// replaced with defaults.
+type DeadlineOptions struct {
+	// Header carries the deadline (default DefaultDeadlineHeader)
+	Header string
+	// SafetyMargin is subtracted from the remaining budget when it is propagated, allowing for the time taken to
+	// transmit the request and to return the response. It is ignored by the server-side filter.
+	SafetyMargin time.Duration
+}
+
+func (o DeadlineOptions) header() string {
+	if o.Header == "" {
+		return DefaultDeadlineHeader
+	}
+	return o.Header
+}
+
+// DeadlineFilter is a server-side Filter which applies the deadline carried in the X-Request-Deadline header to
+// the request's context, so work on behalf of a caller which has given up is cancelled. A deadline already on the
+// context is only ever shortened. Requests without a valid header are passed through untouched.
+func DeadlineFilter(req Request, svc Service) Response {
+	return deadlineFilter(req, svc, DeadlineOptions{})
+}
+
+// DeadlineFilterWithOptions is like DeadlineFilter, but configured by the given options
+func DeadlineFilterWithOptions(opts DeadlineOptions) Filter {
+	return func(req Request, svc Service) Response {
+		return deadlineFilter(req, svc, opts)
+	}
+}
+
+func deadlineFilter(req Request, svc Service, opts DeadlineOptions) Response {
+	budget, ok := parseDeadline(req.Header.Get(opts.header()))
+	if !ok {
+		return svc(req)
+	}
+	parent := req.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, budget)
+	req.Context = ctx
+	rsp := svc(req)
+	releaseWithBody(ctx, rsp, cancel)
+	return rsp
+}
+
+// parseDeadline parses a budget in milliseconds, as carried by the deadline header
+func parseDeadline(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 || ms > int64(time.Duration(1<<63-1)/time.Millisecond) {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// PropagateDeadlineFilter is a client-side Filter which sets the X-Request-Deadline header of outgoing requests to
+// the budget remaining before their context's deadline, unless it is already set. If the budget is already spent,
+// the request fails immediately with a timeout error rather than being sent.
+func PropagateDeadlineFilter(req Request, svc Service) Response {
+	return propagateDeadlineFilter(req, svc, DeadlineOptions{})
+}
+
+// PropagateDeadlineFilterWithOptions is like PropagateDeadlineFilter, but configured by the given options
+func PropagateDeadlineFilterWithOptions(opts DeadlineOptions) Filter {
+	return func(req Request, svc Service) Response {
+		return propagateDeadlineFilter(req, svc, opts)
+	}
+}
+
+func propagateDeadlineFilter(req Request, svc Service, opts DeadlineOptions) Response {
+	if req.Context == nil {
+		return svc(req)
+	}
+	deadline, ok := req.Deadline()
+	if !ok || req.Header.Get(opts.header()) != "" {
+		return svc(req)
+	}
+	budget := time.Until(deadline) - opts.SafetyMargin
+	if budget < time.Millisecond {
+		return Response{
+			Error: terrors.Timeout("deadline_exceeded", "Request deadline exceeded before sending", nil)}
+	}
+	req.Header = cloneHeader(req.Header)
+	req.Header.Set(opts.header(), strconv.FormatInt(int64(budget/time.Millisecond), 10))
+	return svc(req)
+}
@@ -0,0 +1,91 @@
+package typhon
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlineFilter(t *testing.T) {
+	t.Parallel()
+	var remaining time.Duration
+	var header string
+	downstream := Service(func(req Request) Response {
+		header = req.Header.Get("X-Request-Deadline")
+		deadline, ok := req.Deadline()
+		require.True(t, ok)
+		remaining = time.Until(deadline)
+		return req.Response(nil)
+	}).Filter(DeadlineFilter)
+	client := downstream.Filter(PropagateDeadlineFilterWithOptions(DeadlineOptions{
+		SafetyMargin: 500 * time.Millisecond}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rsp := NewRequest(ctx, "GET", "/", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	ms, err := strconv.Atoi(header)
+	require.NoError(t, err)
+	assert.True(t, ms > 1000 && ms <= 1500, header)
+	assert.True(t, remaining > time.Second && remaining <= 1500*time.Millisecond, remaining)
+
+	// Without a deadline, nothing is propagated or applied
+	header = "unset"
+	svc := Service(func(req Request) Response {
+		header = req.Header.Get("X-Request-Deadline")
+		_, ok := req.Deadline()
+		assert.False(t, ok)
+		return req.Response(nil)
+	}).Filter(DeadlineFilter).Filter(PropagateDeadlineFilter)
+	require.NoError(t, svc(NewRequest(context.Background(), "GET", "/", nil)).Error)
+	assert.Empty(t, header)
+
+	// Nor is an invalid header applied
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Request-Deadline", "-5")
+	require.NoError(t, svc(req).Error)
+
+	// A spent budget fails without sending
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	called := false
+	rsp = NewRequest(ctx, "GET", "/", nil).SendVia(Service(func(req Request) Response {
+		called = true
+		return req.Response(nil)
+	}).Filter(PropagateDeadlineFilterWithOptions(DeadlineOptions{
+		SafetyMargin: time.Second}))).Response()
+	assert.False(t, called)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrTimeout))
+}
+
+func TestDeadlineFilterHeader(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		deadline, ok := req.Deadline()
+		require.True(t, ok)
+		return req.Response(time.Until(deadline) <= 50*time.Millisecond)
+	}).Filter(DeadlineFilterWithOptions(DeadlineOptions{
+		Header: "X-Budget"}))
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Budget", "50")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	within := false
+	require.NoError(t, rsp.Decode(&within))
+	assert.True(t, within)
+
+	// An existing, shorter deadline is kept
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req = NewRequest(ctx, "GET", "/", nil)
+	req.Header.Set("X-Budget", "60000")
+	rsp = svc(req)
+	require.NoError(t, rsp.Decode(&within))
+	assert.True(t, within)
+}
@@ -0,0 +1,70 @@
+// Package debug mounts the standard library's profiling (net/http/pprof) and exported variable (expvar) handlers on
+// a Typhon Router.
+//
+// It is a separate package because importing net/http/pprof and expvar registers their handlers on
+// http.DefaultServeMux; services which don't import this package don't get them.
+package debug
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/monzo/typhon"
+)
+
+// DefaultPrefix is the path prefix under which Mount registers routes by default
+const DefaultPrefix = "/debug"
+
+// Options configures the routes registered by Mount
+type Options struct {
+	// Prefix is prepended to the paths of the debug routes (default DefaultPrefix)
+	Prefix string
+	// Auth, if set, is applied to all of the debug routes; for example, typhon.BasicAuthFilter. Requests it rejects
+	// are not passed on to the debug handlers.
+	Auth typhon.Filter
+}
+
+// Mount registers the net/http/pprof profiling handlers and the expvar handler on the Router, at:
+//
+//	<prefix>/pprof/         index of the available profiles
+//	<prefix>/pprof/<name>   named profiles (eg. heap, goroutine, allocs), as well as cmdline, profile, symbol, trace
+//	<prefix>/vars           exported variables, as JSON
+//
+// These expose sensitive details of the process, and profiling has a performance cost, so they should not be reachable
+// by the public. Either set an Auth filter, or (preferably) mount them on a separate Router which is served only on a
+// private listener, for example:
+//
+//	r := typhon.NewRouter()
+//	debug.Mount(&r, debug.Options{})
+//	typhon.Listen(r.Serve(), "localhost:6060")
+func Mount(r *typhon.Router, opts Options) {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	var filters []typhon.Filter
+	if opts.Auth != nil {
+		filters = append(filters, opts.Auth)
+	}
+	g := r.Group(opts.Prefix, filters...)
+	g.GET("/pprof/", handlerService(http.HandlerFunc(pprof.Index)))
+	g.GET("/pprof/cmdline", handlerService(http.HandlerFunc(pprof.Cmdline)))
+	g.GET("/pprof/profile", handlerService(http.HandlerFunc(pprof.Profile)))
+	g.GET("/pprof/symbol", handlerService(http.HandlerFunc(pprof.Symbol)))
+	g.POST("/pprof/symbol", handlerService(http.HandlerFunc(pprof.Symbol)))
+	g.GET("/pprof/trace", handlerService(http.HandlerFunc(pprof.Trace)))
+	g.GET("/pprof/:name", func(req typhon.Request) typhon.Response {
+		// pprof.Index only resolves named profiles beneath /debug/pprof/, so they're looked up here instead
+		return handlerService(pprof.Handler(req.Param("name")))(req)
+	})
+	g.GET("/vars", handlerService(expvar.Handler()))
+}
+
+// handlerService adapts a net/http Handler into a Service. The handler's response is buffered.
+func handlerService(h http.Handler) typhon.Service {
+	return func(req typhon.Request) typhon.Response {
+		rsp := typhon.NewResponse(req)
+		h.ServeHTTP(rsp.Writer(), req.Request.WithContext(req))
+		return rsp
+	}
+}
@@ -0,0 +1,58 @@
+package debug
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/monzo/typhon"
+)
+
+func TestMount(t *testing.T) {
+	t.Parallel()
+	router := typhon.NewRouter()
+	Mount(&router, Options{
+		Prefix: "/internal",
+		Auth:   typhon.BasicAuthFilter(typhon.BasicAuthCredentials("admin", "secret"))})
+	svc := router.Serve().Filter(typhon.ErrorFilter)
+
+	get := func(path string, auth bool) typhon.Response {
+		req := typhon.NewRequest(context.Background(), "GET", path, nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		return svc(req)
+	}
+
+	rsp := get("/internal/vars", false)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	rsp = get("/internal/vars", true)
+	require.NoError(t, rsp.Error)
+	vars := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&vars))
+	assert.Contains(t, vars, "memstats")
+
+	rsp = get("/internal/pprof/", true)
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "goroutine")
+
+	rsp = get("/internal/pprof/goroutine?debug=1", true)
+	require.NoError(t, rsp.Error)
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "goroutine profile:")
+
+	rsp = get("/internal/pprof/cmdline", true)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// Nothing is mounted at the default prefix
+	rsp = get("/debug/vars", true)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+}
@@ -1,6 +1,7 @@
 package typhon
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -163,6 +165,84 @@ func (suite *e2eSuite) TestCancellation() {
 	}
 }
 
+func (suite *e2eSuite) TestRequestTimeout() {
+	svc := Service(func(req Request) Response {
+		select {
+		case <-req.Done():
+		case <-time.After(time.Second):
+		}
+		return req.Response("too late")
+	})
+	svc = svc.Filter(ErrorFilter)
+	s := suite.serve(svc)
+	defer s.Stop()
+
+	req := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil)
+	req.SetTimeout(20 * time.Millisecond)
+	start := time.Now()
+	rsp := req.Send().Response()
+	suite.Assert().True(time.Since(start) < 500*time.Millisecond)
+	suite.Require().Error(rsp.Error)
+	suite.Assert().True(terrors.PrefixMatches(rsp.Error, terrors.ErrTimeout), rsp.Error.Error())
+
+	// A buffered response releases the context immediately
+	req = NewRequest(nil, "GET", "/", nil)
+	req.SetTimeout(time.Minute)
+	rsp = req.SendVia(func(req Request) Response {
+		return req.Response("fast")
+	}).Response()
+	suite.Require().NoError(rsp.Error)
+	suite.Assert().Error(req.Err())
+}
+
+func (suite *e2eSuite) TestGzipDecompression() {
+	defer leaktest.Check(suite.T())()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body := strings.Repeat("abc", 1000)
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		if req.URL.Path == "/malformed" {
+			rsp.Header.Set("Content-Encoding", "gzip")
+		}
+		rsp.Write([]byte(body))
+		return rsp
+	})
+	svc = svc.Filter(GzipFilter).Filter(ErrorFilter)
+	s := suite.serve(svc)
+	defer s.Stop()
+
+	req := NewRequest(ctx, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil)
+	rsp := req.Send().Response()
+	suite.Require().NoError(rsp.Error)
+	suite.Assert().Empty(rsp.Header.Get("Content-Encoding"))
+	suite.Assert().Equal(int64(-1), rsp.ContentLength)
+	b, err := rsp.BodyBytes(true)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(body, string(b))
+	suite.Assert().Empty(req.Header.Get("Accept-Encoding")) // the caller's request is not modified
+
+	// Explicitly asking for gzip leaves decompression to the caller
+	req = NewRequest(ctx, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp = req.Send().Response()
+	suite.Require().NoError(rsp.Error)
+	suite.Assert().Equal("gzip", rsp.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(rsp.Body)
+	suite.Require().NoError(err)
+	b, err = ioutil.ReadAll(gz)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(body, string(b))
+	rsp.Body.Close()
+
+	req = NewRequest(ctx, "GET", fmt.Sprintf("http://%s/malformed", s.Listener().Addr()), nil)
+	rsp = req.Send().Response()
+	suite.Require().NoError(rsp.Error)
+	_, err = rsp.BodyBytes(true)
+	suite.Assert().Error(err)
+}
+
 func (suite *e2eSuite) TestNoFollowRedirect() {
 	defer leaktest.Check(suite.T())()
 	ctx, cancel := context.WithCancel(context.Background())
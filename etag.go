@@ -0,0 +1,90 @@
+package typhon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// ETagFilter is a Filter which sets a strong ETag on successful responses to GET and HEAD requests, computed from the
+// response body (unless the service has already set one). If the request's If-None-Match header matches the ETag,
+// the response is replaced with a 304 Not Modified without a body. Streaming responses are not buffered and so are
+// left alone.
+func ETagFilter(req Request, svc Service) Response {
+	rsp := svc(req)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return rsp
+	}
+	if rsp.Error != nil || rsp.Response == nil || rsp.StatusCode < 200 || rsp.StatusCode > 299 || isStreamingRsp(rsp) {
+		return rsp
+	}
+
+	etag := rsp.Header.Get("ETag")
+	if etag == "" {
+		if rsp.Body == nil {
+			return rsp
+		}
+		b, err := rsp.BodyBytes(false)
+		if err != nil {
+			rsp.Error = terrors.Wrap(err, nil)
+			return rsp
+		}
+		sum := sha256.Sum256(b)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		rsp.Header.Set("ETag", etag)
+	}
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" && etagMatchesWeak(inm, etag) {
+		if rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		rsp.StatusCode = http.StatusNotModified
+		rsp.Body = &bufCloser{}
+		rsp.ContentLength = 0
+		rsp.Header.Del("Content-Length")
+		rsp.Header.Del("Content-Type")
+	}
+	return rsp
+}
+
+// etagMatchesWeak returns whether any of the entity tags in the list (an If-None-Match header) matches the given tag
+// using the weak comparison function of RFC 7232: ie. opaque tags must match, but weakness is ignored.
+func etagMatchesWeak(list, etag string) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+		tag, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+		list = rest
+	}
+	return false
+}
+
+// scanETag reads an entity tag from the start of s, returning it and the remainder of the string
+func scanETag(s string) (etag, rest string, ok bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) <= start || s[start] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	end += start + 2
+	return s[:end], s[end:], true
+}
@@ -0,0 +1,63 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"a": "b"})
+	}).Filter(ETagFilter)
+
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	etag := rsp.Header.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "{\"a\":\"b\"}\n", string(b))
+
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("If-None-Match", inm)
+		rsp = svc(req)
+		assert.Equal(t, http.StatusNotModified, rsp.StatusCode, inm)
+		assert.Equal(t, etag, rsp.Header.Get("ETag"), inm)
+		b, _ := rsp.BodyBytes(true)
+		assert.Empty(t, b, inm)
+	}
+
+	for _, inm := range []string{`"other"`, `W/"other"`, "garbage"} {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("If-None-Match", inm)
+		rsp = svc(req)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode, inm)
+	}
+
+	// Only GET and HEAD are affected
+	rsp = svc(NewRequest(nil, "POST", "/", nil))
+	assert.Empty(t, rsp.Header.Get("ETag"))
+}
+
+func TestETagFilterStreaming(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		s := Streamer()
+		go func() {
+			s.Write([]byte("streamed"))
+			s.Close()
+		}()
+		rsp.Body = s
+		return rsp
+	}).Filter(ETagFilter)
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.Empty(t, rsp.Header.Get("ETag"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "streamed", string(b))
+}
@@ -0,0 +1,55 @@
+package typhon
+
+import (
+	"net/http"
+)
+
+// DefaultShouldFallback returns whether a response indicates a server-side failure: an error which maps to a 5xx
+// status (including transport-level errors and timeouts), or a 5xx status
+func DefaultShouldFallback(rsp Response) bool {
+	if rsp.Error != nil {
+		return ErrorStatusCode(rsp.Error) >= http.StatusInternalServerError
+	}
+	return rsp.Response != nil && rsp.StatusCode >= http.StatusInternalServerError
+}
+
+// Fallback returns a Service which passes requests to primary and, if shouldFallback (or DefaultShouldFallback, if nil)
+// says its response is a failure, discards it and passes the same request to secondary instead. The request body is
+// buffered so that it can be replayed, as with RetryFilter. The decision is made on the response's status and headers,
+// so the primary's response body is never read. Requests with streamed bodies (see NewStreamingRequest), or buffered
+// ones which have already been read (see Request.Rewindable), can't be replayed, so are only ever passed to primary.
+func Fallback(primary, secondary Service, shouldFallback func(Response) bool) Service {
+	if shouldFallback == nil {
+		shouldFallback = DefaultShouldFallback
+	}
+	return func(req Request) Response {
+		if !req.replayable() {
+			return primary(req)
+		}
+		body, err := req.bodyForReplay()
+		if err != nil {
+			rsp := NewResponse(req)
+			rsp.Error = err
+			return rsp
+		}
+		replay := func() Request {
+			r := req
+			if body != nil {
+				buf := &bufCloser{}
+				buf.Write(body)
+				r.Body = buf
+			}
+			return r
+		}
+
+		rsp := primary(replay())
+		if !shouldFallback(rsp) {
+			return rsp
+		}
+		// This response is being discarded
+		if rsp.Response != nil && rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		return secondary(replay())
+	}
+}
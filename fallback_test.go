@@ -0,0 +1,108 @@
+package typhon
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallback(t *testing.T) {
+	t.Parallel()
+	var primaryBody, secondaryBody string
+	status := http.StatusOK
+	var primaryErr error
+	primary := Service(func(req Request) Response {
+		b, _ := req.BodyBytes(true)
+		primaryBody = string(b)
+		if primaryErr != nil {
+			return Response{
+				Error: primaryErr}
+		}
+		rsp := req.Response("primary")
+		rsp.StatusCode = status
+		return rsp
+	})
+	secondary := Service(func(req Request) Response {
+		b, _ := req.BodyBytes(true)
+		secondaryBody = string(b)
+		return req.Response("secondary")
+	})
+	svc := Fallback(primary, secondary, nil)
+
+	send := func() string {
+		rsp := svc(NewRequest(nil, "POST", "/", map[string]string{"a": "b"}))
+		require.NoError(t, rsp.Error)
+		var s string
+		require.NoError(t, rsp.Decode(&s))
+		return s
+	}
+
+	assert.Equal(t, "primary", send())
+	assert.Equal(t, `{"a":"b"}`+"\n", primaryBody)
+
+	status = http.StatusServiceUnavailable
+	assert.Equal(t, "secondary", send())
+	assert.Equal(t, primaryBody, secondaryBody, "the secondary should receive the same body")
+
+	status = http.StatusNotFound
+	assert.Equal(t, "primary", send())
+
+	status = http.StatusOK
+	for _, err := range []error{
+		terrors.Timeout("", "Timed out", nil),
+		terrors.Wrap(errors.New("connection refused"), nil)} {
+		primaryErr = err
+		assert.Equal(t, "secondary", send())
+	}
+	primaryErr = terrors.BadRequest("", "Bad request", nil)
+	rsp := svc(NewRequest(nil, "POST", "/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrBadRequest))
+}
+
+func TestFallbackCustomPredicate(t *testing.T) {
+	t.Parallel()
+	primary := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusNotFound
+		return rsp
+	})
+	secondary := Service(func(req Request) Response {
+		return NewResponse(req)
+	})
+	svc := Fallback(primary, secondary, func(rsp Response) bool {
+		return rsp.StatusCode == http.StatusNotFound
+	})
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestFallbackStreamingBody(t *testing.T) {
+	t.Parallel()
+	secondaryCalled := false
+	svc := Fallback(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusServiceUnavailable
+		return rsp
+	}, func(req Request) Response {
+		secondaryCalled = true
+		return NewResponse(req)
+	}, nil)
+
+	// A streamed body can't be replayed, so the primary's failure stands
+	rsp := svc(NewStreamingRequest(nil, "POST", "/", strings.NewReader("hello")))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.False(t, secondaryCalled)
+
+	// Nor can a buffered body which has been partly read, but the request is still passed to the primary
+	req := NewRequest(nil, "POST", "/", "hello")
+	req.Body.Read(make([]byte, 2))
+	rsp = svc(req)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.False(t, secondaryCalled)
+}
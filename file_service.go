@@ -0,0 +1,177 @@
+package typhon
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// streamingResponseWriter is an http.ResponseWriter which populates a Response with a streaming body, for use with
+// code written against net/http. The Response is usable (signalled by ready being closed) as soon as its header has
+// been written; the body is then written to as the caller reads it.
+type streamingResponseWriter struct {
+	rsp       *Response
+	body      io.Writer
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func newStreamingResponseWriter(rsp *Response) *streamingResponseWriter {
+	body := Streamer()
+	rsp.Body = body
+	return &streamingResponseWriter{
+		rsp:   rsp,
+		body:  body,
+		ready: make(chan struct{})}
+}
+
+func (w *streamingResponseWriter) Header() http.Header {
+	return w.rsp.Header
+}
+
+func (w *streamingResponseWriter) WriteHeader(status int) {
+	w.readyOnce.Do(func() {
+		w.rsp.StatusCode = status
+		close(w.ready)
+	})
+}
+
+func (w *streamingResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// FileService returns a Service which serves files from the given filesystem, by the path of the request's URL.
+// Content-Type, Last-Modified, range requests, and conditional requests such as If-Modified-Since are handled as by
+// net/http's file server. File contents are streamed rather than buffered in memory.
+//
+// Requests for a directory are served its index.html, if it exists; directory listings are never served. Paths
+// containing .. segments are rejected. (To serve files beneath a path prefix, the prefix must be removed from the
+// request's URL path before it reaches the service.)
+func FileService(root http.FileSystem) Service {
+	return func(req Request) Response {
+		rsp := NewResponse(req)
+		for _, segment := range strings.Split(req.URL.Path, "/") {
+			if segment == ".." {
+				rsp.Error = terrors.BadRequest("invalid_path", "Invalid URL path", nil)
+				return rsp
+			}
+		}
+
+		name := path.Clean("/" + req.URL.Path)
+		f, stat, err := openFile(root, name)
+		if err == nil && stat.IsDir() {
+			f.Close()
+			f, stat, err = openFile(root, path.Join(name, "index.html"))
+		}
+		switch {
+		case os.IsNotExist(err):
+			rsp.Error = terrors.NotFound("file", fmt.Sprintf("%s not found", name), nil)
+			return rsp
+		case os.IsPermission(err):
+			rsp.Error = terrors.Forbidden("file", fmt.Sprintf("Access to %s is forbidden", name), nil)
+			return rsp
+		case err != nil:
+			rsp.Error = terrors.Wrap(err, nil)
+			return rsp
+		case stat.IsDir():
+			f.Close()
+			rsp.Error = terrors.NotFound("file", fmt.Sprintf("%s not found", name), nil)
+			return rsp
+		}
+
+		serveContent(req, &rsp, stat.Name(), stat.ModTime(), f, f)
+		return rsp
+	}
+}
+
+// serveContent streams content into rsp as by http.ServeContent, which handles range and conditional requests. It
+// returns once the response's header is ready; closer (if not nil) is closed once streaming is complete.
+func serveContent(req Request, rsp *Response, name string, modtime time.Time, content io.ReadSeeker, closer io.Closer) {
+	w := newStreamingResponseWriter(rsp)
+	body := rsp.Body
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if closer != nil {
+			defer closer.Close()
+		}
+		defer body.Close()
+		http.ServeContent(w, &req.Request, name, modtime, content)
+		w.WriteHeader(http.StatusOK) // in case ServeContent wrote nothing
+	}()
+	// If the request is cancelled, stop streaming (which unblocks the goroutine above)
+	if req.Done() != nil {
+		go func() {
+			select {
+			case <-done:
+			case <-req.Done():
+				body.Close()
+			}
+		}()
+	}
+	<-w.ready
+}
+
+// NewFileResponse constructs a Response which streams the contents of r as a download, with a Content-Disposition
+// of attachment under the given filename, and the given Content-Type. If r is an io.ReadSeeker, range and
+// conditional requests are supported. If r is an io.Closer, it is closed once it has been streamed.
+func NewFileResponse(req Request, r io.Reader, filename, contentType string) Response {
+	rsp := NewResponse(req)
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename})
+	if disposition == "" { // the filename can't be represented
+		disposition = "attachment"
+	}
+	rsp.Header.Set("Content-Disposition", disposition)
+	rsp.Header.Set("Content-Type", contentType)
+	closer, _ := r.(io.Closer)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		serveContent(req, &rsp, filename, time.Time{}, rs, closer)
+		return rsp
+	}
+
+	body := Streamer()
+	rsp.Body = body
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if closer != nil {
+			defer closer.Close()
+		}
+		_, err := io.Copy(body, r)
+		body.(ErrorCloser).CloseWithError(err)
+	}()
+	// If the request is cancelled, stop streaming (which unblocks the copy above)
+	if req.Done() != nil {
+		go func() {
+			select {
+			case <-done:
+			case <-req.Done():
+				body.Close()
+			}
+		}()
+	}
+	return rsp
+}
+
+func openFile(root http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, stat, nil
+}
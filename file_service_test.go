@@ -0,0 +1,108 @@
+package typhon
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "typhon-files")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "css"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>hi</p>"), 0644))
+	modTime := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "css", "app.css"), modTime, modTime))
+
+	svc := FileService(http.Dir(dir)).Filter(ErrorFilter)
+	ctx := context.Background()
+
+	rsp := svc(NewRequest(ctx, "GET", "/css/app.css", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, modTime.Format(http.TimeFormat), rsp.Header.Get("Last-Modified"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "body{}", string(b))
+
+	// Range requests
+	req := NewRequest(ctx, "GET", "/css/app.css", nil)
+	req.Header.Set("Range", "bytes=1-3")
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "ody", string(b))
+
+	// Conditional requests
+	req = NewRequest(ctx, "GET", "/css/app.css", nil)
+	req.Header.Set("If-Modified-Since", modTime.Add(time.Hour).Format(http.TimeFormat))
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+	b, _ = rsp.BodyBytes(true)
+	assert.Empty(t, b)
+
+	// Directory index
+	rsp = svc(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "<p>hi</p>", string(b))
+
+	rsp = svc(NewRequest(ctx, "GET", "/css", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	rsp = svc(NewRequest(ctx, "GET", "/nope.txt", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	req = NewRequest(ctx, "GET", "/", nil)
+	req.URL.Path = "/css/../../etc/passwd"
+	rsp = svc(req)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
+
+func TestNewFileResponse(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// A seekable body supports range requests
+	req := NewRequest(ctx, "GET", "/export", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rsp := NewFileResponse(req, strings.NewReader("a,b,c\n1,2,3\n"), "report.csv", "text/csv")
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.Equal(t, `attachment; filename=report.csv`, rsp.Header.Get("Content-Disposition"))
+	assert.Equal(t, "text/csv", rsp.Header.Get("Content-Type"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "b,c", string(b))
+
+	// Other readers are streamed, and closed once done; awkward filenames are escaped
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("chunk"))
+		w.Close()
+	}()
+	req = NewRequest(ctx, "GET", "/export", nil)
+	rsp = NewFileResponse(req, r, `Q1 "final" résumé.pdf`, "application/pdf")
+	assert.True(t, isStreamingRsp(rsp))
+	assert.Equal(t, `attachment; filename*=utf-8''Q1%20%22final%22%20r%C3%A9sum%C3%A9.pdf`,
+		rsp.Header.Get("Content-Disposition"))
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "chunk", string(b))
+	_, err = r.Read(nil)
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
@@ -3,3 +3,16 @@ package typhon
 // Filter functions compose with Services to modify their observed behaviour. They might change a service's input or
 // output, or elect not to call the underlying service at all.
 type Filter func(Request, Service) Response
+
+// Filters composes the given filters into one. The first filter is the outermost: it sees each request first and
+// each response last, so svc.Filter(Filters(a, b, c)) is equivalent to svc.Filter(c).Filter(b).Filter(a). With no
+// filters, the result passes requests straight to the service.
+func Filters(filters ...Filter) Filter {
+	filters = append([]Filter(nil), filters...)
+	return func(req Request, svc Service) Response {
+		for i := len(filters) - 1; i >= 0; i-- {
+			svc = svc.Filter(filters[i])
+		}
+		return svc(req)
+	}
+}
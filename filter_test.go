@@ -0,0 +1,38 @@
+package typhon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+	var order []string
+	named := func(name string) Filter {
+		return func(req Request, svc Service) Response {
+			order = append(order, name+" in")
+			rsp := svc(req)
+			order = append(order, name+" out")
+			return rsp
+		}
+	}
+	svc := Service(func(req Request) Response {
+		order = append(order, "service")
+		return NewResponse(req)
+	}).Filter(Filters(named("a"), named("b"), named("c")))
+
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"a in", "b in", "c in", "service", "c out", "b out", "a out"}, order)
+
+	// Composed filters are themselves composable
+	order = nil
+	svc = Service(func(req Request) Response {
+		order = append(order, "service")
+		return NewResponse(req)
+	}).Filter(Filters(named("a"), Filters(named("b"), named("c")), Filters()))
+	svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, []string{"a in", "b in", "c in", "service", "c out", "b out", "a out"}, order)
+}
@@ -0,0 +1,80 @@
+package typhon
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"strings"
+)
+
+// DefaultGzipMinSize is the size in bytes below which GzipFilter (and CompressFilter) will not compress buffered
+// response bodies
+const DefaultGzipMinSize = 1024
+
+// GzipFilter compresses response bodies with gzip when the client accepts it. Buffered bodies smaller than
+// DefaultGzipMinSize, and content types which are already compressed, are left alone. Streaming responses are
+// compressed on the fly, and are still flushed to the client as they are written. To also use other content codings
+// (such as brotli) where clients prefer them, use CompressFilter.
+func GzipFilter(req Request, svc Service) Response {
+	return gzipFilter(req, svc, DefaultGzipMinSize)
+}
+
+// GzipFilterWithMinSize returns a Filter like GzipFilter, but which will not compress buffered bodies smaller than
+// minSize bytes.
+func GzipFilterWithMinSize(minSize int) Filter {
+	return func(req Request, svc Service) Response {
+		return gzipFilter(req, svc, minSize)
+	}
+}
+
+func gzipFilter(req Request, svc Service, minSize int) Response {
+	return compressFilter(req, svc, minSize, []ContentEncoding{gzipEncoding})
+}
+
+// compressibleContentType returns whether a body of the given Content-Type is likely to benefit from compression
+func compressibleContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "font/woff"):
+		return false
+	}
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd", "application/octet-stream":
+		return false
+	}
+	return true
+}
+
+// gzipReadCloser decompresses a gzip-encoded body as it is read. The gzip header is not read until the first call to
+// Read, so any error from malformed data is returned from there.
+type gzipReadCloser struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (r *gzipReadCloser) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.zr == nil {
+		if r.zr, r.err = gzip.NewReader(r.body); r.err != nil {
+			return 0, r.err
+		}
+	}
+	return r.zr.Read(p)
+}
+
+func (r *gzipReadCloser) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,110 @@
+package typhon
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gunzip(t *testing.T, r io.Reader) string {
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestGzipFilter(t *testing.T) {
+	t.Parallel()
+	large := strings.Repeat("typhon ", 1000)
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		switch req.URL.Path {
+		case "/small":
+			rsp.Write([]byte("tiny"))
+		case "/image":
+			rsp.Header.Set("Content-Type", "image/png")
+			rsp.Write([]byte(large))
+		default:
+			rsp.Header.Set("Content-Type", "text/plain")
+			rsp.Write([]byte(large))
+		}
+		return rsp
+	}).Filter(GzipFilter)
+
+	req := NewRequest(nil, "GET", "/large", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "gzip", rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"))
+	assert.True(t, rsp.ContentLength > 0 && rsp.ContentLength < int64(len(large)))
+	assert.Equal(t, large, gunzip(t, rsp.Body))
+
+	// Not accepted by the client
+	for _, ae := range []string{"", "deflate", "gzip;q=0", "*;q=0"} {
+		req = NewRequest(nil, "GET", "/large", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		rsp = svc(req)
+		assert.Empty(t, rsp.Header.Get("Content-Encoding"), ae)
+		assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"), ae)
+		b, _ := rsp.BodyBytes(true)
+		assert.Equal(t, large, string(b), ae)
+	}
+
+	// Too small, or already compressed
+	for _, path := range []string{"/small", "/image"} {
+		req = NewRequest(nil, "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rsp = svc(req)
+		assert.Empty(t, rsp.Header.Get("Content-Encoding"), path)
+	}
+}
+
+func TestGzipFilterStreaming(t *testing.T) {
+	t.Parallel()
+	chunks := make(chan string)
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		s := Streamer()
+		rsp.Body = s
+		go func() {
+			defer s.Close()
+			for c := range chunks {
+				s.Write([]byte(c))
+			}
+		}()
+		return rsp
+	}).Filter(GzipFilter)
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "gzip", rsp.Header.Get("Content-Encoding"))
+	assert.True(t, isStreamingRsp(rsp))
+
+	// Each chunk must be readable before the stream is complete
+	gz := (*gzip.Reader)(nil)
+	for i, c := range []string{"abc", "def"} {
+		chunks <- c
+		if i == 0 {
+			var err error
+			gz, err = gzip.NewReader(rsp.Body)
+			require.NoError(t, err)
+		}
+		buf := make([]byte, len(c))
+		_, err := io.ReadFull(gz, buf)
+		require.NoError(t, err)
+		assert.Equal(t, c, string(buf))
+	}
+	close(chunks)
+	rest, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
@@ -0,0 +1,207 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckTimeout is how long a HealthService waits for each check to complete before considering it
+	// failed
+	DefaultHealthCheckTimeout = 5 * time.Second
+	// DefaultDrainGrace is a reasonable grace period for GracefulShutdown: long enough for an orchestrator polling
+	// readiness every few seconds to notice and stop routing traffic
+	DefaultDrainGrace = 15 * time.Second
+)
+
+// A HealthCheck reports whether a dependency is healthy, returning an error if not. It should return promptly once ctx
+// is done.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckResult is the outcome of a single HealthCheck, as reported by a HealthService
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport is the body of a HealthService response
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+// A HealthService aggregates health checks into liveness and readiness endpoints, suitable for use with Kubernetes
+// probes. Each responds with 200 OK if all of its checks pass, or 503 Service Unavailable otherwise, with a JSON
+// HealthReport detailing each check. Checks run concurrently, each bounded by Timeout. It is safe for concurrent use.
+//
+// Liveness checks should only fail if the process is unable to recover by itself (and so should be restarted);
+// readiness checks may fail while a dependency is unavailable, to stop traffic being routed to the process. Readiness
+// also fails once the HealthService has been drained, or while the Server which received the request is draining.
+type HealthService struct {
+	// Timeout bounds how long each check may take (default DefaultHealthCheckTimeout). It MUST only be set before use.
+	Timeout time.Duration
+
+	m         sync.RWMutex
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+	draining  int32
+}
+
+// NewHealthService returns a HealthService with no checks
+func NewHealthService() *HealthService {
+	return &HealthService{
+		Timeout:   DefaultHealthCheckTimeout,
+		liveness:  make(map[string]HealthCheck),
+		readiness: make(map[string]HealthCheck)}
+}
+
+// RegisterCheck registers a readiness check with the given name, replacing any existing check of that name
+func (h *HealthService) RegisterCheck(name string, fn HealthCheck) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.readiness[name] = fn
+}
+
+// RegisterLivenessCheck registers a liveness check with the given name, replacing any existing check of that name
+func (h *HealthService) RegisterLivenessCheck(name string, fn HealthCheck) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.liveness[name] = fn
+}
+
+// Drain makes readiness fail from now on, so that orchestrators stop routing traffic to the process ahead of a
+// shutdown. Liveness is unaffected, so the process isn't restarted in the meantime. See also GracefulShutdown.
+func (h *HealthService) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Draining returns whether Drain has been called
+func (h *HealthService) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// Liveness returns a Service which runs the liveness checks
+func (h *HealthService) Liveness() Service {
+	return func(req Request) Response {
+		return h.respond(req, h.run(req, h.liveness))
+	}
+}
+
+// Readiness returns a Service which runs the readiness checks
+func (h *HealthService) Readiness() Service {
+	return func(req Request) Response {
+		report := h.run(req, h.readiness)
+		if h.Draining() || IsDraining(req) {
+			report.Status = healthStatusFail
+			report.Checks["draining"] = HealthCheckResult{
+				Status: healthStatusFail,
+				Error:  "Server is draining"}
+		}
+		return h.respond(req, report)
+	}
+}
+
+// Mount registers the liveness endpoint at GET /livez and the readiness endpoint at GET /readyz and /healthz on the
+// Router
+func (h *HealthService) Mount(r *Router) {
+	r.GET("/livez", h.Liveness())
+	r.GET("/readyz", h.Readiness())
+	r.GET("/healthz", h.Readiness())
+}
+
+func (h *HealthService) run(ctx context.Context, checks map[string]HealthCheck) HealthReport {
+	h.m.RLock()
+	names := make([]string, 0, len(checks))
+	fns := make([]HealthCheck, 0, len(checks))
+	for name, fn := range checks {
+		names = append(names, name)
+		fns = append(fns, fn)
+	}
+	h.m.RUnlock()
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]error, len(fns))
+	wg := sync.WaitGroup{}
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, fn)
+		}(i, fn)
+	}
+	wg.Wait()
+
+	report := HealthReport{
+		Status: healthStatusOK,
+		Checks: make(map[string]HealthCheckResult, len(names))}
+	for i, name := range names {
+		if err := results[i]; err != nil {
+			report.Status = healthStatusFail
+			report.Checks[name] = HealthCheckResult{
+				Status: healthStatusFail,
+				Error:  err.Error()}
+		} else {
+			report.Checks[name] = HealthCheckResult{
+				Status: healthStatusOK}
+		}
+	}
+	return report
+}
+
+// GracefulShutdown shuts down the server without dropping traffic. The server and the HealthService (if non-nil) are
+// drained, so readiness fails while liveness continues to pass, and the server keeps serving requests for the grace
+// period; this gives orchestrators polling readiness time to stop routing traffic to it. The server is then shut down,
+// waiting for in-flight requests to complete until ctx is done (see Server.Shutdown). If ctx is done during the grace
+// period, the server is shut down straight away.
+func GracefulShutdown(ctx context.Context, srv Server, health *HealthService, grace time.Duration) error {
+	if health != nil {
+		health.Drain()
+	}
+	srv.Drain()
+	if grace > 0 {
+		t := time.NewTimer(grace)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return srv.Shutdown(ctx)
+}
+
+// runHealthCheck runs fn, giving up on it once ctx is done (even if fn itself does not return)
+func runHealthCheck(ctx context.Context, fn HealthCheck) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *HealthService) respond(req Request, report HealthReport) Response {
+	rsp := req.Response(report)
+	rsp.Header.Set("Cache-Control", "no-store")
+	if report.Status != healthStatusOK {
+		rsp.StatusCode = http.StatusServiceUnavailable
+	}
+	return rsp
+}
@@ -0,0 +1,161 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthService(t *testing.T) {
+	t.Parallel()
+	h := NewHealthService()
+	h.Timeout = 50 * time.Millisecond
+	router := NewRouter()
+	h.Mount(&router)
+	svc := router.Serve()
+
+	get := func(path string) (int, HealthReport) {
+		rsp := svc(NewRequest(context.Background(), "GET", path, nil))
+		require.NoError(t, rsp.Error)
+		report := HealthReport{}
+		require.NoError(t, rsp.Decode(&report))
+		return rsp.StatusCode, report
+	}
+
+	// With no checks, everything is healthy
+	status, report := get("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", report.Status)
+
+	h.RegisterCheck("db", func(ctx context.Context) error {
+		return nil
+	})
+	h.RegisterCheck("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	release := make(chan struct{})
+	defer close(release)
+	h.RegisterCheck("hung", func(ctx context.Context) error {
+		<-release // ignores its context entirely
+		return nil
+	})
+	h.RegisterLivenessCheck("deadlock", func(ctx context.Context) error {
+		return nil
+	})
+
+	start := time.Now()
+	status, report = get("/healthz")
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "fail", report.Status)
+	assert.Equal(t, HealthCheckResult{Status: "ok"}, report.Checks["db"])
+	assert.Equal(t, HealthCheckResult{Status: "fail", Error: "connection refused"}, report.Checks["cache"])
+	assert.Equal(t, HealthCheckResult{Status: "fail", Error: context.DeadlineExceeded.Error()}, report.Checks["hung"])
+
+	// Liveness is independent of readiness
+	status, report = get("/livez")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]HealthCheckResult{"deadlock": {Status: "ok"}}, report.Checks)
+}
+
+func TestHealthServiceDraining(t *testing.T) {
+	t.Parallel()
+	h := NewHealthService()
+	s, err := Listen(h.Readiness(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	addr := "http://" + s.Listener().Addr().String()
+
+	rsp := NewRequest(context.Background(), "GET", addr, nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+
+	s.Drain()
+	rsp = NewRequest(context.Background(), "GET", addr, nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	report := HealthReport{}
+	require.NoError(t, rsp.Decode(&report))
+	assert.Equal(t, "fail", report.Checks["draining"].Status)
+}
+
+func TestHealthServiceDrain(t *testing.T) {
+	t.Parallel()
+	h := NewHealthService()
+	router := NewRouter()
+	h.Mount(&router)
+	svc := router.Serve()
+
+	h.Drain()
+	assert.True(t, h.Draining())
+	rsp := svc(NewRequest(nil, "GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	report := HealthReport{}
+	require.NoError(t, rsp.Decode(&report))
+	assert.Equal(t, "fail", report.Checks["draining"].Status)
+
+	rsp = svc(NewRequest(nil, "GET", "/livez", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	t.Parallel()
+	h := NewHealthService()
+	router := NewRouter()
+	h.Mount(&router)
+	release := make(chan struct{})
+	router.GET("/slow", func(req Request) Response {
+		<-release
+		return req.Response("done")
+	})
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	addr := "http://" + s.Listener().Addr().String()
+
+	slow := NewRequest(context.Background(), "GET", addr+"/slow", nil).Send()
+	time.Sleep(10 * time.Millisecond) // let the slow request arrive
+	start := time.Now()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- GracefulShutdown(context.Background(), s, h, 100*time.Millisecond)
+	}()
+	for !s.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// During the grace period, readiness fails but liveness passes, and requests are still served
+	rsp := NewRequest(context.Background(), "GET", addr+"/readyz", nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	rsp.Body.Close()
+	rsp = NewRequest(context.Background(), "GET", addr+"/livez", nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// In-flight requests are completed before the server stops, even once the grace period is over
+	time.Sleep(150 * time.Millisecond)
+	close(release)
+	rsp = slow.Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "done", body)
+
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "GracefulShutdown did not return")
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	<-s.WaitC()
+}
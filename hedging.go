@@ -0,0 +1,103 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// IsSafe returns whether the request's method is safe (ie. read-only), and so may be sent more than once without
+// consequence
+func IsSafe(req Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// HedgingFilter returns a Filter which, if a request with a safe method has not been answered within delay, sends
+// another copy of it, up to a total of max copies. The first response to arrive is returned, and the others are
+// cancelled. Requests with streamed bodies (see NewStreamingRequest), or buffered ones which have already been read
+// (see Request.Rewindable), are never hedged.
+func HedgingFilter(delay time.Duration, max int) Filter {
+	return HedgingFilterWith(delay, max, IsSafe)
+}
+
+// HedgingFilterWith is like HedgingFilter, but hedges only requests for which hedgeable returns true.
+func HedgingFilterWith(delay time.Duration, max int, hedgeable func(Request) bool) Filter {
+	return func(req Request, svc Service) Response {
+		if max <= 1 || !hedgeable(req) || !req.replayable() {
+			return svc(req)
+		}
+		body, err := req.bodyForReplay()
+		if err != nil {
+			rsp := NewResponse(req)
+			rsp.Error = err
+			return rsp
+		}
+		parent := req.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+
+		type result struct {
+			i   int
+			rsp Response
+		}
+		results := make(chan result, max)
+		cancels := make([]context.CancelFunc, 0, max)
+		send := func() {
+			ctx, cancel := context.WithCancel(parent)
+			i := len(cancels)
+			cancels = append(cancels, cancel)
+			attemptReq := req
+			attemptReq.Context = ctx
+			attemptReq.cancel = nil
+			if body != nil {
+				buf := &bufCloser{}
+				buf.Write(body)
+				attemptReq.Body = buf
+			}
+			go func() {
+				results <- result{i, svc(attemptReq)}
+			}()
+		}
+
+		send()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		var winner result
+	wait:
+		for {
+			select {
+			case winner = <-results:
+				break wait
+			case <-timer.C:
+				if len(cancels) < max {
+					send()
+					timer.Reset(delay)
+				}
+			}
+		}
+
+		// Cancel the losers, and clean up after them as they return
+		for i, cancel := range cancels {
+			if i != winner.i {
+				cancel()
+			}
+		}
+		if pending := len(cancels) - 1; pending > 0 {
+			go func() {
+				for ; pending > 0; pending-- {
+					loser := <-results
+					if loser.rsp.Response != nil && loser.rsp.Body != nil {
+						loser.rsp.Body.Close()
+					}
+				}
+			}()
+		}
+		releaseWithBody(parent, winner.rsp, cancels[winner.i])
+		return winner.rsp
+	}
+}
@@ -0,0 +1,53 @@
+package typhon
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgingFilter(t *testing.T) {
+	t.Parallel()
+	calls := int32(0)
+	cancelled := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		body := ""
+		req.Decode(&body)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-req.Done():
+				close(cancelled)
+			case <-time.After(time.Second):
+			}
+			return req.Response("slow " + body)
+		}
+		return req.Response("fast " + body)
+	})
+
+	start := time.Now()
+	rsp := svc.Filter(HedgingFilter(10*time.Millisecond, 3))(NewRequest(nil, "GET", "/", "hedged"))
+	require.NoError(t, rsp.Error)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "fast hedged", body)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "losing request was not cancelled")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Unsafe methods are not hedged
+	calls = 0
+	svc = Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(30 * time.Millisecond)
+		return req.Response(nil)
+	})
+	svc.Filter(HedgingFilter(time.Millisecond, 3))(NewRequest(nil, "POST", "/", nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,104 @@
+package typhon
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+// A HostRouter multiplexes requests to a set of Services by the host to which they are addressed. Hosts are either
+// exact names (eg. api.example.com), or wildcards (eg. *.example.com) which match any subdomain of the given domain.
+// Exact names take precedence over wildcards, and longer wildcards over shorter ones.
+type HostRouter struct {
+	// Default serves requests whose host doesn't match any registration. If nil, such requests receive a 404.
+	Default Service
+
+	hosts     map[string]Service
+	wildcards *[]hostWildcard // sorted from most to least specific
+	m         *sync.RWMutex
+}
+
+type hostWildcard struct {
+	suffix string // eg. ".example.com"
+	svc    Service
+}
+
+// NewHostRouter vends a new implementation of HostRouter
+func NewHostRouter() HostRouter {
+	return HostRouter{
+		hosts:     make(map[string]Service, 10),
+		wildcards: &[]hostWildcard{},
+		m:         new(sync.RWMutex)}
+}
+
+// normaliseHost lower-cases the host and strips any port and trailing dot.
+func normaliseHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Register associates a Service with a host. To route requests for a host to a Router, pass its Serve() method.
+func (h *HostRouter) Register(host string, svc Service) {
+	host = normaliseHost(host)
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if !strings.HasPrefix(host, "*.") {
+		h.hosts[host] = svc
+		return
+	}
+
+	suffix := host[1:]
+	wildcards := *h.wildcards
+	for i, w := range wildcards {
+		if w.suffix == suffix {
+			wildcards[i].svc = svc
+			return
+		}
+	}
+	wildcards = append(wildcards, hostWildcard{
+		suffix: suffix,
+		svc:    svc})
+	sort.SliceStable(wildcards, func(i, j int) bool {
+		return len(wildcards[i].suffix) > len(wildcards[j].suffix)
+	})
+	*h.wildcards = wildcards
+}
+
+// Lookup returns the Service registered for the host, if any.
+func (h HostRouter) Lookup(host string) (Service, bool) {
+	host = normaliseHost(host)
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	if svc, ok := h.hosts[host]; ok {
+		return svc, true
+	}
+	for _, w := range *h.wildcards {
+		if strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return w.svc, true
+		}
+	}
+	return nil, false
+}
+
+// Serve returns a Service which will route inbound requests to the Service registered for their host.
+func (h HostRouter) Serve() Service {
+	return func(req Request) Response {
+		if svc, ok := h.Lookup(req.Host); ok {
+			return svc(req)
+		}
+		if h.Default != nil {
+			return h.Default(req)
+		}
+		rsp := NewResponse(req)
+		rsp.Error = terrors.NotFound("no_host", fmt.Sprintf("No handler for host %s", req.Host), nil)
+		return rsp
+	}
+}
@@ -0,0 +1,57 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostRouter(t *testing.T) {
+	t.Parallel()
+
+	named := func(name string) Service {
+		return func(req Request) Response {
+			return req.Response(name)
+		}
+	}
+	hr := NewHostRouter()
+	hr.Register("api.example.com", named("api"))
+	hr.Register("*.example.com", named("wildcard"))
+	hr.Register("*.admin.example.com", named("admin"))
+	svc := hr.Serve().Filter(ErrorFilter)
+
+	cases := map[string]string{
+		"api.example.com":        "api",
+		"API.Example.com:8080":   "api",
+		"api.example.com.":       "api",
+		"www.example.com":        "wildcard",
+		"a.b.example.com":        "wildcard",
+		"eu.admin.example.com":   "admin",
+		"admin.example.com":      "wildcard",
+		"example.com":            "",
+		"api.example.com.evil.x": ""}
+	ctx := context.Background()
+	for host, expected := range cases {
+		req := NewRequest(ctx, "GET", "/", nil)
+		req.Host = host
+		rsp := svc(req)
+		if expected == "" {
+			assert.Equal(t, http.StatusNotFound, rsp.StatusCode, host)
+			continue
+		}
+		body := ""
+		assert.NoError(t, rsp.Decode(&body), host)
+		assert.Equal(t, expected, body, host)
+	}
+
+	// A default service handles unmatched hosts
+	hr.Default = named("default")
+	req := NewRequest(ctx, "GET", "/", nil)
+	req.Host = "example.org"
+	body := ""
+	rsp := hr.Serve()(req)
+	assert.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "default", body)
+}
@@ -1,21 +1,55 @@
 package typhon
 
 import (
+	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/monzo/slog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
-	// chunkThreshold is a byte threshold above which request and response bodies that result from using buffered I/O
-	// within Typhon will be transferred with chunked encoding on the wire.
-	chunkThreshold = 5 * 1000000 // 5 megabytes
+	// DefaultChunkThreshold is the default value of ChunkThreshold
+	DefaultChunkThreshold = 5 * 1000000 // 5 megabytes
 )
 
+// ChunkThreshold is a byte threshold above which request and response bodies that result from using buffered I/O
+// within Typhon (eg. Encode) will be transferred with chunked encoding on the wire; smaller bodies are sent with a
+// Content-Length. Such bodies are held in memory either way, so this doesn't change Typhon's own memory use, but peers
+// (and proxies in particular) may buffer a whole body of known length before processing it, whereas chunked bodies can
+// be processed incrementally. Lowering the threshold therefore reduces the memory peers need for large bodies, at the
+// cost of them not knowing bodies' sizes up-front.
+//
+// It can be overridden globally but MUST only be done before use takes place; access is not synchronised. It has no
+// bearing on streaming responses, which are always chunked.
+var ChunkThreshold = DefaultChunkThreshold
+
+// A bodyWrapper is a body which wraps another (for example, to observe reads from it). isStreamingRsp looks through
+// wrappers to the underlying body.
+type bodyWrapper interface {
+	unwrapBody() io.ReadCloser
+}
+
 func isStreamingRsp(rsp Response) bool {
 	// Most straightforward: service may have set rsp.Body to a streamer
-	if s, ok := rsp.Body.(*streamer); ok && s != nil {
+	body := rsp.Body
+	for {
+		w, ok := body.(bodyWrapper)
+		if !ok {
+			break
+		}
+		body = w.unwrapBody()
+	}
+	if s, ok := body.(*streamer); ok && s != nil {
 		return true
 	}
 	// In a proxy situation, the upstream would have set Transfer-Encoding
@@ -33,22 +67,99 @@ func isStreamingRsp(rsp Response) bool {
 	return false
 }
 
+// hasKnownLength returns whether the response has a non-streaming body whose length is known, and matches its
+// Content-Length header. Buffered bodies are measured, rather than trusting ContentLength to have been kept up to date.
+func hasKnownLength(rsp Response) bool {
+	if rsp.Response == nil || rsp.Body == nil || rsp.ContentLength < 0 || isStreamingRsp(rsp) ||
+		rsp.Header.Get("Content-Length") != strconv.FormatInt(rsp.ContentLength, 10) {
+		return false
+	}
+	if b, ok := rsp.Body.(*bufCloser); ok {
+		return !b.consumed && int64(b.Len()) == rsp.ContentLength
+	}
+	return true
+}
+
+// clientGoneErrs are errors writing a response which mean that the client went away before reading all of it. They are
+// matched anywhere in an error's chain: errno values are typically wrapped in an *os.SyscallError within a
+// *net.OpError.
+var clientGoneErrs = []error{
+	context.Canceled,
+	http.ErrHandlerTimeout,
+	net.ErrClosed,
+	syscall.EPIPE,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED}
+
+// clientGoneMessages are suffixes of the messages of write errors which mean the same, but which can't be matched by
+// value: because they are unexported (as with HTTP/2's), or have been flattened into strings along the way
+var clientGoneMessages = []string{
+	"broken pipe",
+	"connection reset by peer",
+	"client disconnected"}
+
+// writeErrSeverity returns the severity with which to log an error writing a response body to the client. Clients
+// going away are an ordinary part of serving traffic, so are logged at debug level, and clients timing out because they
+// are too slow to read the response at info level. Anything else is logged as an error.
+//
+// It must only be given errors from writing: the same errors from reading a body (eg. from an upstream which reset the
+// connection) are real failures.
+func writeErrSeverity(err error) slog.Severity {
+	for _, target := range clientGoneErrs {
+		if errors.Is(err, target) {
+			return slog.DebugSeverity
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return slog.InfoSeverity
+	}
+	msg := err.Error()
+	for _, suffix := range clientGoneMessages {
+		if strings.HasSuffix(msg, suffix) {
+			return slog.DebugSeverity
+		}
+	}
+	return slog.ErrorSeverity
+}
+
 // HttpHandler transforms the given Service into a http.Handler, suitable for use directly with net/http
 func HttpHandler(svc Service) http.Handler {
+	return httpHandler(svc, 0)
+}
+
+// httpHandler is like HttpHandler, but if bodyWriteTimeout is non-zero, writing each buffered response body to the
+// client is bounded by it (see ServerConfig.BodyWriteTimeout)
+func httpHandler(svc Service, bodyWriteTimeout time.Duration) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, httpReq *http.Request) {
 		if httpReq.Body != nil {
 			defer httpReq.Body.Close()
 		}
 
+		hw := &hijackableWriter{
+			ResponseWriter: rw}
+		bw := &bytesWrittenWriter{
+			Writer: rw}
+		ctx := withHijackableWriter(httpReq.Context(), hw)
 		req := Request{
-			Context: httpReq.Context(),
+			Context: context.WithValue(ctx, bytesWrittenContextKey, bw),
 			Request: *httpReq}
 		rsp := svc(req)
+		if hw.hijacked {
+			// The service has taken over the connection (eg. for a WebSocket); there's no response to write
+			if rsp.Body != nil {
+				rsp.Body.Close()
+			}
+			return
+		}
 
 		// Write the response out
 		rwHeader := rw.Header()
 		for k, v := range rsp.Header {
-			if k == "Content-Length" {
+			// Content-Length is left to net/http, except for HEAD requests, where there's no body from which to derive
+			// it, and responses whose body is known to be of that length (eg. proxied ones), which would otherwise be
+			// chunked needlessly
+			if k == "Content-Length" && httpReq.Method != http.MethodHead && !hasKnownLength(rsp) {
 				continue
 			}
 			rwHeader[k] = v
@@ -57,21 +168,168 @@ func HttpHandler(svc Service) http.Handler {
 		if rsp.Body != nil {
 			defer rsp.Body.Close()
 			if isStreamingRsp(rsp) {
-				// Streaming responses use copyChunked(), which takes care of flushing transparently
-				if _, err := copyChunked(rw, rsp.Body); err != nil {
-					slog.Error(req, "Error copying streaming response body: %v", err)
+				// Streaming responses use copyChunked(), which takes care of flushing transparently. The server's
+				// write deadline is extended by WriteTimeout on every write rather than applying to the whole response,
+				// so long-lived streams aren't cut off part-way through.
+				if srv, ok := httpReq.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.WriteTimeout > 0 {
+					bw.Writer = &deadlineWriter{
+						ResponseWriter: rw,
+						rc:             http.NewResponseController(rw),
+						timeout:        srv.WriteTimeout}
+				}
+				src := &errRecordingReader{
+					Reader: rsp.Body}
+				if _, err := copyChunked(bw, src); err != nil {
+					slog.Log(slog.Eventf(bw.errSeverity(), req, "Error copying streaming response body: %v", err))
+				}
+				if src.err != nil {
+					// Let the client know that the stream is incomplete
+					rwHeader.Set(http.TrailerPrefix+StreamErrorTrailer, encodeStreamError(src.err))
 				}
 			} else {
-				if _, err := io.Copy(rw, rsp.Body); err != nil {
-					slog.Error(req, "Error copying response body: %v", err)
+				if bodyWriteTimeout > 0 {
+					// This also bounds net/http's final flush of the response once the handler returns, after which it
+					// clears the deadline
+					http.NewResponseController(rw).SetWriteDeadline(time.Now().Add(bodyWriteTimeout))
+				}
+				if _, err := io.Copy(bw, rsp.Body); err != nil {
+					slog.Log(slog.Eventf(bw.errSeverity(), req, "Error copying response body: %v", err))
 				}
 			}
 		}
 	})
 }
 
+// handlerService adapts a net/http Handler into a Service. The handler's response is buffered.
+func handlerService(h http.Handler) Service {
+	return func(req Request) Response {
+		rsp := NewResponse(req)
+		h.ServeHTTP(rsp.Writer(), req.Request.WithContext(req))
+		return rsp
+	}
+}
+
+// ServerConfig configures the timeouts and limits of a server constructed by HttpServerWithConfig. Zero values mean no
+// limit (or for timeouts, no timeout).
+//
+// ReadHeaderTimeout bounds how long a client may take to send a request's headers, and is the main defence against
+// slow-loris style clients. ReadTimeout bounds the time taken to read the whole request, including its body; if it is
+// zero, ReadHeaderTimeout still applies to the headers. WriteTimeout bounds the time from the end of reading the
+// request headers to the end of writing the response, except for streaming responses, where it instead bounds the
+// time taken by each write of the body (the deadline being extended by WriteTimeout on every write).
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for its next request before being closed. If zero,
+	// ReadTimeout is used.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of request headers. If zero, http.DefaultMaxHeaderBytes is used.
+	MaxHeaderBytes int
+	// BodyWriteTimeout bounds the time taken to write the body of each buffered (ie. non-streaming) response to the
+	// client, once the service has returned it, so a client which reads slowly can't tie up the server indefinitely.
+	// When it passes, the response is abandoned and the connection closed. For these responses it takes the place of
+	// WriteTimeout, which also counts the time spent handling the request. It doesn't apply to streaming responses.
+	BodyWriteTimeout time.Duration
+}
+
+// DefaultServerConfig returns the ServerConfig used by HttpServer. It bounds the time taken to send request headers
+// and the time idle connections are kept open, but not the time taken to read requests or write responses, so that
+// long-running handlers and large uploads aren't cut off: set ReadTimeout and WriteTimeout to opt in to those.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes}
+}
+
 func HttpServer(svc Service) *http.Server {
+	return HttpServerWithConfig(svc, DefaultServerConfig())
+}
+
+// HttpServerWithConfig is like HttpServer, but with the given timeouts and limits
+func HttpServerWithConfig(svc Service, cfg ServerConfig) *http.Server {
 	return &http.Server{
-		Handler:        HttpHandler(svc),
-		MaxHeaderBytes: http.DefaultMaxHeaderBytes}
+		Handler:           httpHandler(svc, cfg.BodyWriteTimeout),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes}
+}
+
+// H2CServer is like HttpServer, but the returned server additionally speaks cleartext HTTP/2 ("h2c"), both to clients
+// with prior knowledge and to HTTP/1.1 clients requesting an upgrade. HTTP/1.1 requests are served as normal.
+func H2CServer(svc Service) *http.Server {
+	srv := HttpServer(svc)
+	srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+	return srv
+}
+
+type bytesWrittenContextKeyType struct{}
+
+var bytesWrittenContextKey = bytesWrittenContextKeyType{}
+
+// bytesWrittenWriter counts the bytes of a response body written through it to the client, and records the first
+// error writing them. The count may be read concurrently with writes.
+type bytesWrittenWriter struct {
+	io.Writer
+	n   int64
+	err error
+}
+
+func (w *bytesWrittenWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(&w.n, int64(n))
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// errSeverity returns the severity with which to log the failure of a copy through the writer: if no write failed, the
+// copy failed reading the body, which is always logged as an error
+func (w *bytesWrittenWriter) errSeverity() slog.Severity {
+	if w.err == nil {
+		return slog.ErrorSeverity
+	}
+	return writeErrSeverity(w.err)
+}
+
+func (w *bytesWrittenWriter) Flush() {
+	if f, ok := w.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// BytesWritten returns the number of bytes of the response body which have so far been written to the client, and
+// whether this is known: it is only for requests received by HttpHandler. The count is kept up to date as the body is
+// written, including for streaming responses, so it can be read by a filter once the response body has been closed (as
+// AccessLogFilter does) to find out how much of it reached the client.
+func (r Request) BytesWritten() (int64, bool) {
+	if r.Context == nil {
+		return 0, false
+	}
+	w, ok := r.Value(bytesWrittenContextKey).(*bytesWrittenWriter)
+	if !ok {
+		return 0, false
+	}
+	return atomic.LoadInt64(&w.n), true
+}
+
+// deadlineWriter extends the write deadline of a response by timeout on every write to it, so the deadline bounds each
+// write rather than the whole response
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(w.timeout)) // not all ResponseWriters support deadlines
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) Flush() {
+	w.rc.Flush()
 }
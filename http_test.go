@@ -0,0 +1,189 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteErrSeverity(t *testing.T) {
+	t.Parallel()
+	opErr := func(err error) error {
+		return &net.OpError{
+			Op:  "write",
+			Net: "tcp",
+			Err: err}
+	}
+	cases := []struct {
+		name     string
+		err      error
+		expected slog.Severity
+	}{
+		{"broken pipe", opErr(os.NewSyscallError("write", syscall.EPIPE)), slog.DebugSeverity},
+		{"wrapped broken pipe", fmt.Errorf("copying: %w", opErr(os.NewSyscallError("write", syscall.EPIPE))),
+			slog.DebugSeverity},
+		{"connection reset", opErr(os.NewSyscallError("write", syscall.ECONNRESET)), slog.DebugSeverity},
+		{"connection aborted", fmt.Errorf("a: %w", fmt.Errorf("b: %w", syscall.ECONNABORTED)), slog.DebugSeverity},
+		{"closed connection", opErr(net.ErrClosed), slog.DebugSeverity},
+		{"cancelled", fmt.Errorf("writing body: %w", context.Canceled), slog.DebugSeverity},
+		{"handler timeout", fmt.Errorf("%w", http.ErrHandlerTimeout), slog.DebugSeverity},
+		{"flattened broken pipe", errors.New("write tcp 127.0.0.1:80->127.0.0.1:1234: write: broken pipe"),
+			slog.DebugSeverity},
+		{"http2 disconnect", errors.New("client disconnected"), slog.DebugSeverity},
+		{"write timeout", opErr(os.ErrDeadlineExceeded), slog.InfoSeverity},
+		{"wrapped write timeout", fmt.Errorf("copying: %w", opErr(os.ErrDeadlineExceeded)), slog.InfoSeverity},
+		{"no space", opErr(os.NewSyscallError("write", syscall.ENOSPC)), slog.ErrorSeverity},
+		{"unexpected eof", fmt.Errorf("writing body: %w", io.ErrUnexpectedEOF), slog.ErrorSeverity},
+		{"http2 stream closed", errors.New("http2: stream closed"), slog.ErrorSeverity},
+		{"other", errors.New("something went wrong"), slog.ErrorSeverity}}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, writeErrSeverity(c.err), c.name)
+	}
+}
+
+func TestHttpHandlerBodyReadErrorSeverity(t *testing.T) {
+	logs := captureLogs(t)
+	// An upstream resetting the connection mid-body is a real failure, even though the same error writing to the
+	// client would not be
+	upstreamErr := fmt.Errorf("reading body: %w", &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: os.NewSyscallError("read", syscall.ECONNRESET)})
+	h := HttpHandler(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Body = ioutil.NopCloser(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(upstreamErr)))
+		if req.URL.Path == "/stream" {
+			rsp.Header.Set("Transfer-Encoding", "chunked")
+		}
+		return rsp
+	})
+	for _, path := range []string{"/buffered", "/stream"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	require.Len(t, logs.events, 2)
+	for _, ev := range logs.events {
+		assert.Equal(t, slog.ErrorSeverity, ev.Severity, ev.Message)
+	}
+}
+
+func TestHttpHandlerProxiedContentLength(t *testing.T) {
+	t.Parallel()
+	payload := strings.Repeat("0123456789", 1000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		for i := 0; i < len(payload); i += 1000 {
+			io.WriteString(rw, payload[i:i+1000])
+		}
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rt := NewRoundTripper(DefaultClientConfig())
+	defer rt.(*http.Transport).CloseIdleConnections()
+	s, err := Listen(ProxyService(target, ProxyOptions{
+		Client: HttpService(rt)}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// Without the upstream's Content-Length, a body this large would be chunked
+	rsp, err := http.Get("http://" + s.Listener().Addr().String() + "/")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, int64(len(payload)), rsp.ContentLength)
+	assert.Empty(t, rsp.TransferEncoding)
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(b))
+}
+
+func TestHasKnownLength(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "GET", "/", nil)
+	rsp := req.Response("hello")
+	assert.False(t, hasKnownLength(rsp), "without a Content-Length header")
+	rsp.Header.Set("Content-Length", strconv.FormatInt(rsp.ContentLength, 10))
+	assert.True(t, hasKnownLength(rsp))
+	rsp.Header.Set("Content-Length", "1")
+	assert.False(t, hasKnownLength(rsp), "with a mismatched Content-Length header")
+
+	// Buffered bodies are measured
+	rsp = NewResponse(req)
+	rsp.Write([]byte("hello"))
+	rsp.ContentLength = 3
+	rsp.Header.Set("Content-Length", "3")
+	assert.False(t, hasKnownLength(rsp))
+
+	s := Streamer()
+	defer s.Close()
+	rsp = NewResponse(req)
+	rsp.Body = s
+	rsp.ContentLength = 5
+	rsp.Header.Set("Content-Length", "5")
+	assert.False(t, hasKnownLength(rsp), "for a streaming response")
+}
+
+func TestRequestBytesWritten(t *testing.T) {
+	logs := captureLogs(t)
+	_, ok := NewRequest(nil, "GET", "/", nil).BytesWritten()
+	assert.False(t, ok, "for a request not received by HttpHandler")
+
+	payload := strings.Repeat("0123456789", 10000)
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/buffered" {
+			return req.Response(payload)
+		}
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for i := 0; i < len(payload); i += 10000 {
+				s.Write([]byte(payload[i : i+10000]))
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	}).Filter(AccessLogFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// AccessLogFilter reports the bytes written to the client, which it logs once the handler has closed the body
+	for _, path := range []string{"/streaming", "/buffered"} {
+		rsp, err := http.Get("http://" + s.Listener().Addr().String() + path)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		require.NoError(t, err)
+
+		var ev slog.Event
+		logged := false
+		for deadline := time.Now().Add(time.Second); !logged && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			logs.Lock()
+			for _, e := range logs.events {
+				if e.Metadata["path"] == path {
+					ev, logged = e, true
+				}
+			}
+			logs.Unlock()
+		}
+		require.True(t, logged, path)
+		assert.Equal(t, strconv.Itoa(len(b)), ev.Metadata["bytes"], path)
+	}
+}
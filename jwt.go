@@ -0,0 +1,105 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/monzo/terrors"
+)
+
+// JWTOptions configures the checks made by JWTFilter, beyond the token's signature and expiry
+type JWTOptions struct {
+	// Issuer, if set, must match the token's iss claim
+	Issuer string
+	// Audience, if set, must be among the token's aud claim
+	Audience string
+	// ValidMethods, if set, are the signing algorithms (eg. "RS256") accepted. The Keyfunc should in any case check
+	// the token's algorithm is the one expected for the key it returns.
+	ValidMethods []string
+	// Leeway is the clock skew tolerated when checking the exp, nbf and iat claims
+	Leeway time.Duration
+	// RequiredClaims are claims which must be present in the token, in addition to exp
+	RequiredClaims []string
+	// Realm is included in the WWW-Authenticate challenge of rejected requests (default "Restricted")
+	Realm string
+}
+
+type jwtClaimsContextKeyType struct{}
+
+var jwtClaimsContextKey = jwtClaimsContextKeyType{}
+
+// JWTClaims returns the claims of the token verified by JWTFilter, or false if there are none
+func JWTClaims(ctx context.Context) (jwt.MapClaims, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	claims, ok := ctx.Value(jwtClaimsContextKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+// JWTFilter returns a Filter which only passes requests to the service if they carry a valid JWT as a bearer token in
+// their Authorization header. The token's signature is verified with the key returned by keyfunc, it must have an
+// unexpired exp claim, any nbf and iat claims must not be in the future, and it must satisfy the options. The token's
+// claims are available to the service via JWTClaims. Other requests are rejected with a 401 and a WWW-Authenticate
+// challenge.
+func JWTFilter(keyfunc jwt.Keyfunc, opts JWTOptions) Filter {
+	if opts.Realm == "" {
+		opts.Realm = "Restricted"
+	}
+	parser := &jwt.Parser{
+		ValidMethods:         opts.ValidMethods,
+		UseJSONNumber:        true,
+		SkipClaimsValidation: true} // validated below, allowing for leeway
+	return func(req Request, svc Service) Response {
+		auth := req.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			rsp := NewResponse(req)
+			rsp.Header.Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, opts.Realm))
+			rsp.Error = terrors.Unauthorized("jwt", "Missing bearer token", nil)
+			return rsp
+		}
+
+		claims := jwt.MapClaims{}
+		_, err := parser.ParseWithClaims(strings.TrimSpace(auth[7:]), claims, keyfunc)
+		if err == nil {
+			err = opts.validate(claims)
+		}
+		if err != nil {
+			rsp := NewResponse(req)
+			rsp.Header.Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, opts.Realm))
+			rsp.Error = terrors.Unauthorized("jwt", "Invalid bearer token", map[string]string{
+				"reason": err.Error()})
+			return rsp
+		}
+		if req.Context == nil {
+			req.Context = context.Background()
+		}
+		req.Context = context.WithValue(req.Context, jwtClaimsContextKey, claims)
+		return svc(req)
+	}
+}
+
+func (o JWTOptions) validate(claims jwt.MapClaims) error {
+	now, leeway := time.Now().Unix(), int64(o.Leeway/time.Second)
+	switch {
+	case !claims.VerifyExpiresAt(now-leeway, true):
+		return fmt.Errorf("token is expired or has no expiry")
+	case !claims.VerifyNotBefore(now+leeway, false):
+		return fmt.Errorf("token is not valid yet")
+	case !claims.VerifyIssuedAt(now+leeway, false):
+		return fmt.Errorf("token was issued in the future")
+	case o.Issuer != "" && !claims.VerifyIssuer(o.Issuer, true):
+		return fmt.Errorf("token has the wrong issuer")
+	case o.Audience != "" && !claims.VerifyAudience(o.Audience, true):
+		return fmt.Errorf("token has the wrong audience")
+	}
+	for _, c := range o.RequiredClaims {
+		if _, ok := claims[c]; !ok {
+			return fmt.Errorf("token is missing claim %s", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package typhon
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTFilter(t *testing.T) {
+	t.Parallel()
+	secret := []byte("s3cret")
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return secret, nil
+	}
+	svc := Service(func(req Request) Response {
+		claims, ok := JWTClaims(req)
+		require.True(t, ok)
+		return req.Response(claims["sub"])
+	}).Filter(JWTFilter(keyfunc, JWTOptions{
+		Issuer:         "gateway",
+		Audience:       "payments",
+		Leeway:         30 * time.Second,
+		RequiredClaims: []string{"sub"}})).Filter(ErrorFilter)
+
+	sign := func(claims jwt.MapClaims, key []byte) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+	send := func(auth string) Response {
+		req := NewRequest(nil, "GET", "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return svc(req)
+	}
+	now := time.Now().Unix()
+	valid := jwt.MapClaims{
+		"sub": "user_1",
+		"iss": "gateway",
+		"aud": []string{"ledger", "payments"},
+		"exp": now + 60}
+
+	rsp := send("Bearer " + sign(valid, secret))
+	require.NoError(t, rsp.Error)
+	sub := ""
+	require.NoError(t, rsp.Decode(&sub))
+	assert.Equal(t, "user_1", sub)
+
+	// Expired, but within the leeway
+	skewed := jwt.MapClaims{}
+	for k, v := range valid {
+		skewed[k] = v
+	}
+	skewed["exp"] = now - 10
+	skewed["nbf"] = now + 10
+	require.NoError(t, send("bearer "+sign(skewed, secret)).Error)
+
+	// A request without a context
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Context = nil
+	req.Header.Set("Authorization", "Bearer "+sign(valid, secret))
+	require.NoError(t, svc(req).Error)
+
+	rsp = send("")
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	assert.Equal(t, `Bearer realm="Restricted"`, rsp.Header.Get("WWW-Authenticate"))
+
+	invalid := map[string]func(c jwt.MapClaims){
+		"expired":      func(c jwt.MapClaims) { c["exp"] = now - 60 },
+		"no expiry":    func(c jwt.MapClaims) { delete(c, "exp") },
+		"future":       func(c jwt.MapClaims) { c["nbf"] = now + 60 },
+		"issuer":       func(c jwt.MapClaims) { c["iss"] = "someone" },
+		"audience":     func(c jwt.MapClaims) { c["aud"] = "ledger" },
+		"missing sub":  func(c jwt.MapClaims) { delete(c, "sub") },
+		"wrong secret": nil}
+	for name, modify := range invalid {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		key := secret
+		if modify != nil {
+			modify(claims)
+		} else {
+			key = []byte("wrong")
+		}
+		rsp = send("Bearer " + sign(claims, key))
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode, name)
+		assert.True(t, terrors.PrefixMatches(rsp.Error, "unauthorized.jwt"), name)
+		assert.Equal(t, `Bearer realm="Restricted", error="invalid_token"`, rsp.Header.Get("WWW-Authenticate"), name)
+	}
+}
@@ -0,0 +1,26 @@
+package typhon
+
+// MaxBodyFilter returns a Filter which limits request bodies to maxBytes. Requests declaring a larger Content-Length
+// are rejected with 413 Payload Too Large before reaching the service; otherwise, reads beyond the limit fail, and the
+// service's response is replaced with a 413. The limit applies to the body as this filter sees it, so if a filter
+// which decompresses request bodies runs first, it is the decompressed size which is limited.
+func MaxBodyFilter(maxBytes int64) Filter {
+	return func(req Request, svc Service) Response {
+		if req.Body == nil {
+			return svc(req)
+		}
+		if req.ContentLength > maxBytes {
+			return Response{
+				Error: bodyTooLarge(maxBytes)}
+		}
+		body := newLimitReader(req.Body, maxBytes, bodyTooLarge(maxBytes))
+		req.Body = body
+		rsp := svc(req)
+		if body.remaining < 0 {
+			// The service may not have surfaced the error itself
+			return Response{
+				Error: bodyTooLarge(maxBytes)}
+		}
+		return rsp
+	}
+}
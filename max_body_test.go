@@ -0,0 +1,50 @@
+package typhon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodyFilter(t *testing.T) {
+	t.Parallel()
+	called := false
+	svc := Service(func(req Request) Response {
+		called = true
+		b, _ := ioutil.ReadAll(req.Body) // deliberately ignores the error
+		return req.Response(len(b))
+	}).Filter(MaxBodyFilter(10)).Filter(ErrorFilter)
+
+	send := func(body string, contentLength int64) Response {
+		called = false
+		req := NewRequest(nil, "POST", "/", nil)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.ContentLength = contentLength
+		return svc(req)
+	}
+
+	// A body of exactly the limit is fine
+	rsp := send("0123456789", -1)
+	require.NoError(t, rsp.Error)
+	n := 0
+	require.NoError(t, rsp.Decode(&n))
+	assert.Equal(t, 10, n)
+
+	// A declared length over the limit is rejected without calling the service
+	rsp = send("0123456789a", 11)
+	assert.False(t, called)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrBodyTooLarge))
+
+	// An undeclared one fails once read, even if the service ignores the error
+	rsp = send(string(bytes.Repeat([]byte("a"), 100)), -1)
+	assert.True(t, called)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrBodyTooLarge))
+}
@@ -0,0 +1,211 @@
+package typhon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// memoryRemoteAddr is the RemoteAddr of requests served by InMemoryRoundTripper
+const memoryRemoteAddr = "127.0.0.1:0"
+
+// InMemoryClient returns a Service which sends requests to svc in-process, without touching the network. Requests and
+// responses go through the same encoding, decoding and HTTP handling (see HttpHandler) as they would over a real
+// connection, so it is well suited to testing services end-to-end. It is equivalent to
+// HttpService(InMemoryRoundTripper(svc)).
+func InMemoryClient(svc Service) Service {
+	return HttpService(InMemoryRoundTripper(svc))
+}
+
+// InMemoryRoundTripper returns a http.RoundTripper which serves requests with svc in-process, rather than sending them
+// over the network. Responses which are flushed by the handler (such as streaming responses) are streamed back to the
+// caller; otherwise responses are buffered until the handler returns, and have a known Content-Length. Connection
+// hijacking (and hence WebSockets) is not supported.
+func InMemoryRoundTripper(svc Service) http.RoundTripper {
+	return &memoryTransport{
+		handler: HttpHandler(svc)}
+}
+
+type memoryTransport struct {
+	handler http.Handler
+}
+
+func (t *memoryTransport) RoundTrip(httpReq *http.Request) (*http.Response, error) {
+	ctx := httpReq.Context()
+	serverReq, err := memoryServerRequest(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	rw := &memoryResponseWriter{
+		header: make(http.Header),
+		rsp: &http.Response{
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Request:    httpReq},
+		ready: make(chan struct{})}
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				rw.fail(terrors.InternalService("panic", fmt.Sprintf("Panic serving in-memory request: %v", v), nil))
+				return
+			}
+			rw.finish()
+		}()
+		t.handler.ServeHTTP(rw, serverReq)
+	}()
+
+	select {
+	case <-rw.ready:
+		if rw.err != nil {
+			return nil, rw.err
+		}
+		return rw.rsp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// memoryServerRequest constructs the request a server would see upon receiving the given client request
+func memoryServerRequest(httpReq *http.Request) (*http.Request, error) {
+	if httpReq.URL == nil {
+		return nil, terrors.BadRequest("missing_url", "Request has no URL", nil)
+	}
+	serverReq := httpReq.Clone(httpReq.Context())
+	serverReq.RequestURI = httpReq.URL.RequestURI()
+	u, err := url.ParseRequestURI(serverReq.RequestURI)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	serverReq.URL = u
+	if serverReq.Host == "" {
+		serverReq.Host = httpReq.URL.Host
+	}
+	serverReq.RemoteAddr = memoryRemoteAddr
+	serverReq.Proto, serverReq.ProtoMajor, serverReq.ProtoMinor = "HTTP/1.1", 1, 1
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+	return serverReq, nil
+}
+
+// memoryResponseWriter is the ResponseWriter used by memoryTransport. Until it is flushed, writes are buffered; from
+// then on they are sent down a pipe to the response body. Only the handler's goroutine may use it.
+type memoryResponseWriter struct {
+	header      http.Header
+	rsp         *http.Response // may only be accessed by the caller once ready is closed
+	wroteHeader bool
+	buf         bytes.Buffer
+	pw          *io.PipeWriter // set once flushed
+	ready       chan struct{}  // closed once rsp (or err) can be returned to the caller
+	err         error
+}
+
+func (w *memoryResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *memoryResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.rsp.StatusCode = status
+	w.rsp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	w.rsp.Header = w.header.Clone()
+	for k := range w.rsp.Header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			w.rsp.Header.Del(k)
+		}
+	}
+	w.rsp.Header.Del("Trailer")
+}
+
+func (w *memoryResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pw != nil {
+		return w.pw.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush starts streaming the response to the caller
+func (w *memoryResponseWriter) Flush() {
+	if w.pw != nil {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.rsp.Body = &memoryBody{
+		Reader: io.MultiReader(bytes.NewReader(w.buf.Bytes()), pr),
+		pr:     pr}
+	w.rsp.ContentLength = -1
+	w.rsp.TransferEncoding = []string{"chunked"}
+	close(w.ready)
+}
+
+// finish is called once the handler has returned
+func (w *memoryResponseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.rsp.Trailer = w.trailer()
+	if w.pw != nil {
+		w.pw.Close()
+		return
+	}
+	w.rsp.Body = ioutil.NopCloser(bytes.NewReader(w.buf.Bytes()))
+	w.rsp.ContentLength = int64(w.buf.Len())
+	close(w.ready)
+}
+
+// fail is called if the handler panics
+func (w *memoryResponseWriter) fail(err error) {
+	if w.pw != nil {
+		w.pw.CloseWithError(err)
+		return
+	}
+	w.err = err
+	close(w.ready)
+}
+
+// trailer returns the trailers set by the handler: those declared in the Trailer header, and those set with
+// http.TrailerPrefix
+func (w *memoryResponseWriter) trailer() http.Header {
+	trailer := make(http.Header)
+	for _, v := range w.header["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vs, ok := w.header[k]; ok {
+				trailer[k] = vs
+			}
+		}
+	}
+	for k, vs := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vs
+		}
+	}
+	return trailer
+}
+
+// memoryBody is the body of a streaming in-memory response. Closing it unblocks the handler's writes.
+type memoryBody struct {
+	io.Reader
+	pr *io.PipeReader
+}
+
+func (b *memoryBody) Close() error {
+	return b.pr.Close()
+}
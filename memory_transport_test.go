@@ -0,0 +1,99 @@
+package typhon
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryClient(t *testing.T) {
+	t.Parallel()
+	type greeting struct {
+		Name string `json:"name"`
+	}
+	router := NewRouter()
+	router.POST("/greet", func(req Request) Response {
+		g := greeting{}
+		if err := req.Decode(&g); err != nil {
+			return Response{Error: err}
+		}
+		rsp := req.Response(greeting{
+			Name: "hello " + g.Name})
+		rsp.Header.Set("X-Remote-Addr", req.RemoteAddr)
+		return rsp
+	})
+	router.GET("/fail", func(req Request) Response {
+		return Response{Error: terrors.NotFound("thing", "No such thing", nil)}
+	})
+	router.GET("/stream", func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for _, c := range []string{"a", "b", "c"} {
+				s.Write([]byte(c))
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	svc := router.Serve().Filter(ErrorFilter).Filter(GzipFilter)
+	client := InMemoryClient(svc).Filter(ErrorFilter)
+	ctx := context.Background()
+
+	rsp := NewRequest(ctx, "POST", "http://svc/greet", greeting{Name: "world"}).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, memoryRemoteAddr, rsp.Header.Get("X-Remote-Addr"))
+	g := greeting{}
+	require.NoError(t, rsp.Decode(&g))
+	assert.Equal(t, "hello world", g.Name)
+
+	rsp = NewRequest(ctx, "GET", "http://svc/fail", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrNotFound))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+	rsp = NewRequest(ctx, "GET", "http://svc/stream", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.True(t, isStreamingRsp(rsp))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+}
+
+func TestInMemoryClientStreamError(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			s.Write([]byte("partial"))
+			s.(ErrorCloser).CloseWithError(terrors.InternalService("broken", "Stream broke", nil))
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	rsp := NewRequest(context.Background(), "GET", "http://svc/", nil).SendVia(InMemoryClient(svc)).Response()
+	require.NoError(t, rsp.Error)
+	b, err := ioutil.ReadAll(rsp.Body)
+	assert.Equal(t, "partial", string(b))
+	require.Error(t, err)
+	assert.True(t, terrors.PrefixMatches(err, "internal_service.broken"))
+}
+
+func TestInMemoryClientPanic(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		panic("oops")
+	})
+	rsp := NewRequest(context.Background(), "GET", "http://svc/", nil).SendVia(InMemoryClient(svc)).Response()
+	require.Error(t, rsp.Error)
+	assert.NotEqual(t, io.EOF, rsp.Error)
+}
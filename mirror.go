@@ -0,0 +1,154 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// DefaultMirrorTimeout bounds how long a mirrored request may take, if MirrorOptions doesn't say otherwise
+const DefaultMirrorTimeout = 5 * time.Second
+
+// MirrorOptions configures MirrorFilterWithOptions
+type MirrorOptions struct {
+	// Timeout bounds each mirrored request. If zero, DefaultMirrorTimeout is used.
+	Timeout time.Duration
+	// Compare, if set, is called with each mirrored request along with copies of the primary's response and the
+	// shadow's, once both are available: for example, to count differences in a metric. It is called in its own
+	// goroutine, and the shadow response's body is closed when it returns. The primary's response is buffered so that
+	// it can be copied; streaming responses are not compared.
+	Compare func(req Request, primary, shadow Response)
+}
+
+// MirrorFilter returns a Filter which sends a copy of a fraction (sample, from 0 to 1) of requests to the shadow
+// service as well as the real one. Mirrored requests are sent asynchronously, with their own timeout, and their
+// responses are discarded: the client only ever sees the real service's response, so the shadow's errors (or
+// slowness) can't affect it. Bodies of sampled requests are buffered, so that both services can read them; requests
+// whose bodies can't be replayed in full (see Request.Rewindable) aren't mirrored.
+func MirrorFilter(shadow Service, sample float64) Filter {
+	return MirrorFilterWithOptions(shadow, sample, MirrorOptions{})
+}
+
+// MirrorFilterWithOptions is like MirrorFilter, but with the given options
+func MirrorFilterWithOptions(shadow Service, sample float64, opts MirrorOptions) Filter {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultMirrorTimeout
+	}
+	var compare func(Request, Response, []Response)
+	if opts.Compare != nil {
+		compare = func(req Request, primary Response, shadows []Response) {
+			opts.Compare(req, primary, shadows[0])
+		}
+	}
+	shadows := []Service{shadow}
+	return func(req Request, svc Service) Response {
+		if sample <= 0 || (sample < 1 && rand.Float64() >= sample) {
+			return svc(req)
+		}
+		return mirror(req, svc, shadows, opts.Timeout, compare)
+	}
+}
+
+// mirror sends req to primary, returning its response, and asynchronously sends a copy of it to each of the shadows.
+// Each copy has its own timeout, and isn't cancelled along with req. If compare is set, it is called in its own
+// goroutine once all the responses are available, with a copy of the primary's (unless it is streaming) and each of the
+// shadows' in turn; their bodies are closed when it returns. A request whose body can't be replayed in full is sent
+// only to the primary.
+func mirror(req Request, primary Service, shadows []Service, timeout time.Duration,
+	compare func(req Request, primary Response, shadows []Response)) Response {
+	if len(shadows) == 0 || !req.replayable() {
+		return primary(req)
+	}
+	if req.Body != nil {
+		if _, err := req.BodyBytes(false); err != nil {
+			rsp := NewResponse(req)
+			rsp.Error = terrors.Wrap(err, nil)
+			return rsp
+		}
+	}
+	parent := context.Context(context.Background())
+	if req.Context != nil {
+		parent = context.WithoutCancel(req.Context)
+	}
+
+	rsps := make([]Response, len(shadows))
+	cancels := make([]context.CancelFunc, len(shadows))
+	wg := sync.WaitGroup{}
+	for i, s := range shadows {
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		cancels[i] = cancel
+		wg.Add(1)
+		go func(i int, s Service, req Request) {
+			defer wg.Done()
+			rsps[i] = sendShadow(s, req)
+		}(i, s, req.Clone(ctx))
+	}
+
+	var primaries chan Response
+	if compare != nil {
+		primaries = make(chan Response, 1)
+	}
+	cmpReq := req.Clone(parent)
+	go func() {
+		wg.Wait()
+		defer func() {
+			for i, rsp := range rsps {
+				if rsp.Response != nil && rsp.Body != nil {
+					rsp.Body.Close()
+				}
+				cancels[i]()
+			}
+		}()
+		if primaries != nil {
+			if p, ok := <-primaries; ok {
+				compare(cmpReq, p, rsps)
+			}
+		}
+	}()
+
+	rsp := primary(req)
+	if primaries != nil {
+		if c, ok := comparableCopy(req, &rsp); ok {
+			primaries <- c
+		}
+		close(primaries)
+	}
+	return rsp
+}
+
+// sendShadow sends a request to a shadow service, turning any panic into an error response
+func sendShadow(svc Service, req Request) (rsp Response) {
+	defer func() {
+		if v := recover(); v != nil {
+			slog.Error(req, "Recovered from panic in shadow service for %v: %v\n%s", req, v, debug.Stack())
+			rsp = NewResponse(req)
+			rsp.Error = terrors.InternalService("panic", fmt.Sprintf("Panic serving request: %v", v), nil)
+		}
+	}()
+	return svc(req)
+}
+
+// comparableCopy returns a copy of rsp for comparison, buffering its body (which remains readable), or false if it is
+// streaming or its body can't be read
+func comparableCopy(req Request, rsp *Response) (Response, bool) {
+	shared := &sharedResponse{
+		rsp: *rsp}
+	if rsp.Response != nil && rsp.Body != nil {
+		if isStreamingRsp(*rsp) {
+			return Response{}, false
+		}
+		b, err := rsp.BodyBytes(false)
+		if err != nil {
+			rsp.Error = err
+			return Response{}, false
+		}
+		shared.body = b
+	}
+	return shared.copyFor(req), true
+}
@@ -0,0 +1,117 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorFilter(t *testing.T) {
+	t.Parallel()
+	type comparison struct {
+		primaryStatus, shadowStatus int
+		primaryBody, shadowBody     string
+	}
+	compared := make(chan comparison, 1)
+	shadowBodies := make(chan string, 1)
+	shadow := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		require.NoError(t, err)
+		shadowBodies <- string(b)
+		_, hasDeadline := req.Deadline()
+		assert.True(t, hasDeadline)
+		rsp := req.Response("shadow")
+		rsp.StatusCode = http.StatusAccepted
+		return rsp
+	})
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		require.NoError(t, err)
+		return req.Response("primary: " + string(b))
+	}).Filter(MirrorFilterWithOptions(shadow, 1, MirrorOptions{
+		Compare: func(req Request, primary, shadow Response) {
+			c := comparison{
+				primaryStatus: primary.StatusCode,
+				shadowStatus:  shadow.StatusCode}
+			assert.NoError(t, primary.Decode(&c.primaryBody))
+			assert.NoError(t, shadow.Decode(&c.shadowBody))
+			compared <- c
+		}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rsp := svc(NewRequest(ctx, "POST", "/", "body"))
+	cancel() // Cancelling the original request doesn't cancel the mirrored one
+	require.NoError(t, rsp.Error)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "primary: \"body\"\n", body)
+	assert.Equal(t, "\"body\"\n", <-shadowBodies)
+
+	assert.Equal(t, comparison{
+		primaryStatus: http.StatusOK,
+		shadowStatus:  http.StatusAccepted,
+		primaryBody:   "primary: \"body\"\n",
+		shadowBody:    "shadow"}, <-compared)
+}
+
+func TestMirrorFilterIsolatesShadow(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{}, 3)
+	shadows := []Service{
+		func(req Request) Response {
+			defer func() { done <- struct{}{} }()
+			panic("boom")
+		},
+		func(req Request) Response {
+			defer func() { done <- struct{}{} }()
+			return Response{
+				Error: terrors.InternalService("", "Shadow failed", nil)}
+		},
+		func(req Request) Response {
+			defer func() { done <- struct{}{} }()
+			<-req.Done() // The shadow's own timeout applies
+			return Response{
+				Error: terrors.Wrap(req.Err(), nil)}
+		}}
+	for _, shadow := range shadows {
+		svc := Service(func(req Request) Response {
+			return req.Response("primary")
+		}).Filter(MirrorFilterWithOptions(shadow, 1, MirrorOptions{
+			Timeout: 10 * time.Millisecond}))
+		rsp := svc(NewRequest(nil, "GET", "/", nil))
+		require.NoError(t, rsp.Error)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, "primary", body)
+	}
+	for range shadows {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Shadow request didn't finish")
+		}
+	}
+}
+
+func TestMirrorFilterSampling(t *testing.T) {
+	t.Parallel()
+	var mirrored int32
+	shadow := Service(func(req Request) Response {
+		atomic.AddInt32(&mirrored, 1)
+		return NewResponse(req)
+	})
+	svc := Service(func(req Request) Response {
+		return NewResponse(req)
+	})
+	for i := 0; i < 100; i++ {
+		svc.Filter(MirrorFilter(shadow, 0))(NewRequest(nil, "GET", "/", nil))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&mirrored))
+}
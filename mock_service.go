@@ -0,0 +1,229 @@
+package typhon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+// A RequestMatcher is a predicate over requests, used to select the requests a MockService stub applies to
+type RequestMatcher func(req Request) bool
+
+// MatchRequest returns a RequestMatcher which matches requests with the given method and URL path. An empty method or
+// path matches any.
+func MatchRequest(method, path string) RequestMatcher {
+	return func(req Request) bool {
+		if method != "" && !strings.EqualFold(req.Method, method) {
+			return false
+		}
+		if path != "" && (req.URL == nil || req.URL.Path != path) {
+			return false
+		}
+		return true
+	}
+}
+
+// MatchAny is a RequestMatcher which matches all requests
+func MatchAny(req Request) bool {
+	return true
+}
+
+// TestingT is the subset of *testing.T used by MockService's assertions
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type mockStub struct {
+	match RequestMatcher
+	svc   Service
+}
+
+type mockCall struct {
+	req  Request
+	body []byte
+}
+
+// A MockService is a Service for use in tests, which responds to requests with programmed stubs and records the
+// requests it receives so they can be asserted upon afterwards. It is safe for concurrent use.
+//
+// Requests which match no stub fail with a not found error.
+type MockService struct {
+	m     sync.Mutex
+	stubs []mockStub
+	calls []mockCall
+}
+
+// NewMockService returns a MockService with no stubs
+func NewMockService() *MockService {
+	return &MockService{}
+}
+
+// Stub arranges for requests matching match to be handled by svc. Stubs are tried in the order they were added; the
+// first which matches handles the request.
+func (m *MockService) Stub(match RequestMatcher, svc Service) *MockService {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.stubs = append(m.stubs, mockStub{
+		match: match,
+		svc:   svc})
+	return m
+}
+
+// StubResponse arranges for requests matching match to receive a response with the given body (see Request.Response)
+func (m *MockService) StubResponse(match RequestMatcher, body interface{}) *MockService {
+	return m.Stub(match, func(req Request) Response {
+		return req.Response(body)
+	})
+}
+
+// StubError arranges for requests matching match to fail with the given error
+func (m *MockService) StubError(match RequestMatcher, err error) *MockService {
+	return m.Stub(match, func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Error = err
+		return rsp
+	})
+}
+
+// Serve returns a Service which records requests and dispatches them to the matching stub
+func (m *MockService) Serve() Service {
+	return m.serve
+}
+
+func (m *MockService) serve(req Request) Response {
+	// Buffer the body so it can be both recorded and read by the stub
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+		body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	m.m.Lock()
+	m.calls = append(m.calls, mockCall{
+		req:  req,
+		body: body})
+	var svc Service
+	for _, stub := range m.stubs {
+		if stub.match(req) {
+			svc = stub.svc
+			break
+		}
+	}
+	m.m.Unlock()
+
+	if svc == nil {
+		rsp := NewResponse(req)
+		rsp.Error = terrors.NotFound("mock_stub", "No stub matches the request", map[string]string{
+			"method": req.Method,
+			"path":   requestPath(req)})
+		return rsp
+	}
+	return svc(req)
+}
+
+// Calls returns the requests received so far, in the order they were received. Each has a fresh copy of the request
+// body, so may be decoded.
+func (m *MockService) Calls() []Request {
+	m.m.Lock()
+	defer m.m.Unlock()
+	reqs := make([]Request, len(m.calls))
+	for i, c := range m.calls {
+		reqs[i] = c.request()
+	}
+	return reqs
+}
+
+// CallCount returns the number of requests received so far which match match
+func (m *MockService) CallCount(match RequestMatcher) int {
+	n := 0
+	for _, req := range m.Calls() {
+		if match(req) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset forgets all recorded requests (but not stubs)
+func (m *MockService) Reset() {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.calls = nil
+}
+
+// AssertCalledWith asserts that at least one request matching match has been received. It returns whether the
+// assertion held.
+func (m *MockService) AssertCalledWith(t TestingT, match RequestMatcher) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if m.CallCount(match) == 0 {
+		t.Errorf("Expected a matching request, but none was received (received: %s)", m.describeCalls())
+		return false
+	}
+	return true
+}
+
+// AssertNotCalledWith asserts that no request matching match has been received. It returns whether the assertion
+// held.
+func (m *MockService) AssertNotCalledWith(t TestingT, match RequestMatcher) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if n := m.CallCount(match); n > 0 {
+		t.Errorf("Expected no matching requests, but received %d", n)
+		return false
+	}
+	return true
+}
+
+// AssertCallCount asserts that exactly n requests matching match have been received. It returns whether the
+// assertion held.
+func (m *MockService) AssertCallCount(t TestingT, match RequestMatcher, n int) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if actual := m.CallCount(match); actual != n {
+		t.Errorf("Expected %d matching requests, but received %d (received: %s)", n, actual, m.describeCalls())
+		return false
+	}
+	return true
+}
+
+func (m *MockService) describeCalls() string {
+	reqs := m.Calls()
+	if len(reqs) == 0 {
+		return "none"
+	}
+	descs := make([]string, len(reqs))
+	for i, req := range reqs {
+		descs[i] = fmt.Sprintf("%s %s", req.Method, requestPath(req))
+	}
+	return strings.Join(descs, ", ")
+}
+
+// request returns the recorded request, with a fresh body
+func (c mockCall) request() Request {
+	req := c.req
+	if req.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(c.body))
+	}
+	return req
+}
+
+func requestPath(req Request) string {
+	if req.URL == nil {
+		return ""
+	}
+	return req.URL.Path
+}
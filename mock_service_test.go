@@ -0,0 +1,82 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMockService(t *testing.T) {
+	t.Parallel()
+	m := NewMockService().
+		StubResponse(MatchRequest("GET", "/foo"), map[string]string{"a": "b"}).
+		StubError(MatchRequest("POST", "/foo"), terrors.Forbidden("nope", "Nope", nil))
+	client := m.Serve().Filter(ErrorFilter)
+	ctx := context.Background()
+
+	rsp := NewRequest(ctx, "GET", "http://svc/foo", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "b", body["a"])
+
+	rsp = NewRequest(ctx, "POST", "http://svc/foo", map[string]string{"x": "y"}).SendVia(client).Response()
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrForbidden))
+
+	rsp = NewRequest(ctx, "GET", "http://svc/bar", nil).SendVia(client).Response()
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrNotFound+".mock_stub"))
+
+	// Recorded requests retain their bodies
+	calls := m.Calls()
+	require.Len(t, calls, 3)
+	sent := map[string]string{}
+	require.NoError(t, calls[1].Decode(&sent))
+	assert.Equal(t, "y", sent["x"])
+
+	assert.Equal(t, 2, m.CallCount(MatchRequest("", "/foo")))
+	assert.True(t, m.AssertCalledWith(t, func(req Request) bool {
+		b, _ := req.BodyBytes(true)
+		return req.Method == "POST" && string(b) == "{\"x\":\"y\"}\n"
+	}))
+	assert.True(t, m.AssertCallCount(t, MatchAny, 3))
+	assert.True(t, m.AssertNotCalledWith(t, MatchRequest("DELETE", "")))
+
+	rt := &recordingT{}
+	assert.False(t, m.AssertCalledWith(rt, MatchRequest("PUT", "")))
+	assert.False(t, m.AssertCallCount(rt, MatchAny, 1))
+	assert.False(t, m.AssertNotCalledWith(rt, MatchAny))
+	assert.Len(t, rt.errors, 3)
+	assert.Contains(t, rt.errors[0], "GET /foo, POST /foo, GET /bar")
+
+	m.Reset()
+	assert.Empty(t, m.Calls())
+}
+
+func TestMockServiceConcurrent(t *testing.T) {
+	t.Parallel()
+	m := NewMockService().StubResponse(MatchAny, "ok")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := NewRequest(context.Background(), "GET", "http://svc/", nil).SendVia(m.Serve()).Response()
+			assert.NoError(t, rsp.Error)
+		}()
+	}
+	wg.Wait()
+	m.AssertCallCount(t, MatchAny, 20)
+}
@@ -0,0 +1,132 @@
+package typhon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// An NDJSONStreamer streams newline-delimited JSON values to a client. It is safe for concurrent use.
+type NDJSONStreamer struct {
+	m      sync.Mutex
+	s      *streamer
+	done   chan struct{}
+	closer sync.Once
+}
+
+// NDJSON turns the response into a stream of newline-delimited JSON values, returning an NDJSONStreamer through which
+// values are sent. The stream ends when the streamer is closed, or when the request's context is done.
+func (r *Response) NDJSON() *NDJSONStreamer {
+	body := Streamer().(*streamer)
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", ndjsonContentType)
+	r.Header.Del("Content-Length")
+
+	s := &NDJSONStreamer{
+		s:    body,
+		done: make(chan struct{})}
+	if r.Request != nil && r.Request.Context != nil {
+		go func(ctxDone <-chan struct{}) {
+			select {
+			case <-ctxDone:
+				s.Close()
+			case <-s.done:
+			}
+		}(r.Request.Done())
+	}
+	return s
+}
+
+// Encode serialises v as JSON (with NewJSONEncoder) and sends it as the next line of the stream. If v can't be
+// serialised, the stream is ended with the error (so that the client doesn't mistake it for a complete stream), and the
+// error is returned.
+func (s *NDJSONStreamer) Encode(v interface{}) error {
+	buf := &bytes.Buffer{}
+	err := encodeJSON(buf, v)
+	if err != nil {
+		err = terrors.Wrap(err, nil)
+		s.finish(err)
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	select {
+	case <-s.done:
+		return terrors.InternalService("stream_closed", "Stream has ended", nil)
+	default:
+	}
+	_, err = s.s.Write(buf.Bytes())
+	return err
+}
+
+// Done returns a channel which is closed when the stream ends
+func (s *NDJSONStreamer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream
+func (s *NDJSONStreamer) Close() error {
+	return s.finish(nil)
+}
+
+func (s *NDJSONStreamer) finish(err error) error {
+	s.closer.Do(func() {
+		close(s.done)
+	})
+	return s.s.CloseWithError(err) // unblocks any write in progress
+}
+
+// DecodeStream de-serialises a stream of newline-delimited JSON values from the body (such as one sent through an
+// NDJSONStreamer). Each value in turn is decoded into v, which must be a pointer and is reset beforehand, and then fn
+// is called. Decoding stops at the end of the stream, on the first error, or when fn returns an error, which is then
+// returned. The body is closed afterwards.
+func (r *Response) DecodeStream(v interface{}, fn func() error) error {
+	if r.Error != nil {
+		return r.Error
+	} else if r.Response == nil || r.Body == nil {
+		return terrors.InternalService("", "Response has no body", nil)
+	}
+	defer r.Body.Close()
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return terrors.InternalService("invalid_target", "DecodeStream requires a non-nil pointer", nil)
+	}
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && !isBlank(line) {
+			rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+			if err := json.Unmarshal(line, v); err != nil {
+				return terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+			}
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return terrors.Wrap(err, nil)
+		}
+	}
+}
+
+func isBlank(b []byte) bool {
+	for _, c := range b {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ndjsonRow struct {
+	N    int    `json:"n"`
+	Name string `json:"name,omitempty"`
+}
+
+func TestNDJSON(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		s := rsp.NDJSON()
+		go func() {
+			defer s.Close()
+			for i := 0; i < 3; i++ {
+				row := ndjsonRow{N: i}
+				if i == 0 {
+					row.Name = "first"
+				}
+				s.Encode(row)
+			}
+		}()
+		return rsp
+	})
+	srv, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer srv.Stop()
+
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", srv.Listener().Addr()), nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "application/x-ndjson", rsp.Header.Get("Content-Type"))
+	var rows []ndjsonRow
+	row := ndjsonRow{}
+	require.NoError(t, rsp.DecodeStream(&row, func() error {
+		rows = append(rows, row)
+		return nil
+	}))
+	assert.Equal(t, []ndjsonRow{{0, "first"}, {1, ""}, {2, ""}}, rows)
+}
+
+func TestNDJSONEncodingError(t *testing.T) {
+	rsp := NewResponse(NewRequest(nil, "GET", "/", nil))
+	s := rsp.NDJSON()
+	go func() {
+		s.Encode(ndjsonRow{N: 1})
+		assert.Error(t, s.Encode(func() {})) // functions can't be serialised
+	}()
+
+	n := 0
+	row := ndjsonRow{}
+	err := rsp.DecodeStream(&row, func() error {
+		n++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	assert.Error(t, s.Encode(ndjsonRow{N: 2}))
+}
+
+func TestNDJSONStopsEarly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rsp := NewResponse(NewRequest(ctx, "GET", "/", nil))
+	s := rsp.NDJSON()
+	go func() {
+		for i := 0; s.Encode(ndjsonRow{N: i}) == nil; i++ {
+		}
+	}()
+
+	row := ndjsonRow{}
+	stop := terrors.New("stop", "", nil)
+	err := rsp.DecodeStream(&row, func() error {
+		if row.N == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream didn't end when the context was cancelled")
+	}
+}
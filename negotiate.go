@@ -0,0 +1,124 @@
+package typhon
+
+import (
+	"encoding/xml"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An encoderFunc serialises v to w
+type encoderFunc func(w io.Writer, v interface{}) error
+
+// negotiableEncoder is an encoder which may be selected by content negotiation, and the media type it produces
+type negotiableEncoder struct {
+	mediaType   string
+	contentType string // The Content-Type header value to set
+	encode      encoderFunc
+}
+
+// negotiableEncoders are the encoders which may be chosen by Response.EncodeNegotiated, in order of preference
+var negotiableEncoders = []negotiableEncoder{
+	{"application/json", "application/json", encodeJSON},
+	{"application/xml", "application/xml; charset=utf-8", encodeXML},
+	{"text/xml", "text/xml; charset=utf-8", encodeXML}}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return NewJSONEncoder(w).Encode(v)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// acceptRange is a media range from an Accept header, with its quality value
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header into its media ranges, ordered by descending preference. Ranges which cannot
+// be parsed are omitted. Those with a quality of zero are kept, as they make the types they match unacceptable.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	// More specific ranges take precedence over wildcards of the same quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+	return ranges
+}
+
+// negotiateEncoder picks the most preferred encoder acceptable according to the given Accept header. An empty header
+// accepts anything; if nothing acceptable is available, ok is false.
+func negotiateEncoder(accept string) (enc negotiableEncoder, ok bool) {
+	mediaTypes := make([]string, len(negotiableEncoders))
+	for i, e := range negotiableEncoders {
+		mediaTypes[i] = e.mediaType
+	}
+	if i, ok := negotiateMediaType(accept, mediaTypes); ok {
+		return negotiableEncoders[i], true
+	}
+	return negotiableEncoder{}, false
+}
+
+// negotiateMediaType returns the index of the most preferred of the offered media types (which are in the server's
+// order of preference) acceptable according to the given Accept header. An empty header accepts anything; if nothing
+// offered is acceptable, ok is false.
+func negotiateMediaType(accept string, offered []string) (i int, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return 0, len(offered) > 0
+	}
+	ranges := parseAccept(accept)
+	best, bestRank := 0, len(ranges)
+	for i, mediaType := range offered {
+		if rank, ok := acceptRank(ranges, mediaType); ok && rank < bestRank {
+			best, bestRank = i, rank
+		}
+	}
+	return best, bestRank < len(ranges)
+}
+
+// acceptRank returns the position among the ranges (as ordered by parseAccept) of the most specific one which matches
+// the media type, or false if none does or that range has a quality of zero: a type which is refused explicitly isn't
+// accepted by a wildcard
+func acceptRank(ranges []acceptRange, mediaType string) (int, bool) {
+	rank, specificity := 0, -1
+	for i, r := range ranges {
+		if s := 2 - strings.Count(r.mediaType, "*"); s > specificity && mediaRangeMatches(r.mediaType, mediaType) {
+			rank, specificity = i, s
+		}
+	}
+	return rank, specificity >= 0 && ranges[rank].q > 0
+}
+
+// mediaRangeMatches returns whether the media type is within the given media range (which may contain wildcards)
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+	}
+	return false
+}
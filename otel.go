@@ -0,0 +1,132 @@
+package typhon
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelInstrumentationName identifies the tracer used by Typhon's OpenTelemetry filters
+const otelInstrumentationName = "github.com/monzo/typhon"
+
+// OTelOptions configures OTelFilter and OTelClientFilter. Zero values are replaced with defaults.
+type OTelOptions struct {
+	// TracerProvider creates the tracer with which spans are started (default otel.GetTracerProvider())
+	TracerProvider trace.TracerProvider
+	// Propagator extracts trace context from incoming requests, and injects it into outbound ones. The default is the
+	// registered global propagator if one has been set, otherwise W3C Trace Context (traceparent) and Baggage.
+	Propagator propagation.TextMapPropagator
+}
+
+func (o OTelOptions) tracer() trace.Tracer {
+	tp := o.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(otelInstrumentationName)
+}
+
+func (o OTelOptions) propagator() propagation.TextMapPropagator {
+	if o.Propagator != nil {
+		return o.Propagator
+	}
+	// The global propagator is a no-op unless one has been registered
+	if p := otel.GetTextMapPropagator(); len(p.Fields()) > 0 {
+		return p
+	}
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// OTelFilter returns a Filter which starts an OpenTelemetry server span for each incoming request, continuing any
+// trace propagated in its headers (eg. traceparent). Whether the span is sampled follows the configured sampler,
+// which by default honours the sampling decision of the incoming trace. The span is named after the Router route which
+// handled the request, and records the response's status code and any error; it ends once the response body has been
+// fully read or closed. The span's context is available to the service from the request's context, so outbound
+// requests sent with it (through OTelClientFilter) continue the trace.
+func OTelFilter(opts OTelOptions) Filter {
+	tracer, propagator := opts.tracer(), opts.propagator()
+	return func(req Request, svc Service) Response {
+		ctx := propagator.Extract(req.Context, propagation.HeaderCarrier(req.Header))
+		attrs := []attribute.KeyValue{
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPHostKey.String(req.Host)}
+		if req.URL != nil {
+			attrs = append(attrs, semconv.HTTPTargetKey.String(req.URL.RequestURI()))
+		}
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			attrs = append(attrs, semconv.NetPeerIPKey.String(ip))
+		}
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("HTTP %s", req.Method),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		ctx, pattern := withRoutePattern(ctx)
+		req.Context = ctx
+		rsp := svc(req)
+
+		if *pattern != "" {
+			span.SetName(fmt.Sprintf("%s %s", req.Method, *pattern))
+			span.SetAttributes(semconv.HTTPRouteKey.String(*pattern))
+		}
+		return endSpanWithBody(span, rsp, http.StatusInternalServerError)
+	}
+}
+
+// OTelClientFilter returns a Filter which starts an OpenTelemetry client span for each outbound request, as a child
+// of any span in the request's context, and injects its context into the request's headers so the trace continues in
+// the service called. The span records the response's status code and any error, and ends once the response body has
+// been fully read or closed.
+func OTelClientFilter(opts OTelOptions) Filter {
+	tracer, propagator := opts.tracer(), opts.propagator()
+	return func(req Request, svc Service) Response {
+		attrs := []attribute.KeyValue{
+			semconv.HTTPMethodKey.String(req.Method)}
+		if req.URL != nil {
+			attrs = append(attrs, semconv.HTTPURLKey.String(req.URL.String()))
+		}
+		ctx, span := tracer.Start(req.Context, fmt.Sprintf("HTTP %s", req.Method),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrs...))
+		req.Context = ctx
+		req.Header = cloneHeader(req.Header)
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		rsp := svc(req)
+		return endSpanWithBody(span, rsp, http.StatusBadRequest)
+	}
+}
+
+// endSpanWithBody records the response's outcome on the span, and arranges for the span to end once the response body
+// is done. Responses with a status of at least errorStatus mark the span as failed.
+func endSpanWithBody(span trace.Span, rsp Response, errorStatus int) Response {
+	status := responseStatus(rsp)
+	if rsp.Response != nil || rsp.Error == nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	}
+	if rsp.Error != nil {
+		span.RecordError(rsp.Error)
+	}
+	if status >= errorStatus || (rsp.Response == nil && rsp.Error != nil) {
+		msg := http.StatusText(status)
+		if rsp.Error != nil {
+			msg = rsp.Error.Error()
+		}
+		span.SetStatus(codes.Error, msg)
+	}
+
+	if rsp.Response == nil || rsp.Body == nil {
+		span.End()
+		return rsp
+	}
+	rsp.Body = &countingBody{
+		ReadCloser: rsp.Body,
+		done: func(int64) {
+			span.End()
+		}}
+	return rsp
+}
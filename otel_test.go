@@ -0,0 +1,98 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func otelAttr(span sdktrace.ReadOnlySpan, key string) interface{} {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestOTelFilter(t *testing.T) {
+	t.Parallel()
+	sr := tracetest.NewSpanRecorder()
+	opts := OTelOptions{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))}
+
+	// The downstream service reports the trace context it received
+	downstream := Service(func(req Request) Response {
+		return req.Response(req.Header.Get("traceparent"))
+	}).Filter(OTelFilter(opts))
+	client := downstream.Filter(OTelClientFilter(opts))
+
+	router := NewRouter()
+	router.GET("/users/:id", func(req Request) Response {
+		rsp := NewRequest(req, "GET", "http://downstream/", nil).SendVia(client).Response()
+		b, err := rsp.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(string(b))
+	})
+	router.GET("/fail", func(req Request) Response {
+		return Response{Error: terrors.InternalService("broken", "Broken", nil)}
+	})
+	svc := router.Serve().Filter(ErrorFilter).Filter(OTelFilter(opts))
+
+	req := NewRequest(context.Background(), "GET", "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rsp := svc(req)
+	_, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 3) // downstream server, client, upstream server
+	downstreamSpan, clientSpan, serverSpan := spans[0], spans[1], spans[2]
+	assert.Equal(t, "GET /users/:id", serverSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+	assert.True(t, serverSpan.Parent().IsRemote())
+	assert.Equal(t, "/users/:id", otelAttr(serverSpan, "http.route"))
+	assert.Equal(t, int64(200), otelAttr(serverSpan, "http.status_code"))
+
+	assert.Equal(t, trace.SpanKindClient, clientSpan.SpanKind())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), downstreamSpan.Parent().SpanID())
+	assert.Equal(t, serverSpan.SpanContext().TraceID(), downstreamSpan.SpanContext().TraceID())
+
+	// Errors are recorded
+	rsp = svc(NewRequest(context.Background(), "GET", "/fail", nil))
+	rsp.BodyBytes(true)
+	spans = sr.Ended()
+	failSpan := spans[len(spans)-1]
+	assert.Equal(t, codes.Error, failSpan.Status().Code)
+	assert.Equal(t, int64(500), otelAttr(failSpan, "http.status_code"))
+	require.Len(t, failSpan.Events(), 1)
+	assert.Equal(t, "exception", failSpan.Events()[0].Name)
+}
+
+func TestOTelFilterSampling(t *testing.T) {
+	t.Parallel()
+	sr := tracetest.NewSpanRecorder()
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	}).Filter(OTelFilter(OTelOptions{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))}))
+
+	// The incoming trace isn't sampled, so neither is the server span
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	rsp := svc(req)
+	rsp.BodyBytes(true)
+	assert.Empty(t, sr.Ended())
+}
@@ -0,0 +1,133 @@
+package typhon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// UnmatchedRoute is the path label given by PrometheusFilter to requests which were not dispatched by a Router
+const UnmatchedRoute = "unmatched"
+
+// PrometheusOptions configures the metrics recorded by PrometheusMetrics. Zero values are replaced with defaults.
+type PrometheusOptions struct {
+	// Registerer is where the metrics are registered (default prometheus.DefaultRegisterer)
+	Registerer prometheus.Registerer
+	// Namespace, if set, is prepended to the names of the metrics
+	Namespace string
+	// Buckets are the upper bounds, in seconds, of the request latency histogram's buckets (default
+	// prometheus.DefBuckets)
+	Buckets []float64
+}
+
+// PrometheusMetrics records Prometheus metrics describing the requests handled by services it filters:
+//
+//	http_requests_total{method,path,status}                  counter of completed requests
+//	http_request_duration_seconds{method,path,status}        histogram of request latency
+//	http_requests_in_flight                                  gauge of requests being handled
+//
+// The path label is the pattern of the Router route which handled the request (or UnmatchedRoute), rather than the
+// request's path, so that the number of series is bounded. Requests are considered complete once their response body
+// has been fully read or closed, so streaming responses are timed in full.
+type PrometheusMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// NewPrometheusMetrics constructs PrometheusMetrics and registers them according to opts. An error is returned if
+// registration fails (for example, because the metrics are already registered).
+func NewPrometheusMetrics(opts PrometheusOptions) (*PrometheusMetrics, error) {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+	labels := []string{"method", "path", "status"}
+	m := &PrometheusMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests completed."}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, in seconds.",
+			Buckets:   opts.Buckets}, labels),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being handled."})}
+	for _, c := range []prometheus.Collector{m.requests, m.duration, m.inFlight} {
+		if err := opts.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// PrometheusFilter returns a Filter which records PrometheusMetrics registered according to opts. It panics if the
+// metrics cannot be registered; use NewPrometheusMetrics to handle the error instead.
+func PrometheusFilter(opts PrometheusOptions) Filter {
+	m, err := NewPrometheusMetrics(opts)
+	if err != nil {
+		panic(err)
+	}
+	return m.Filter
+}
+
+// Filter is a Filter which records the metrics
+func (m *PrometheusMetrics) Filter(req Request, svc Service) Response {
+	start := time.Now()
+	m.inFlight.Inc()
+	ctx, pattern := withRoutePattern(req.Context)
+	req.Context = ctx
+	rsp := svc(req)
+
+	observe := func(int64) {
+		path := *pattern
+		if path == "" {
+			path = UnmatchedRoute
+		}
+		labels := prometheus.Labels{
+			"method": req.Method,
+			"path":   path,
+			"status": strconv.Itoa(responseStatus(rsp))}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		m.inFlight.Dec()
+	}
+	if rsp.Response == nil || rsp.Body == nil {
+		observe(0)
+		return rsp
+	}
+	rsp.Body = &countingBody{
+		ReadCloser: rsp.Body,
+		done:       observe}
+	return rsp
+}
+
+// PrometheusService returns a Service which serves the metrics gathered by g (or if nil,
+// prometheus.DefaultGatherer) for scraping
+func PrometheusService(g prometheus.Gatherer) Service {
+	if g == nil {
+		g = prometheus.DefaultGatherer
+	}
+	return handlerService(promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+}
+
+// responseStatus returns the status code which the response will be sent with
+func responseStatus(rsp Response) int {
+	status := http.StatusOK
+	if rsp.Response != nil {
+		status = rsp.StatusCode
+	}
+	if rsp.Error != nil && status == http.StatusOK {
+		status = ErrorStatusCode(rsp.Error)
+	}
+	return status
+}
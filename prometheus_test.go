@@ -0,0 +1,85 @@
+package typhon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusFilter(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	m, err := NewPrometheusMetrics(PrometheusOptions{
+		Registerer: reg,
+		Buckets:    []float64{0.1, 1}})
+	require.NoError(t, err)
+
+	router := NewRouter()
+	router.GET("/users/:id", func(req Request) Response {
+		return req.Response("ok")
+	})
+	svc := router.Serve().Filter(ErrorFilter).Filter(m.Filter)
+
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		rsp := svc(NewRequest(context.Background(), "GET", path, nil))
+		_, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+	}
+
+	rsp := PrometheusService(reg)(NewRequest(context.Background(), "GET", "/metrics", nil))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	body := string(b)
+	assert.Contains(t, body, `http_requests_total{method="GET",path="/users/:id",status="200"} 2`)
+	assert.Contains(t, body, `http_requests_total{method="GET",path="unmatched",status="404"} 1`)
+	assert.Contains(t, body,
+		`http_request_duration_seconds_bucket{method="GET",path="/users/:id",status="200",le="0.1"} 2`)
+	assert.Contains(t, body, "http_requests_in_flight 0")
+	assert.False(t, strings.Contains(body, "/users/1"))
+
+	// Registering twice fails
+	_, err = NewPrometheusMetrics(PrometheusOptions{
+		Registerer: reg})
+	assert.Error(t, err)
+	assert.Panics(t, func() {
+		PrometheusFilter(PrometheusOptions{
+			Registerer: reg})
+	})
+}
+
+func TestPrometheusFilterStreaming(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	m, err := NewPrometheusMetrics(PrometheusOptions{
+		Registerer: reg,
+		Namespace:  "test"})
+	require.NoError(t, err)
+
+	s := Streamer()
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	}).Filter(m.Filter)
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+
+	// The request is in flight until its body is done
+	gauge := func() string {
+		rsp := PrometheusService(reg)(NewRequest(context.Background(), "GET", "/metrics", nil))
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Contains(t, gauge(), "test_http_requests_in_flight 1")
+	go func() {
+		s.Write([]byte("abc"))
+		s.Close()
+	}()
+	_, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Contains(t, gauge(), "test_http_requests_in_flight 0")
+}
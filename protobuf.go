@@ -0,0 +1,85 @@
+package typhon
+
+import (
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/monzo/terrors"
+)
+
+// protobufContentType is the Content-Type set on protobuf-encoded bodies
+const protobufContentType = "application/protobuf"
+
+func encodeProto(w io.Writer, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// isProtobufContentType returns whether the given Content-Type header denotes a protobuf body
+func isProtobufContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case protobufContentType, "application/x-protobuf":
+		return true
+	}
+	return false
+}
+
+// EncodeProto serialises the passed message in protobuf wire format into the body (and sets appropriate headers).
+func (r *Request) EncodeProto(msg proto.Message) {
+	r.encode(encodeProto, protobufContentType, msg)
+}
+
+// DecodeProto de-serialises the protobuf body into the passed message. If the body is not protobuf (according to its
+// Content-Type), a bad request error is returned.
+func (r Request) DecodeProto(msg proto.Message) error {
+	if ct := r.Header.Get("Content-Type"); !isProtobufContentType(ct) {
+		return terrors.BadRequest("invalid_content_type", "Request body is not protobuf", map[string]string{
+			"content_type": ct})
+	}
+	b, err := r.BodyBytes(true)
+	if err == nil {
+		err = proto.Unmarshal(b, msg)
+	}
+	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+}
+
+// EncodeProto serialises the passed message in protobuf wire format into the body (and sets appropriate headers).
+func (r *Response) EncodeProto(msg proto.Message) {
+	r.encode(encodeProto, protobufContentType, msg)
+}
+
+// DecodeProto de-serialises the protobuf body into the passed message. If the body is not protobuf (according to its
+// Content-Type), a bad response error is returned.
+func (r *Response) DecodeProto(msg proto.Message) error {
+	if r.Error != nil {
+		return r.Error
+	} else if r.Response == nil {
+		r.Error = terrors.InternalService("", "Response has no body", nil)
+		return r.Error
+	}
+	if ct := r.Header.Get("Content-Type"); !isProtobufContentType(ct) {
+		r.Error = terrors.BadResponse("invalid_content_type", "Response body is not protobuf", map[string]string{
+			"content_type": ct})
+		return r.Error
+	}
+	b, err := r.BodyBytes(true)
+	if err == nil {
+		err = proto.Unmarshal(b, msg)
+	}
+	r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+	return r.Error
+}
@@ -0,0 +1,47 @@
+package typhon
+
+import (
+	"testing"
+
+	"github.com/monzo/terrors"
+	terrorsproto "github.com/monzo/terrors/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	t.Parallel()
+	msg := &terrorsproto.Error{
+		Code:    "bad_request.foo",
+		Message: "foo",
+		Params: map[string]string{
+			"a": "b"}}
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.EncodeProto(msg)
+	assert.Equal(t, "application/protobuf", req.Header.Get("Content-Type"))
+	assert.True(t, req.ContentLength > 0)
+	reqMsg := &terrorsproto.Error{}
+	require.NoError(t, req.DecodeProto(reqMsg))
+	assert.Equal(t, msg.Code, reqMsg.Code)
+	assert.Equal(t, msg.Params, reqMsg.Params)
+
+	rsp := NewResponse(req)
+	rsp.EncodeProto(msg)
+	assert.Equal(t, "application/protobuf", rsp.Header.Get("Content-Type"))
+	rspMsg := &terrorsproto.Error{}
+	require.NoError(t, rsp.DecodeProto(rspMsg))
+	assert.Equal(t, msg.Message, rspMsg.Message)
+}
+
+func TestProtobufContentTypeMismatch(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "POST", "/", map[string]string{"a": "b"})
+	err := req.DecodeProto(&terrorsproto.Error{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_content_type"))
+
+	rsp := NewResponse(req)
+	rsp.Encode(map[string]string{"a": "b"})
+	err = rsp.DecodeProto(&terrorsproto.Error{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_response.invalid_content_type"))
+}
@@ -0,0 +1,146 @@
+package typhon
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// hopHeaders are the hop-by-hop headers, which apply only to a single connection and so mustn't be forwarded by
+// proxies (RFC 7230 section 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade"}
+
+// ProxyOptions configures a Service constructed by ProxyService
+type ProxyOptions struct {
+	// Client sends requests to the target. If nil, Client is used.
+	Client Service
+	// PreserveHost forwards the request's Host header unchanged, rather than replacing it with the target's host
+	PreserveHost bool
+	// StreamRequestBody forwards request bodies to the target as they arrive (see NewStreamingRequest), rather than
+	// letting them be buffered. This suits large uploads, but means the Client can't retry them.
+	StreamRequestBody bool
+	// RewriteRequest, if set, is called with each outgoing request before it is sent, after its URL and headers have
+	// been rewritten
+	RewriteRequest func(*Request)
+	// RewriteResponse, if set, is called with each upstream response before it is returned, after hop-by-hop headers
+	// have been removed
+	RewriteResponse func(*Response)
+}
+
+// ProxyService returns a Service which forwards requests to the target URL, and returns the upstream's responses. The
+// scheme and host of each request are replaced with the target's, and its path and query are appended to the
+// target's (so with a target of http://backend/api, a request for /users?page=2 is forwarded to
+// http://backend/api/users?page=2). Hop-by-hop headers are stripped in both directions, and the X-Forwarded-For and
+// X-Forwarded-Proto headers are set on outgoing requests.
+//
+// Response bodies are not buffered, so streaming responses are streamed through to the client. If no response can be
+// obtained from the target, the response has a bad_gateway (502) error. Connection upgrades (eg. WebSockets) are
+// not proxied.
+func ProxyService(target *url.URL, opts ProxyOptions) Service {
+	return func(req Request) Response {
+		svc := opts.Client
+		if svc == nil {
+			svc = Client
+		}
+		out := req
+		out.Request = *req.Request.WithContext(req.unwrappedContext())
+		out.RequestURI = ""
+		out.Close = false
+		u := *target
+		// The escaped path is kept too, so that escaped slashes (%2F) aren't forwarded as path separators
+		u.Path = joinProxyPath(target.Path, req.URL.Path)
+		u.RawPath = joinProxyPath(target.EscapedPath(), req.URL.EscapedPath())
+		if target.RawQuery == "" || req.URL.RawQuery == "" {
+			u.RawQuery = target.RawQuery + req.URL.RawQuery
+		} else {
+			u.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+		}
+		out.URL = &u
+		if !opts.PreserveHost {
+			out.Host = ""
+		}
+		if _, buffered := out.Body.(*bufCloser); opts.StreamRequestBody && !buffered && out.Body != nil &&
+			out.Body != http.NoBody && !out.Streaming() {
+			out.Body = &streamingBody{
+				ReadCloser: out.Body}
+		}
+		out.Header = cloneHeader(req.Header)
+		removeHopHeaders(out.Header)
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			if prior := out.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+				ip = strings.Join(prior, ", ") + ", " + ip
+			}
+			out.Header.Set("X-Forwarded-For", ip)
+		}
+		if req.TLS != nil {
+			out.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			out.Header.Set("X-Forwarded-Proto", "http")
+		}
+		if opts.RewriteRequest != nil {
+			opts.RewriteRequest(&out)
+		}
+
+		rsp := svc(out)
+		if rsp.Response == nil {
+			if rsp.Error == nil || terrors.PrefixMatches(rsp.Error, terrors.ErrInternalService) {
+				params := map[string]string{
+					"upstream": target.Host}
+				if rsp.Error != nil {
+					params["error"] = rsp.Error.Error()
+				}
+				rsp.Error = terrors.New(ErrBadGateway, "No response from upstream", params)
+			}
+			return rsp
+		}
+		rsp.Request = &req
+		removeHopHeaders(rsp.Header)
+		if opts.RewriteResponse != nil {
+			opts.RewriteResponse(&rsp)
+		}
+		return rsp
+	}
+}
+
+// removeHopHeaders removes the hop-by-hop headers from h, including any named by its Connection header
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// joinProxyPath appends the path of a proxied request to the target's path, with exactly one slash between them
+func joinProxyPath(base, p string) string {
+	switch {
+	case base == "" || base == "/":
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		return p
+	case p == "" || p == "/":
+		if strings.HasSuffix(p, "/") && !strings.HasSuffix(base, "/") {
+			return base + "/"
+		}
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
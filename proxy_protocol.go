@@ -0,0 +1,254 @@
+package typhon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+const (
+	// DefaultProxyHeaderTimeout is the default time allowed for a client to send its PROXY protocol header
+	DefaultProxyHeaderTimeout = 5 * time.Second
+
+	proxyV1MaxLength = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolOptions configures a listener created with NewProxyProtocolListener.
+type ProxyProtocolOptions struct {
+	// TrustedSources are the CIDR ranges (eg. "10.0.0.0/8") of load balancers which are trusted to send PROXY protocol
+	// headers. Connections from trusted sources must begin with a valid header, or they are dropped. Connections from
+	// elsewhere are passed through untouched, with any header left unparsed.
+	TrustedSources []string
+	// HeaderTimeout bounds how long a trusted source may take to send its header. If zero, DefaultProxyHeaderTimeout
+	// is used.
+	HeaderTimeout time.Duration
+}
+
+// NewProxyProtocolListener wraps a listener so that connections from trusted sources have their HAProxy PROXY
+// protocol (v1 or v2) header parsed, and report the original client's address from RemoteAddr. Headers are parsed
+// lazily on the first read from (or address lookup of) a connection, so a slow client cannot block Accept.
+func NewProxyProtocolListener(l net.Listener, opts ProxyProtocolOptions) (net.Listener, error) {
+	trusted := make([]*net.IPNet, 0, len(opts.TrustedSources))
+	for _, s := range opts.TrustedSources {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, terrors.Wrap(err, map[string]string{
+				"cidr": s})
+		}
+		trusted = append(trusted, ipNet)
+	}
+	if opts.HeaderTimeout <= 0 {
+		opts.HeaderTimeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyListener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  opts.HeaderTimeout}, nil
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &proxyConn{
+		Conn:    c,
+		r:       bufio.NewReader(c),
+		timeout: l.timeout}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection from a trusted source, whose PROXY protocol header is parsed before it is read from
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	src     net.Addr
+	dst     net.Addr
+	err     error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.dst, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Warn(nil, "Dropping connection from %v with invalid PROXY protocol header: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header from r, returning the source and destination addresses it
+// names. These are nil if the header doesn't carry addresses (eg. health checks from the proxy itself).
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	if sig, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2Header(r)
+	}
+	if prefix, err := r.Peek(6); err != nil {
+		return nil, nil, err
+	} else if string(prefix) == "PROXY " {
+		return readProxyV1Header(r)
+	}
+	return nil, nil, terrors.BadRequest("missing_proxy_header", "Missing PROXY protocol header", nil)
+}
+
+func readProxyV1Header(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, nil, invalidProxyHeader("v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, invalidProxyHeader("v1 header not terminated by CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, invalidProxyHeader("malformed v1 header")
+	}
+	src, err := parseProxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(proto, ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, invalidProxyHeader("invalid v1 address")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, invalidProxyHeader("invalid v1 port")
+	}
+	return &net.TCPAddr{
+		IP:   ip,
+		Port: int(port)}, nil
+}
+
+func readProxyV2Header(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, nil, invalidProxyHeader("unsupported v2 version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	switch verCmd & 0xf {
+	case 0x0: // LOCAL: the connection was made by the proxy itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, invalidProxyHeader("unsupported v2 command")
+	}
+
+	var ipLen int
+	switch fam >> 4 {
+	case 0x1: // AF_INET
+		ipLen = net.IPv4len
+	case 0x2: // AF_INET6
+		ipLen = net.IPv6len
+	case 0x0, 0x3: // AF_UNSPEC, AF_UNIX: no usable addresses
+		return nil, nil, nil
+	default:
+		return nil, nil, invalidProxyHeader("unsupported v2 address family")
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, invalidProxyHeader("v2 addresses truncated")
+	}
+	srcIP := net.IP(append([]byte(nil), body[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	ports := body[2*ipLen:]
+	src := &net.TCPAddr{
+		IP:   srcIP,
+		Port: int(binary.BigEndian.Uint16(ports[0:2]))}
+	dst := &net.TCPAddr{
+		IP:   dstIP,
+		Port: int(binary.BigEndian.Uint16(ports[2:4]))}
+	return src, dst, nil
+}
+
+func invalidProxyHeader(reason string) error {
+	return terrors.BadRequest("invalid_proxy_header", "Invalid PROXY protocol header", map[string]string{
+		"reason": reason})
+}
@@ -0,0 +1,111 @@
+package typhon
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveProxyProtocol(t *testing.T, trusted ...string) Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l, err = NewProxyProtocolListener(l, ProxyProtocolOptions{
+		TrustedSources: trusted})
+	require.NoError(t, err)
+	s, err := Serve(Service(func(req Request) Response {
+		return req.Response(req.RemoteAddr)
+	}), l)
+	require.NoError(t, err)
+	return s
+}
+
+// proxyRoundTrip sends header followed by a HTTP request, returning the response, or nil if the connection was dropped
+func proxyRoundTrip(t *testing.T, s Server, header []byte) *http.Response {
+	c, err := net.Dial("tcp", s.Listener().Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write(append(header, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"...))
+	require.NoError(t, err)
+	rsp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return nil
+	}
+	return rsp
+}
+
+func proxyRemoteAddr(t *testing.T, rsp *http.Response) string {
+	require.NotNil(t, rsp)
+	defer rsp.Body.Close()
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	s := serveProxyProtocol(t, "127.0.0.0/8")
+	defer s.Stop()
+
+	rsp := proxyRoundTrip(t, s, []byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n"))
+	assert.Equal(t, "\"203.0.113.7:56324\"\n", proxyRemoteAddr(t, rsp))
+	rsp = proxyRoundTrip(t, s, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n"))
+	assert.Equal(t, "\"[2001:db8::1]:1234\"\n", proxyRemoteAddr(t, rsp))
+	rsp = proxyRoundTrip(t, s, []byte("PROXY UNKNOWN\r\n"))
+	assert.Contains(t, proxyRemoteAddr(t, rsp), "127.0.0.1:")
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	s := serveProxyProtocol(t, "127.0.0.0/8")
+	defer s.Stop()
+
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x21, 0x11, 0, 12+3) // PROXY, TCP over IPv4, with a trailing TLV
+	hdr = append(hdr, 198, 51, 100, 9, 192, 0, 2, 1)
+	hdr = binary.BigEndian.AppendUint16(hdr, 40000)
+	hdr = binary.BigEndian.AppendUint16(hdr, 443)
+	hdr = append(hdr, 0x04, 0, 0) // empty PP2_TYPE_NOOP
+	rsp := proxyRoundTrip(t, s, hdr)
+	assert.Equal(t, "\"198.51.100.9:40000\"\n", proxyRemoteAddr(t, rsp))
+
+	local := append(append([]byte(nil), proxyV2Signature...), 0x20, 0x00, 0, 0)
+	rsp = proxyRoundTrip(t, s, local)
+	assert.Contains(t, proxyRemoteAddr(t, rsp), "127.0.0.1:")
+}
+
+func TestProxyProtocolMalformed(t *testing.T) {
+	s := serveProxyProtocol(t, "127.0.0.0/8")
+	defer s.Stop()
+
+	for _, hdr := range []string{
+		"PROXY TCP4 not-an-ip 192.0.2.1 1 2\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 56324\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\n",
+		"", // trusted sources must send a header
+	} {
+		assert.Nil(t, proxyRoundTrip(t, s, []byte(hdr)), hdr)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	s := serveProxyProtocol(t, "10.0.0.0/8")
+	defer s.Stop()
+
+	// Without a header, untrusted connections are served as normal
+	rsp := proxyRoundTrip(t, s, nil)
+	assert.Contains(t, proxyRemoteAddr(t, rsp), "127.0.0.1:")
+	// With one, it isn't parsed (and so the request is malformed)
+	rsp = proxyRoundTrip(t, s, []byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n"))
+	require.NotNil(t, rsp)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
+
+func TestNewProxyProtocolListenerInvalidCIDR(t *testing.T) {
+	_, err := NewProxyProtocolListener(nil, ProxyProtocolOptions{
+		TrustedSources: []string{"nonsense"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package typhon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyService(t *testing.T) {
+	t.Parallel()
+	var upstreamReq Request
+	upstream := InMemoryClient(func(req Request) Response {
+		upstreamReq = req
+		rsp := req.Response("hello")
+		rsp.Header.Set("Connection", "X-Upstream-Hop")
+		rsp.Header.Set("X-Upstream-Hop", "1")
+		rsp.Header.Set("Keep-Alive", "timeout=5")
+		rsp.Header.Set("X-Upstream", "1")
+		return rsp
+	})
+	target, err := url.Parse("http://backend.internal/api?key=k")
+	require.NoError(t, err)
+	svc := ProxyService(target, ProxyOptions{
+		Client: upstream,
+		RewriteRequest: func(req *Request) {
+			req.Header.Set("X-Rewritten", "1")
+		},
+		RewriteResponse: func(rsp *Response) {
+			rsp.Header.Set("X-Proxied", "1")
+		}})
+
+	req := NewRequest(nil, "GET", "http://proxy.example.com/users?page=2", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("X-Custom", "1")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	assert.Equal(t, "/api/users", upstreamReq.URL.Path)
+	assert.Equal(t, "key=k&page=2", upstreamReq.URL.RawQuery)
+	assert.Equal(t, "backend.internal", upstreamReq.Host)
+	assert.Equal(t, "192.0.2.1, 10.0.0.2", upstreamReq.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", upstreamReq.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "1", upstreamReq.Header.Get("X-Custom"))
+	assert.Equal(t, "1", upstreamReq.Header.Get("X-Rewritten"))
+	assert.Empty(t, upstreamReq.Header.Get("X-Hop"))
+	assert.Empty(t, upstreamReq.Header.Get("Proxy-Authorization"))
+	assert.Equal(t, "1", req.Header.Get("X-Hop"), "the incoming request's headers should be left alone")
+
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("X-Upstream"))
+	assert.Equal(t, "1", rsp.Header.Get("X-Proxied"))
+	assert.Empty(t, rsp.Header.Get("X-Upstream-Hop"))
+	assert.Empty(t, rsp.Header.Get("Keep-Alive"))
+	assert.Empty(t, rsp.Header.Get("Connection"))
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "hello", body)
+}
+
+func TestProxyServicePreserveHost(t *testing.T) {
+	t.Parallel()
+	var host string
+	upstream := InMemoryClient(func(req Request) Response {
+		host = req.Host
+		return NewResponse(req)
+	})
+	target, _ := url.Parse("http://backend.internal")
+	svc := ProxyService(target, ProxyOptions{
+		Client:       upstream,
+		PreserveHost: true})
+	rsp := svc(NewRequest(nil, "GET", "http://proxy.example.com/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "proxy.example.com", host)
+}
+
+func TestProxyServiceStreaming(t *testing.T) {
+	t.Parallel()
+	upstream := InMemoryClient(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for _, c := range []string{"a", "b", "c"} {
+				s.Write([]byte(c))
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	target, _ := url.Parse("http://backend.internal")
+	rsp := ProxyService(target, ProxyOptions{
+		Client: upstream})(NewRequest(nil, "GET", "/stream", nil))
+	require.NoError(t, rsp.Error)
+	assert.True(t, isStreamingRsp(rsp))
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+}
+
+func TestProxyServiceUpstreamFailure(t *testing.T) {
+	t.Parallel()
+	target, _ := url.Parse("http://backend.internal")
+	svc := ProxyService(target, ProxyOptions{
+		Client: func(req Request) Response {
+			return Response{
+				Error: terrors.Wrap(errors.New("connection refused"), nil)}
+		}})
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Matches(rsp.Error, ErrBadGateway))
+	assert.Equal(t, http.StatusBadGateway, ErrorStatusCode(rsp.Error))
+
+	// Timeouts are kept as they are, to be reported as a 504
+	svc = ProxyService(target, ProxyOptions{
+		Client: func(req Request) Response {
+			return Response{
+				Error: terrors.Timeout("", "Timed out", nil)}
+		}})
+	rsp = svc(NewRequest(nil, "GET", "/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrTimeout))
+}
+
+func TestProxyServiceEscapedPath(t *testing.T) {
+	t.Parallel()
+	paths := make(chan string, 1)
+	upstream, err := Listen(Service(func(req Request) Response {
+		paths <- req.URL.EscapedPath()
+		return req.Response(nil)
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer upstream.Stop()
+	target, err := url.Parse("http://" + upstream.Listener().Addr().String() + "/api")
+	require.NoError(t, err)
+	svc := ProxyService(target, ProxyOptions{
+		Client: HttpService(NewRoundTripper(DefaultClientConfig()))})
+
+	req := NewRequest(nil, "GET", "http://proxy.example.com/files/a%2Fb%20c", nil)
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "/api/files/a%2Fb%20c", <-paths)
+}
+
+func TestJoinProxyPath(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		base, path, expected string
+	}{
+		{"", "/a", "/a"},
+		{"/", "/a", "/a"},
+		{"", "", "/"},
+		{"/api", "/a", "/api/a"},
+		{"/api/", "/a/", "/api/a/"},
+		{"/api", "/", "/api/"},
+		{"/api", "", "/api"},
+		{"/api/", "", "/api/"}}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, joinProxyPath(c.base, c.path), "%q + %q", c.base, c.path)
+	}
+}
+
+func TestProxyServiceStreamRequestBody(t *testing.T) {
+	t.Parallel()
+	var streaming bool
+	var body []byte
+	upstream := func(req Request) Response {
+		streaming = req.Streaming()
+		body, _ = ioutil.ReadAll(req.Body)
+		return NewResponse(req)
+	}
+	target, _ := url.Parse("http://backend.internal")
+	svc := ProxyService(target, ProxyOptions{
+		Client:            upstream,
+		StreamRequestBody: true})
+
+	// Bodies of incoming requests aren't buffered
+	req := NewRequest(nil, "POST", "/upload", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("large upload"))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.True(t, streaming)
+	assert.Equal(t, "large upload", string(body))
+
+	// ...and without the option, they may be
+	svc = ProxyService(target, ProxyOptions{
+		Client: upstream})
+	req.Body = ioutil.NopCloser(strings.NewReader("large upload"))
+	svc(req)
+	assert.False(t, streaming)
+}
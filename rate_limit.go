@@ -0,0 +1,100 @@
+package typhon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitOptions configures the behaviour of RateLimitFilterWithOptions
+type RateLimitOptions struct {
+	// Limit is the sustained rate of requests permitted, and Burst the number which may be made at once
+	Limit rate.Limit
+	Burst int
+	// Key, if non-nil, partitions requests such that each key has its own limit (for example, RateLimitByHost)
+	Key func(req Request) string
+	// Reject, if true, fails requests immediately when no token is available, rather than waiting for one
+	Reject bool
+}
+
+// RateLimitByHost is a RateLimitOptions.Key function which limits requests to each target host separately
+func RateLimitByHost(req Request) string {
+	if req.URL != nil && req.URL.Host != "" {
+		return req.URL.Host
+	}
+	return req.Host
+}
+
+// RateLimitFilter returns a Filter which limits the rate at which requests are passed to the service using a token
+// bucket. Requests wait until a token is available; if the request's context is done (or its deadline would pass)
+// first, they fail with a rate limited error. Rejected responses carry a Retry-After header giving the time until a
+// token will be available.
+func RateLimitFilter(r rate.Limit, burst int) Filter {
+	return RateLimitFilterWithOptions(RateLimitOptions{
+		Limit: r,
+		Burst: burst})
+}
+
+// RateLimitFilterWithOptions is like RateLimitFilter, but allows limits to be applied per key, and allows requests to
+// be rejected immediately rather than waiting.
+func RateLimitFilterWithOptions(opts RateLimitOptions) Filter {
+	limiters := map[string]*rate.Limiter{}
+	m := sync.Mutex{}
+	limiter := func(key string) *rate.Limiter {
+		m.Lock()
+		defer m.Unlock()
+		l, ok := limiters[key]
+		if !ok {
+			l = rate.NewLimiter(opts.Limit, opts.Burst)
+			limiters[key] = l
+		}
+		return l
+	}
+
+	return func(req Request, svc Service) Response {
+		key := ""
+		if opts.Key != nil {
+			key = opts.Key(req)
+		}
+		l := limiter(key)
+
+		var err error
+		if opts.Reject {
+			if !l.Allow() {
+				err = terrors.New(ErrRateLimited, "Rate limit exceeded", nil)
+			}
+		} else {
+			ctx := req.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if werr := l.Wait(ctx); werr != nil {
+				err = terrors.New(ErrRateLimited, "Rate limit exceeded: "+werr.Error(), nil)
+			}
+		}
+		if err != nil {
+			rsp := NewResponse(req)
+			rsp.Error = err
+			if d, ok := rateLimitDelay(l); ok {
+				rsp.SetRetryAfter(d)
+			}
+			return rsp
+		}
+		return svc(req)
+	}
+}
+
+// rateLimitDelay returns how long it will be until the limiter has a token available, without taking one. It returns
+// false if it never will (ie. its burst is zero).
+func rateLimitDelay(l *rate.Limiter) (time.Duration, bool) {
+	now := time.Now()
+	r := l.ReserveN(now, 1)
+	if !r.OK() {
+		return 0, false
+	}
+	defer r.CancelAt(now)
+	return r.DelayFrom(now), true
+}
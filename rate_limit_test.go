@@ -0,0 +1,49 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	})
+
+	// Blocking: the second request waits for a token
+	limited := svc.Filter(RateLimitFilter(rate.Every(50*time.Millisecond), 1))
+	start := time.Now()
+	require.NoError(t, limited(NewRequest(nil, "GET", "/", nil)).Error)
+	require.NoError(t, limited(NewRequest(nil, "GET", "/", nil)).Error)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	// ...unless the context would expire first
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	rsp := limited(NewRequest(ctx, "GET", "/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrRateLimited))
+	assert.Equal(t, http.StatusTooManyRequests, ErrorStatusCode(rsp.Error))
+	assert.Equal(t, "1", rsp.Header.Get("Retry-After"))
+
+	// Rejecting immediately, with a limit per host
+	limited = svc.Filter(RateLimitFilterWithOptions(RateLimitOptions{
+		Limit:  rate.Every(time.Hour),
+		Burst:  1,
+		Key:    RateLimitByHost,
+		Reject: true}))
+	require.NoError(t, limited(NewRequest(nil, "GET", "http://a/", nil)).Error)
+	require.NoError(t, limited(NewRequest(nil, "GET", "http://b/", nil)).Error)
+	start = time.Now()
+	rsp = limited(NewRequest(nil, "GET", "http://a/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrRateLimited))
+	assert.True(t, time.Since(start) < 10*time.Millisecond)
+	assert.Equal(t, "3600", rsp.Header.Get("Retry-After"))
+}
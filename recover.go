@@ -0,0 +1,35 @@
+package typhon
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// RecoverFilter returns a Filter which recovers from panics in the service, logging them (with a stack trace) and
+// responding with the Response returned by onPanic. If onPanic is nil, an internal service error is returned. Panics
+// with http.ErrAbortHandler are propagated, as net/http relies on them to abort responses.
+func RecoverFilter(onPanic func(req Request, v interface{}) Response) Filter {
+	return func(req Request, svc Service) (rsp Response) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			slog.Error(req, "Recovered from panic in %v: %v\n%s", req, v, debug.Stack())
+			if onPanic != nil {
+				rsp = onPanic(req, v)
+				return
+			}
+			rsp = NewResponse(req)
+			rsp.Error = terrors.InternalService("panic", fmt.Sprintf("Panic serving request: %v", v), nil)
+		}()
+		return svc(req)
+	}
+}
@@ -0,0 +1,34 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("boom")
+	})
+
+	rsp := svc.Filter(RecoverFilter(nil))(NewRequest(nil, "GET", "/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, "internal_service.panic"))
+	assert.Contains(t, rsp.Error.Error(), "boom")
+
+	rsp = svc.Filter(RecoverFilter(func(req Request, v interface{}) Response {
+		rsp := req.Response(v)
+		rsp.StatusCode = http.StatusTeapot
+		return rsp
+	})).Filter(ErrorFilter)(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusTeapot, rsp.StatusCode)
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		svc.Filter(RecoverFilter(nil))(NewRequest(nil, "GET", "/abort", nil))
+	})
+}
@@ -0,0 +1,44 @@
+package typhon
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/monzo/terrors"
+)
+
+// NewRedirect constructs a Response redirecting the request to the given URL, with the given 3xx status code. A
+// relative URL is resolved against the request's URL (so "edit" in response to /items/1/ redirects to
+// /items/1/edit). If the code is not a redirect status, or the URL is invalid, the response has an error.
+func NewRedirect(req Request, target string, code int) Response {
+	if code < 300 || code > 399 || code == http.StatusNotModified {
+		status := strconv.Itoa(code)
+		return Response{
+			Error: terrors.InternalService("invalid_redirect", "Invalid redirect status "+status, map[string]string{
+				"status": status})}
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return Response{
+			Error: terrors.InternalService("invalid_redirect", "Invalid redirect URL", map[string]string{
+				"url": target})}
+	}
+	if req.URL != nil && !u.IsAbs() {
+		u = req.URL.ResolveReference(u)
+	}
+	rsp := NewResponse(req)
+	rsp.StatusCode = code
+	rsp.Header.Set("Location", u.String())
+	return rsp
+}
+
+// PermanentRedirect constructs a 308 Permanent Redirect Response to the given URL; see NewRedirect
+func PermanentRedirect(req Request, target string) Response {
+	return NewRedirect(req, target, http.StatusPermanentRedirect)
+}
+
+// TemporaryRedirect constructs a 307 Temporary Redirect Response to the given URL; see NewRedirect
+func TemporaryRedirect(req Request, target string) Response {
+	return NewRedirect(req, target, http.StatusTemporaryRedirect)
+}
@@ -0,0 +1,42 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedirect(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "POST", "/items/1/?q=x", nil)
+	cases := []struct {
+		target, location string
+	}{
+		{"https://example.com/a?b=c", "https://example.com/a?b=c"},
+		{"/login", "/login"},
+		{"edit", "/items/1/edit"},
+		{"../2/", "/items/2/"},
+		{"?page=2", "/items/1/?page=2"}}
+	for _, c := range cases {
+		rsp := NewRedirect(req, c.target, http.StatusSeeOther)
+		require.NoError(t, rsp.Error, c.target)
+		assert.Equal(t, http.StatusSeeOther, rsp.StatusCode)
+		assert.Equal(t, c.location, rsp.Header.Get("Location"), c.target)
+	}
+
+	rsp := PermanentRedirect(req, "/new")
+	assert.Equal(t, http.StatusPermanentRedirect, rsp.StatusCode)
+	assert.Equal(t, "/new", rsp.Header.Get("Location"))
+	rsp = TemporaryRedirect(req, "/new")
+	assert.Equal(t, http.StatusTemporaryRedirect, rsp.StatusCode)
+
+	for _, code := range []int{http.StatusOK, http.StatusNotModified, http.StatusNotFound} {
+		rsp = NewRedirect(req, "/new", code)
+		assert.True(t, terrors.PrefixMatches(rsp.Error, "internal_service.invalid_redirect"), code)
+	}
+	rsp = NewRedirect(req, "http://a b/", http.StatusFound)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, "internal_service.invalid_redirect"))
+}
@@ -2,11 +2,16 @@ package typhon
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/monzo/terrors"
 )
@@ -15,7 +20,8 @@ import (
 type Request struct {
 	http.Request
 	context.Context
-	err error // Any error from request construction; read by Client
+	err    error              // Any error from request construction; read by Client
+	cancel context.CancelFunc // Releases any context set by SetTimeout; invoked by SendVia
 }
 
 // unwrappedContext returns the most "unwrapped" Context possible for that in the request.
@@ -34,29 +40,127 @@ func (r *Request) unwrappedContext() context.Context {
 	}
 }
 
-// Encode serialises the passed object as JSON into the body (and sets appropriate headers).
+// SetTimeout derives a new context for the request which expires after d. When the request is sent, the context is
+// released once the response body has been fully read or closed; a request which is never sent holds on to its
+// context until it expires.
+func (r *Request) SetTimeout(d time.Duration) {
+	parent := r.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, d)
+	if prev := r.cancel; prev != nil {
+		r.cancel = func() {
+			cancel()
+			prev()
+		}
+	} else {
+		r.cancel = cancel
+	}
+	r.Context = ctx
+}
+
+// Encode serialises the passed object into the body (and sets appropriate headers), using the Codec registered for
+// the request's Content-Type. If no Content-Type is set, or none is registered for it, the object is encoded as JSON.
 func (r *Request) Encode(v interface{}) {
+	enc, contentType := codecEncoder(r.Header.Get("Content-Type"))
+	r.encode(enc, contentType, v)
+}
+
+func (r *Request) encode(enc encoderFunc, contentType string, v interface{}) {
 	cw := &countingWriter{
 		Writer: r}
-	if err := json.NewEncoder(cw).Encode(v); err != nil {
+	if err := enc(cw, v); err != nil {
 		r.err = terrors.Wrap(err, nil)
 		return
 	}
-	r.Header.Set("Content-Type", "application/json")
-	if r.ContentLength < 0 && cw.n < chunkThreshold {
+	r.Header.Set("Content-Type", contentType)
+	if r.ContentLength < 0 && cw.n < ChunkThreshold {
 		r.ContentLength = int64(cw.n)
 	}
 }
 
-// Decode de-serialises the JSON body into the passed object.
+// Decode de-serialises the body into the passed object, using the Codec registered for the request's Content-Type. A
+// body without a Content-Type, or with one for which no Codec is registered, is decoded as JSON.
 func (r Request) Decode(v interface{}) error {
+	c, _ := codecFor(r.Header.Get("Content-Type"))
 	b, err := r.BodyBytes(true)
 	if err == nil {
-		err = json.Unmarshal(b, v)
+		err = c.Unmarshal(b, v)
 	}
 	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
 }
 
+// DecodeQuery maps the URL query parameters onto the fields of the struct pointed to by v, according to their `query`
+// tags. Repeated parameters may be decoded into slices, and times are parsed as RFC 3339. A tag of the form
+// `query:"name,required"` marks a parameter which must be present.
+func (r Request) DecodeQuery(v interface{}) error {
+	var values url.Values
+	if r.URL != nil {
+		values = r.URL.Query()
+	}
+	return decodeValues(values, v, "query")
+}
+
+// DecodeForm maps the fields of a form-encoded (application/x-www-form-urlencoded) body onto the fields of the struct
+// pointed to by v, according to their `form` tags, in the same way as DecodeQuery. Query parameters are not included.
+// If the body is not form-encoded (according to its Content-Type), a bad request error is returned.
+func (r Request) DecodeForm(v interface{}) error {
+	ct := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return terrors.BadRequest("invalid_content_type", "Request body is not form-encoded", map[string]string{
+			"content_type": ct})
+	}
+	b, err := r.BodyBytes(true)
+	if err != nil {
+		return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+	}
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return terrors.BadRequest("invalid_form", "Request body is not a valid form", map[string]string{
+			"error": err.Error()})
+	}
+	return decodeValues(values, v, "form")
+}
+
+// Multipart returns a reader over the parts of a multipart request body. At most maxSize bytes of the body will be
+// read; beyond that, reads from the parts will fail with a bad request error. If the request is not multipart, an
+// error is returned.
+func (r *Request) Multipart(maxSize int64) (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, terrors.BadRequest("not_multipart", "Request is not multipart", map[string]string{
+			"content_type": r.Header.Get("Content-Type")})
+	}
+	if r.Body == nil {
+		return nil, terrors.BadRequest("missing_body", "Request has no body", nil)
+	}
+	r.Body = newLimitReader(r.Body, maxSize, bodyTooLarge(maxSize))
+	return multipart.NewReader(r.Body, params["boundary"]), nil
+}
+
+// MultipartFile returns the first part of a multipart request body with the given form name. The part's FileName and
+// Header carry the metadata supplied by the client. Reading the part consumes the request body, so only one part may
+// be retrieved in this way; for anything more complex, use Multipart.
+func (r *Request) MultipartFile(name string, maxSize int64) (*multipart.Part, error) {
+	mr, err := r.Multipart(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p, err := mr.NextPart()
+		switch {
+		case err == io.EOF:
+			return nil, terrors.BadRequest("missing_part", fmt.Sprintf("Missing multipart part %s", name), map[string]string{
+				"part": name})
+		case err != nil:
+			return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+		case p.FormName() == name:
+			return p, nil
+		}
+	}
+}
+
 func (r *Request) Write(b []byte) (int, error) {
 	switch rc := r.Body.(type) {
 	// In the "normal" case, the response body will be a buffer, to which we can write
@@ -98,6 +202,153 @@ func (r *Request) BodyBytes(consume bool) ([]byte, error) {
 	}
 }
 
+// PeekBody reads and buffers the request body without consuming it, so that it may still be read (or decoded) by
+// others later. If the body is larger than maxSize, an error is returned and the body is left intact but unbuffered.
+func (r *Request) PeekBody(maxSize int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	if buf, ok := r.Body.(*bufCloser); ok {
+		if int64(buf.Len()) > maxSize {
+			return nil, bodyTooLarge(maxSize)
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf := &bufCloser{}
+	rc := r.Body
+	_, err := io.Copy(buf, io.LimitReader(rc, maxSize+1))
+	if err != nil || int64(buf.Len()) > maxSize {
+		// Some of the body has been consumed; make sure what we have is not lost
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(buf, rc), rc}
+		if err != nil {
+			return nil, terrors.Wrap(err, nil)
+		}
+		return nil, bodyTooLarge(maxSize)
+	}
+	// rc has been fully read: it will never again be accessible so it must be closed
+	rc.Close()
+	r.Body = buf
+	return buf.Bytes(), nil
+}
+
+// Rewindable returns whether the request's body can be sent again in full: because there is none, it is buffered in
+// memory (as by Encode or Write, or BodyBytes(false)) and none of it has been read, or the request has a GetBody
+// function. Filters which replay requests (such as RetryFilter) buffer unread bodies themselves, but refuse to replay
+// a buffered body which has been read, which would send a truncated (or empty) body.
+func (r Request) Rewindable() bool {
+	switch b := r.Body.(type) {
+	case nil:
+		return true
+	case *bufCloser:
+		return !b.consumed
+	}
+	return r.Body == http.NoBody || r.GetBody != nil
+}
+
+// replayable returns whether the request's body can be buffered for replay without losing any of it: that is, unless
+// it is a buffered body which has been read, or it is to be streamed
+func (r Request) replayable() bool {
+	switch b := r.Body.(type) {
+	case *bufCloser:
+		return !b.consumed
+	case *streamingBody:
+		return false
+	}
+	return true
+}
+
+// bodyForReplay buffers the request's body so that it can be replayed, returning its content. Callers must first
+// check that the request is replayable, as otherwise the content returned may be truncated.
+func (r *Request) bodyForReplay() ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	b, err := r.BodyBytes(false)
+	return b, terrors.Wrap(err, nil)
+}
+
+// Clone returns a copy of the request with its context replaced by ctx (or left as it is, if ctx is nil), which may
+// be used concurrently with the original: as with http.Request.Clone, its headers, trailers, URL and parsed forms are
+// deep copies. A buffered body (as set by Encode or Write, or by BodyBytes or PeekBody when reading a body) is copied
+// too, so both requests may read it independently. Other bodies are only replayable if the request has a GetBody
+// function; otherwise they are shared by the two requests, so call BodyBytes(false) on the original first if both
+// need to read it.
+func (r Request) Clone(ctx context.Context) Request {
+	if ctx == nil {
+		ctx = r.Context
+	}
+	clone := r
+	clone.Context = ctx
+	clone.cancel = nil // Any timeout belongs to the original
+	httpCtx := r.Request.Context()
+	if ctx != nil {
+		httpCtx = ctx
+	}
+	clone.Request = *r.Request.Clone(httpCtx)
+	switch body := r.Body.(type) {
+	case *bufCloser:
+		buf := newBufCloser()
+		buf.Write(body.Bytes())
+		clone.Body = buf
+	default:
+		if r.GetBody != nil && r.Body != nil && r.Body != http.NoBody {
+			if rc, err := r.GetBody(); err == nil {
+				clone.Body = rc
+			}
+		}
+	}
+	return clone
+}
+
+// Param returns the value of the named path parameter captured by the Router which dispatched the request. If there is
+// no such parameter (or the request was not dispatched by a Router), an empty string is returned.
+func (r Request) Param(name string) string {
+	if r.Context == nil {
+		return ""
+	}
+	params, _ := r.Value(routerParamsContextKey).(map[string]string)
+	return params[name]
+}
+
+// RoutePattern returns the pattern of the route which matched the request (eg. /users/:id), as registered with the
+// Router which dispatched it. Unlike the request's path, this has low cardinality, so is suitable for labelling
+// metrics. If the request was not dispatched by a Router, it returns false. With nested Routers, the innermost one's
+// pattern is returned.
+func (r Request) RoutePattern() (string, bool) {
+	if r.Context == nil {
+		return "", false
+	}
+	pattern, ok := r.Value(routeMatchedPatternContextKey).(string)
+	return pattern, ok
+}
+
+// ParamInt returns the value of the named path parameter as an int. If the parameter is missing or cannot be parsed, a
+// bad request error is returned.
+func (r Request) ParamInt(name string) (int, error) {
+	v := r.Param(name)
+	if v == "" {
+		return 0, terrors.BadRequest("missing_param", fmt.Sprintf("Missing path parameter %s", name), map[string]string{
+			"param": name})
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		msg := fmt.Sprintf("Path parameter %s is not an integer", name)
+		return 0, terrors.BadRequest("invalid_param", msg, map[string]string{
+			"param": name,
+			"value": v})
+	}
+	return i, nil
+}
+
+func bodyTooLarge(maxSize int64) error {
+	return terrors.BadRequest("body_too_large", "Request body too large", map[string]string{
+		"max_size": strconv.FormatInt(maxSize, 10)})
+}
+
 func (r Request) Send() *ResponseFuture {
 	return Send(r)
 }
@@ -133,7 +384,7 @@ func NewRequest(ctx context.Context, method, url string, body interface{}) Reque
 		err:     err}
 	if httpReq != nil {
 		httpReq.ContentLength = -1
-		httpReq.Body = &bufCloser{}
+		httpReq.Body = newBufCloser()
 		req.Request = *httpReq
 	}
 	if body != nil && err == nil {
@@ -141,3 +392,32 @@ func NewRequest(ctx context.Context, method, url string, body interface{}) Reque
 	}
 	return req
 }
+
+// streamingBody marks a request body which is to be streamed to its destination as it is read, rather than buffered
+type streamingBody struct {
+	io.ReadCloser
+}
+
+// NewStreamingRequest constructs a new Request whose body is streamed from the given reader as the request is sent,
+// with chunked transfer encoding (unless ContentLength is set), rather than being buffered in memory first. This suits
+// large uploads, or forwarding a request body as it arrives. Such a body can't be replayed, so filters which would
+// replay the request (such as RetryFilter and HedgingFilter) send it just once. If body is an io.ReadCloser, it is
+// closed once the request has been sent.
+func NewStreamingRequest(ctx context.Context, method, url string, body io.Reader) Request {
+	req := NewRequest(ctx, method, url, nil)
+	if body != nil && req.err == nil {
+		rc, ok := body.(io.ReadCloser)
+		if !ok {
+			rc = ioutil.NopCloser(body)
+		}
+		req.Body = &streamingBody{
+			ReadCloser: rc}
+	}
+	return req
+}
+
+// Streaming returns whether the request's body is streamed to its destination, as by NewStreamingRequest
+func (r Request) Streaming() bool {
+	_, ok := r.Body.(*streamingBody)
+	return ok
+}
@@ -0,0 +1,99 @@
+package typhon
+
+import (
+	"context"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// DefaultRequestIDHeader is the header used to carry request IDs by RequestIDFilter and PropagateRequestIDFilter
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the length beyond which an incoming request ID is discarded and replaced
+const maxRequestIDLength = 128
+
+type requestIDContextKeyType struct{}
+
+var requestIDContextKey = requestIDContextKeyType{}
+
+// RequestID returns the request ID stored in the context by RequestIDFilter, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// WithRequestID returns a copy of the context carrying the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFilter is a server-side Filter which ensures every request has an ID: it is read from the X-Request-Id
+// header, or generated if the header is absent (or invalid). The ID is stored in the request's context (see
+// RequestID) and echoed on the response.
+func RequestIDFilter(req Request, svc Service) Response {
+	return requestIDFilter(req, svc, DefaultRequestIDHeader)
+}
+
+// RequestIDFilterWithHeader is like RequestIDFilter, but carries the ID in the given header
+func RequestIDFilterWithHeader(header string) Filter {
+	return func(req Request, svc Service) Response {
+		return requestIDFilter(req, svc, header)
+	}
+}
+
+func requestIDFilter(req Request, svc Service, header string) Response {
+	id := req.Header.Get(header)
+	if !validRequestID(id) {
+		u, err := uuid.NewV4()
+		if err != nil {
+			return svc(req)
+		}
+		id = u.String()
+	}
+	req.Context = WithRequestID(req.Context, id)
+	rsp := svc(req)
+	if rsp.Response == nil {
+		rsp.Response = newHTTPResponse(req)
+	}
+	rsp.Header.Set(header, id)
+	return rsp
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// PropagateRequestIDFilter is a client-side Filter which copies the request ID from the request's context (see
+// RequestID) onto the outgoing request's X-Request-Id header, unless it is already set.
+func PropagateRequestIDFilter(req Request, svc Service) Response {
+	return propagateRequestIDFilter(req, svc, DefaultRequestIDHeader)
+}
+
+// PropagateRequestIDFilterWithHeader is like PropagateRequestIDFilter, but carries the ID in the given header
+func PropagateRequestIDFilterWithHeader(header string) Filter {
+	return func(req Request, svc Service) Response {
+		return propagateRequestIDFilter(req, svc, header)
+	}
+}
+
+func propagateRequestIDFilter(req Request, svc Service, header string) Response {
+	if id := RequestID(req.Context); id != "" && req.Header.Get(header) == "" {
+		req.Header = cloneHeader(req.Header)
+		req.Header.Set(header, id)
+	}
+	return svc(req)
+}
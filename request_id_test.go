@@ -0,0 +1,52 @@
+package typhon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDFilter(t *testing.T) {
+	t.Parallel()
+	var downstreamID string
+	downstream := Service(func(req Request) Response {
+		downstreamID = req.Header.Get("X-Request-Id")
+		return req.Response(nil)
+	})
+	client := downstream.Filter(PropagateRequestIDFilter)
+	svc := Service(func(req Request) Response {
+		// A downstream request is sent using the inbound request as its context
+		rsp := NewRequest(req, "GET", "/downstream", nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		return req.Response(RequestID(req))
+	}).Filter(RequestIDFilter)
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	rsp := svc(req)
+	assert.Equal(t, "abc-123", rsp.Header.Get("X-Request-Id"))
+	assert.Equal(t, "abc-123", downstreamID)
+	id := ""
+	require.NoError(t, rsp.Decode(&id))
+	assert.Equal(t, "abc-123", id)
+
+	// Generated if absent or invalid
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("a", 200)} {
+		req = NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("X-Request-Id", incoming)
+		rsp = svc(req)
+		generated := rsp.Header.Get("X-Request-Id")
+		assert.Len(t, generated, 36, incoming)
+		assert.Equal(t, generated, downstreamID)
+	}
+
+	// Configurable header
+	svc = Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(RequestIDFilterWithHeader("X-Correlation-Id"))
+	req = NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("X-Correlation-Id", "xyz")
+	assert.Equal(t, "xyz", svc(req).Header.Get("X-Correlation-Id"))
+}
@@ -2,10 +2,18 @@ package typhon
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/monzo/terrors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestRequestDecodeCloses verifies that a request body is closed after calling Decode()
@@ -24,3 +32,265 @@ func TestRequestDecodeCloses(t *testing.T) {
 		assert.Fail(t, "response body was not closed after Decode()")
 	}
 }
+
+func TestRequestDecodeQuery(t *testing.T) {
+	t.Parallel()
+	type embedded struct {
+		Page int `query:"page"`
+	}
+	type query struct {
+		embedded
+		Name    string        `query:"name,required"`
+		Enabled bool          `query:"enabled"`
+		Tags    []string      `query:"tag"`
+		IDs     []int64       `query:"id"`
+		Since   time.Time     `query:"since"`
+		Wait    time.Duration `query:"wait"`
+		Limit   *uint         `query:"limit"`
+		Ignored string
+	}
+
+	req := NewRequest(nil, "GET", "/?name=foo&enabled=true&tag=a&tag=b&id=1&id=2&since=2018-09-01T12:00:00Z&wait=5s"+
+		"&limit=10&page=3&Ignored=x", nil)
+	q := query{}
+	require.NoError(t, req.DecodeQuery(&q))
+	assert.Equal(t, "foo", q.Name)
+	assert.True(t, q.Enabled)
+	assert.Equal(t, []string{"a", "b"}, q.Tags)
+	assert.Equal(t, []int64{1, 2}, q.IDs)
+	assert.True(t, q.Since.Equal(time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 5*time.Second, q.Wait)
+	require.NotNil(t, q.Limit)
+	assert.Equal(t, uint(10), *q.Limit)
+	assert.Equal(t, 3, q.Page)
+	assert.Empty(t, q.Ignored)
+
+	err := NewRequest(nil, "GET", "/?enabled=true", nil).DecodeQuery(&query{})
+	require.Error(t, err)
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.missing_param"))
+	assert.Equal(t, "Name", err.(*terrors.Error).Params["field"])
+
+	err = NewRequest(nil, "GET", "/?name=foo&id=1&id=x", nil).DecodeQuery(&query{})
+	require.Error(t, err)
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_param"))
+	assert.Equal(t, "id", err.(*terrors.Error).Params["param"])
+}
+
+func TestRequestDecodeForm(t *testing.T) {
+	t.Parallel()
+	type form struct {
+		Email     string   `form:"email,required"`
+		Age       int      `form:"age"`
+		Interest  []string `form:"interest"`
+		Subscribe bool     `form:"subscribe"`
+	}
+	newReq := func(body string) Request {
+		req := NewRequest(nil, "POST", "/?age=99", nil)
+		req.Write([]byte(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	f := form{}
+	require.NoError(t, newReq("email=a%40b.com&interest=go&interest=http&subscribe=on").DecodeForm(&f))
+	assert.Equal(t, "a@b.com", f.Email)
+	assert.Equal(t, []string{"go", "http"}, f.Interest)
+	assert.True(t, f.Subscribe)
+	assert.Equal(t, 0, f.Age) // only the body is decoded
+
+	err := newReq("email=a%40b.com&age=old").DecodeForm(&form{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_param"))
+	assert.Equal(t, "age", err.(*terrors.Error).Params["param"])
+
+	err = newReq("age=1").DecodeForm(&form{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.missing_param"))
+
+	err = newReq("email=%zz").DecodeForm(&form{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_form"))
+
+	err = NewRequest(nil, "POST", "/", map[string]string{"email": "a@b.com"}).DecodeForm(&form{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_content_type"))
+}
+
+func TestRequestMultipart(t *testing.T) {
+	t.Parallel()
+	newReq := func() Request {
+		buf := &bytes.Buffer{}
+		w := multipart.NewWriter(buf)
+		w.WriteField("description", "a document")
+		fw, err := w.CreateFormFile("document", "doc.txt")
+		require.NoError(t, err)
+		fw.Write([]byte("contents of the document"))
+		require.NoError(t, w.Close())
+		req := NewRequest(nil, "POST", "/", nil)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.Write(buf.Bytes())
+		return req
+	}
+
+	req := newReq()
+	p, err := req.MultipartFile("document", 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "doc.txt", p.FileName())
+	b, err := ioutil.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "contents of the document", string(b))
+
+	req = newReq()
+	_, err = req.MultipartFile("missing", 1024)
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.missing_part"))
+
+	req = newReq()
+	p, err = req.MultipartFile("document", 64)
+	if err == nil {
+		_, err = ioutil.ReadAll(p)
+	}
+	assert.True(t, terrors.PrefixMatches(err, "bad_request"))
+
+	req = NewRequest(nil, "POST", "/", map[string]string{"a": "b"})
+	_, err = req.Multipart(1024)
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.not_multipart"))
+}
+
+func TestRequestPeekBody(t *testing.T) {
+	t.Parallel()
+	b := []byte("{\"a\":\"b\"}\n")
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	peeked, err := req.PeekBody(1024)
+	require.NoError(t, err)
+	assert.Equal(t, b, peeked)
+	peeked, err = req.PeekBody(1024)
+	require.NoError(t, err)
+	assert.Equal(t, b, peeked)
+	bout := map[string]string{}
+	require.NoError(t, req.Decode(&bout))
+	assert.Equal(t, "b", bout["a"])
+
+	// Larger than the limit: an error, and the body is left intact
+	req = NewRequest(nil, "POST", "/", nil)
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	_, err = req.PeekBody(4)
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.body_too_large"))
+	all, err := req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, b, all)
+}
+
+func TestRequestClone(t *testing.T) {
+	t.Parallel()
+	type ctxKey struct{}
+	req := NewRequest(context.Background(), "POST", "/foo?a=b", map[string]string{"a": "b"})
+	req.Header.Set("X-Foo", "1")
+	ctx := context.WithValue(context.Background(), ctxKey{}, "clone")
+	clone := req.Clone(ctx)
+
+	assert.Equal(t, "clone", clone.Value(ctxKey{}))
+	assert.Nil(t, req.Value(ctxKey{}))
+	clone.Header.Set("X-Foo", "2")
+	clone.URL.Path = "/bar"
+	assert.Equal(t, "1", req.Header.Get("X-Foo"))
+	assert.Equal(t, "/foo", req.URL.Path)
+
+	// Both can read the buffered body independently
+	b, err := clone.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":\"b\"}\n", string(b))
+	b, err = req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":\"b\"}\n", string(b))
+
+	// A nil context leaves the original's in place
+	clone = req.Clone(nil)
+	assert.Equal(t, req.Context, clone.Context)
+
+	// An unbuffered body is replayed with GetBody, if possible
+	req = NewRequest(nil, "POST", "/", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("abc"))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("abc")), nil
+	}
+	clone = req.Clone(nil)
+	b, err = clone.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+	b, err = req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+}
+
+func TestRequestRewindable(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "POST", "/", map[string]string{"a": "b"})
+	assert.True(t, req.Rewindable())
+	_, err := req.BodyBytes(false)
+	require.NoError(t, err)
+	assert.True(t, req.Rewindable(), "peeking at the body shouldn't consume it")
+	ioutil.ReadAll(req.Body)
+	assert.False(t, req.Rewindable())
+
+	req = NewRequest(nil, "GET", "/", nil)
+	req.Body = nil
+	assert.True(t, req.Rewindable())
+	req.Body = http.NoBody
+	assert.True(t, req.Rewindable())
+
+	// An unbuffered body is only rewindable with GetBody, though it can be buffered with BodyBytes
+	req.Body = ioutil.NopCloser(strings.NewReader("abc"))
+	assert.False(t, req.Rewindable())
+	_, err = req.BodyBytes(false)
+	require.NoError(t, err)
+	assert.True(t, req.Rewindable())
+	req.Body = ioutil.NopCloser(strings.NewReader("abc"))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("abc")), nil
+	}
+	assert.True(t, req.Rewindable())
+}
+
+func TestNewStreamingRequest(t *testing.T) {
+	t.Parallel()
+	received := make(chan string)
+	s, err := Listen(Service(func(req Request) Response {
+		assert.Equal(t, []string{"chunked"}, req.TransferEncoding)
+		buf := make([]byte, 5)
+		for {
+			n, err := io.ReadFull(req.Body, buf)
+			if n > 0 {
+				received <- string(buf[:n])
+			}
+			if err != nil {
+				break
+			}
+		}
+		close(received)
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	pr, pw := io.Pipe()
+	req := NewStreamingRequest(nil, "POST", "http://"+s.Listener().Addr().String()+"/", pr)
+	assert.True(t, req.Streaming())
+	assert.False(t, req.Rewindable())
+	assert.False(t, NewRequest(nil, "POST", "/", "x").Streaming())
+	f := req.Send()
+
+	// Each chunk reaches the server before the next is written, so nothing is buffered along the way
+	for _, chunk := range []string{"hello", "world"} {
+		_, err := pw.Write([]byte(chunk))
+		require.NoError(t, err)
+		select {
+		case got := <-received:
+			assert.Equal(t, chunk, got)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "chunk was not streamed")
+		}
+	}
+	pw.Close()
+	rsp := f.Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+}
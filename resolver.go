@@ -0,0 +1,156 @@
+package typhon
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A CachingResolver resolves hostnames to addresses, caching the results. Once a cached result is older than the TTL,
+// it continues to be used while it is refreshed in the background; if refreshing fails, the stale result is kept.
+// It is used by a RoundTripper constructed with ClientConfig.Resolver set. The zero value is ready to use.
+type CachingResolver struct {
+	// TTL is how long a resolved address is used before being refreshed
+	TTL time.Duration
+	// Lookup resolves a host to its addresses. If nil, net.DefaultResolver is used.
+	Lookup func(ctx context.Context, host string) ([]string, error)
+
+	m         sync.Mutex
+	entries   map[string]*resolverEntry
+	overrides map[string][]string
+	now       func() time.Time
+}
+
+type resolverEntry struct {
+	addrs      []string
+	expires    time.Time
+	refreshing bool
+}
+
+// NewCachingResolver returns a CachingResolver which caches results for the given TTL
+func NewCachingResolver(ttl time.Duration) *CachingResolver {
+	return &CachingResolver{
+		TTL:       ttl,
+		entries:   make(map[string]*resolverEntry),
+		overrides: make(map[string][]string),
+		now:       time.Now}
+}
+
+// Override pins the given host to the given addresses, bypassing resolution entirely. Calling it with no addresses
+// removes an override.
+func (r *CachingResolver) Override(host string, addrs ...string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if len(addrs) == 0 {
+		delete(r.overrides, host)
+		return
+	}
+	if r.overrides == nil {
+		r.overrides = make(map[string][]string)
+	}
+	r.overrides[host] = addrs
+}
+
+// lookup resolves host without the cache, returning an error if it resolves to no addresses
+func (r *CachingResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	var addrs []string
+	var err error
+	if r.Lookup != nil {
+		addrs, err = r.Lookup(ctx, host)
+	} else {
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err == nil && len(addrs) == 0 {
+		err = terrors.NotFound("host", "No addresses found for host", map[string]string{
+			"host": host})
+	}
+	return addrs, err
+}
+
+// timeNow returns the current time, from the resolver's clock if it has one
+func (r *CachingResolver) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// LookupHost returns the addresses of the given host
+func (r *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.m.Lock()
+	if addrs, ok := r.overrides[host]; ok {
+		r.m.Unlock()
+		return addrs, nil
+	}
+	e, ok := r.entries[host]
+	if ok {
+		addrs := e.addrs
+		if !e.refreshing && !r.timeNow().Before(e.expires) {
+			e.refreshing = true
+			go r.refresh(host, e)
+		}
+		r.m.Unlock()
+		return addrs, nil
+	}
+	r.m.Unlock()
+
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.m.Lock()
+	if r.entries == nil {
+		r.entries = make(map[string]*resolverEntry)
+	}
+	r.entries[host] = &resolverEntry{
+		addrs:   addrs,
+		expires: r.timeNow().Add(r.TTL)}
+	r.m.Unlock()
+	return addrs, nil
+}
+
+func (r *CachingResolver) refresh(host string, e *resolverEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	addrs, err := r.lookup(ctx, host)
+	r.m.Lock()
+	defer r.m.Unlock()
+	e.refreshing = false
+	if err != nil {
+		return // Keep serving the stale addresses; the next lookup will try again
+	}
+	e.addrs = addrs
+	e.expires = r.timeNow().Add(r.TTL)
+}
+
+// dialFunc is the signature of net.Dialer's DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialContext wraps a dial function such that hostnames are resolved by r. Each resolved address is tried in turn
+// until a connection succeeds.
+func (r *CachingResolver) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn
+		for _, a := range addrs {
+			if conn, err = dial(ctx, network, net.JoinHostPort(a, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
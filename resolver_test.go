@@ -0,0 +1,120 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingResolver(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	m := sync.Mutex{}
+	lookups, answer, fail := 0, "10.0.0.1", false
+	refreshed := make(chan struct{}, 10)
+	r := NewCachingResolver(time.Minute)
+	r.now = func() time.Time {
+		m.Lock()
+		defer m.Unlock()
+		return now
+	}
+	r.Lookup = func(ctx context.Context, host string) ([]string, error) {
+		m.Lock()
+		defer m.Unlock()
+		defer func() { refreshed <- struct{}{} }()
+		lookups++
+		if fail {
+			return nil, errors.New("resolution failed")
+		}
+		return []string{answer}, nil
+	}
+	ctx := context.Background()
+
+	addrs, err := r.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+	<-refreshed
+	addrs, _ = r.LookupHost(ctx, "example.com")
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+	assert.Equal(t, 1, lookups) // cached
+
+	// Once stale, the old answer is served while refreshing in the background
+	m.Lock()
+	now, answer = now.Add(2*time.Minute), "10.0.0.2"
+	m.Unlock()
+	addrs, _ = r.LookupHost(ctx, "example.com")
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+	<-refreshed
+	addrs, _ = r.LookupHost(ctx, "example.com")
+	assert.Equal(t, []string{"10.0.0.2"}, addrs)
+
+	// Failure to refresh keeps the stale answer
+	m.Lock()
+	now, fail = now.Add(2*time.Minute), true
+	m.Unlock()
+	r.LookupHost(ctx, "example.com")
+	<-refreshed
+	addrs, err = r.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2"}, addrs)
+
+	// Overrides and IPs bypass resolution altogether
+	r.Override("pinned.internal", "192.168.0.1")
+	addrs, _ = r.LookupHost(ctx, "pinned.internal")
+	assert.Equal(t, []string{"192.168.0.1"}, addrs)
+	addrs, _ = r.LookupHost(ctx, "127.0.0.1")
+	assert.Equal(t, []string{"127.0.0.1"}, addrs)
+}
+
+func TestCachingResolverZeroValue(t *testing.T) {
+	t.Parallel()
+	answers := map[string][]string{
+		"example.com": {"10.0.0.1"}}
+	r := &CachingResolver{
+		Lookup: func(ctx context.Context, host string) ([]string, error) {
+			return answers[host], nil
+		}}
+	ctx := context.Background()
+
+	addrs, err := r.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+
+	// An empty result is an error, rather than no addresses
+	addrs, err = r.LookupHost(ctx, "missing.example.com")
+	assert.Empty(t, addrs)
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrNotFound), "%v", err)
+
+	r.Override("missing.example.com", "10.0.0.2")
+	addrs, err = r.LookupHost(ctx, "missing.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2"}, addrs)
+}
+
+func TestCachingResolverClient(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response(req.Host)
+	}), "127.0.0.1:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	_, port, _ := net.SplitHostPort(s.Listener().Addr().String())
+
+	cfg := DefaultClientConfig()
+	cfg.Resolver = NewCachingResolver(time.Minute)
+	cfg.Resolver.Override("service.internal", "127.0.0.1")
+	client := HttpService(NewRoundTripper(cfg)).Filter(ErrorFilter)
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://service.internal:%s/", port), nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "service.internal:"+port, body)
+}
@@ -2,11 +2,12 @@ package typhon
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/monzo/terrors"
 )
@@ -18,21 +19,49 @@ type Response struct {
 	Request *Request // The Request that we are responding to
 }
 
-// Encode serialises the passed object as JSON into the body (and sets appropriate headers).
+// Encode serialises the passed object into the body (and sets appropriate headers), using the Codec registered for
+// the response's Content-Type. If no Content-Type is set, or none is registered for it, the object is encoded as JSON.
 func (r *Response) Encode(v interface{}) {
+	contentType := ""
+	if r.Response != nil {
+		contentType = r.Header.Get("Content-Type")
+	}
+	enc, contentType := codecEncoder(contentType)
+	r.encode(enc, contentType, v)
+}
+
+// EncodeNegotiated serialises the passed object into the body in a format acceptable to the client, according to the
+// Accept header of the Request being responded to. JSON is preferred where the client has no preference. If no
+// supported format is acceptable, the response's Error is set to a bad response (406) error.
+func (r *Response) EncodeNegotiated(v interface{}) {
+	accept := ""
+	if r.Request != nil {
+		accept = r.Request.Header.Get("Accept")
+	}
+	enc, ok := negotiateEncoder(accept)
+	if !ok {
+		r.Error = terrors.BadResponse("not_acceptable", "No acceptable content type", map[string]string{
+			"accept": accept})
+		return
+	}
+	r.encode(enc.encode, enc.contentType, v)
+}
+
+func (r *Response) encode(enc encoderFunc, contentType string, v interface{}) {
 	cw := &countingWriter{
 		Writer: r}
-	if err := json.NewEncoder(cw).Encode(v); err != nil {
+	if err := enc(cw, v); err != nil {
 		r.Error = terrors.Wrap(err, nil)
 		return
 	}
-	r.Header.Set("Content-Type", "application/json")
-	if r.ContentLength < 0 && cw.n < chunkThreshold {
+	r.Header.Set("Content-Type", contentType)
+	if r.ContentLength < 0 && cw.n < ChunkThreshold {
 		r.ContentLength = int64(cw.n)
 	}
 }
 
-// Decode de-serialises the JSON body into the passed object.
+// Decode de-serialises the body into the passed object, using the Codec registered for the response's Content-Type. A
+// body without a Content-Type, or with one for which no Codec is registered, is decoded as JSON.
 func (r *Response) Decode(v interface{}) error {
 	err := error(nil)
 	if r.Error != nil {
@@ -40,10 +69,11 @@ func (r *Response) Decode(v interface{}) error {
 	} else if r.Response == nil {
 		err = terrors.InternalService("", "Response has no body", nil)
 	} else {
+		c, _ := codecFor(r.Header.Get("Content-Type"))
 		var b []byte
 		b, err = r.BodyBytes(true)
 		if err == nil {
-			err = json.Unmarshal(b, v)
+			err = c.Unmarshal(b, v)
 		}
 		err = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
 	}
@@ -100,6 +130,19 @@ func (r *Response) BodyBytes(consume bool) ([]byte, error) {
 	}
 }
 
+// SetRetryAfter sets the Retry-After header, telling clients to wait at least d before retrying the request. The
+// header is in whole seconds, so d is rounded up (and a non-positive d is sent as 0).
+func (r *Response) SetRetryAfter(d time.Duration) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	secs := int64(0)
+	if d > 0 {
+		secs = int64((d + time.Second - 1) / time.Second)
+	}
+	r.Header.Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
 // Writer returns a ResponseWriter proxy.
 func (r *Response) Writer() ResponseWriter {
 	return responseWriterWrapper{
@@ -129,7 +172,7 @@ func newHTTPResponse(req Request) *http.Response {
 		ProtoMinor:    req.ProtoMinor,
 		ContentLength: -1,
 		Header:        make(http.Header, 5),
-		Body:          &bufCloser{}}
+		Body:          newBufCloser()}
 }
 
 // NewResponse constructs a Response
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -108,7 +109,7 @@ func TestResponseBodyBytes_Consuming(t *testing.T) {
 	assert.Equal(t, 1, body.closed) // The reader should have been closed
 
 	// Specialised case: *bufCloser
-	rsp.Body = &bufCloser{*bytes.NewBuffer([]byte("def"))}
+	rsp.Body = &bufCloser{Buffer: *bytes.NewBuffer([]byte("def"))}
 	b, err = rsp.BodyBytes(true)
 	require.NoError(t, err)
 	assert.Equal(t, []byte("def"), b)
@@ -134,7 +135,7 @@ func TestResponseBodyBytes_Preserving(t *testing.T) {
 	}
 
 	// Specialised case: *bufCloser
-	rsp.Body = &bufCloser{*bytes.NewBuffer([]byte("def"))}
+	rsp.Body = &bufCloser{Buffer: *bytes.NewBuffer([]byte("def"))}
 	for i := 0; i < 100; i++ { // Repeated reads should yield the same result
 		b, err := rsp.BodyBytes(false)
 		require.NoError(t, err)
@@ -173,3 +174,77 @@ func BenchmarkRepeatedResponseBodyBytes(b *testing.B) {
 		rsp.BodyBytes(false)
 	}
 }
+
+func TestResponseSetRetryAfter(t *testing.T) {
+	t.Parallel()
+	cases := map[time.Duration]string{
+		-time.Second:            "0",
+		0:                       "0",
+		time.Millisecond:        "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60"}
+	for d, expected := range cases {
+		rsp := Response{}
+		rsp.SetRetryAfter(d)
+		assert.Equal(t, expected, rsp.Header.Get("Retry-After"), "%v", d)
+	}
+}
+
+func TestResponseEncodeNegotiated(t *testing.T) {
+	t.Parallel()
+	type body struct {
+		XMLName struct{} `json:"-" xml:"body"`
+		A       string   `json:"a" xml:"a"`
+	}
+	cases := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json", "{\"a\":\"b\"}\n"},
+		{"*/*", "application/json", "{\"a\":\"b\"}\n"},
+		{"application/xml", "application/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"text/html, text/*;q=0.9, */*;q=0.1", "text/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"application/json;q=0.5, application/xml", "application/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"application/*, application/xml", "application/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"text/html", "", ""},
+		{"application/json;q=0", "", ""},
+		// Types refused explicitly aren't accepted by wildcards
+		{"application/json;q=0, */*", "application/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"application/json;q=0, application/*;q=0, */*", "text/xml; charset=utf-8", "<body><a>b</a></body>"},
+		{"application/*;q=0, text/*;q=0, */*", "", ""},
+		{"*/*;q=0", "", ""},
+	}
+	for _, c := range cases {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("Accept", c.accept)
+		rsp := NewResponse(req)
+		rsp.EncodeNegotiated(body{A: "b"})
+		if c.contentType == "" {
+			require.Error(t, rsp.Error, c.accept)
+			assert.Equal(t, http.StatusNotAcceptable, ErrorStatusCode(rsp.Error))
+			continue
+		}
+		require.NoError(t, rsp.Error, c.accept)
+		assert.Equal(t, c.contentType, rsp.Header.Get("Content-Type"), c.accept)
+		b, _ := rsp.BodyBytes(true)
+		assert.Equal(t, c.body, string(b), c.accept)
+	}
+}
+
+func TestResponseEncodeChunkThreshold(t *testing.T) {
+	defer func(threshold int) { ChunkThreshold = threshold }(ChunkThreshold)
+	body := strings.Repeat("a", 100)
+
+	rsp := NewResponse(Request{})
+	rsp.Encode(body)
+	assert.Equal(t, int64(len(body)+3), rsp.ContentLength) // quotes and newline
+
+	ChunkThreshold = 50
+	rsp = NewResponse(Request{})
+	rsp.Encode(body)
+	assert.Equal(t, int64(-1), rsp.ContentLength)
+	req := NewRequest(nil, "POST", "/", body)
+	assert.Equal(t, int64(-1), req.ContentLength)
+}
@@ -30,7 +30,7 @@ func (rw responseWriterWrapper) WriteHeader(status int) {
 }
 
 func (rw responseWriterWrapper) WriteJSON(v interface{}) {
-	rw.r.Encode(v)
+	rw.r.encode(encodeJSON, "application/json", v)
 }
 
 func (rw responseWriterWrapper) WriteError(err error) {
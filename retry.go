@@ -0,0 +1,142 @@
+package typhon
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryOptions configures the behaviour of RetryFilter. The zero value is usable: it makes up to 3 attempts of
+// idempotent requests which fail with a transport error or a 502, 503 or 504.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of attempts, including the first. If zero, 3 is used.
+	MaxAttempts int
+	// InitialBackoff is the upper bound on the (randomly jittered) wait before the first retry. Each subsequent retry
+	// doubles it, up to MaxBackoff. If zero, 50ms and 2s are used respectively.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// AttemptTimeout, if non-zero, bounds the duration of each attempt
+	AttemptTimeout time.Duration
+	// Timeout, if non-zero, bounds the total duration of all attempts (including backoff)
+	Timeout time.Duration
+	// Retryable decides whether a request may be retried at all. If nil, requests with idempotent methods are.
+	Retryable func(req Request) bool
+	// ShouldRetry decides whether a response warrants a retry. If nil, DefaultShouldRetry is used.
+	ShouldRetry func(req Request, rsp Response) bool
+}
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true}
+
+// IsIdempotent returns whether the request's method is idempotent, and so is safe to retry
+func IsIdempotent(req Request) bool {
+	return idempotentMethods[req.Method]
+}
+
+// DefaultShouldRetry returns whether a response indicates a transient failure: a transport-level error (where no
+// response was received), or a 502, 503 or 504.
+func DefaultShouldRetry(req Request, rsp Response) bool {
+	if rsp.Response == nil {
+		return rsp.Error != nil
+	}
+	switch rsp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryFilter returns a Filter which retries failed requests with jittered exponential backoff. The request body is
+// buffered so that it can be replayed; if it is already buffered but has been read (see Request.Rewindable), it can't
+// be replayed in full, so the request is sent once and never retried, rather than being retried with a truncated body.
+// Retries are decided solely on the status and headers of a response: once a response has been returned, its body may
+// be read without fear of it being retried. Requests with streamed bodies (see NewStreamingRequest) are never retried.
+func RetryFilter(opts RetryOptions) Filter {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 50 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 2 * time.Second
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsIdempotent
+	}
+	if opts.ShouldRetry == nil {
+		opts.ShouldRetry = DefaultShouldRetry
+	}
+
+	return func(req Request, svc Service) Response {
+		if !opts.Retryable(req) || !req.replayable() {
+			return svc(req)
+		}
+		body, err := req.bodyForReplay()
+		if err != nil {
+			rsp := NewResponse(req)
+			rsp.Error = err
+			return rsp
+		}
+		if req.Context == nil {
+			req.Context = context.Background()
+		}
+		if opts.Timeout > 0 {
+			req.cancel = nil // Only the context created here is released here
+			req.SetTimeout(opts.Timeout)
+		}
+		ctx, totalCancel := req.Context, req.cancel
+		req.cancel = nil
+
+		var rsp Response
+		backoff := opts.InitialBackoff
+		for attempt := 1; ; attempt++ {
+			attemptReq := req
+			if body != nil {
+				buf := &bufCloser{}
+				buf.Write(body)
+				attemptReq.Body = buf
+			}
+			if opts.AttemptTimeout > 0 {
+				attemptReq.SetTimeout(opts.AttemptTimeout)
+			}
+			rsp = svc(attemptReq)
+			if attemptReq.cancel != nil {
+				releaseWithBody(attemptReq.Context, rsp, attemptReq.cancel)
+			}
+
+			if attempt >= opts.MaxAttempts || !opts.ShouldRetry(attemptReq, rsp) {
+				break
+			}
+			wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+				break // There's no time left for another attempt
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			// This response is being discarded
+			if rsp.Response != nil && rsp.Body != nil {
+				rsp.Body.Close()
+			}
+			if backoff *= 2; backoff > opts.MaxBackoff {
+				backoff = opts.MaxBackoff
+			}
+		}
+
+		if totalCancel != nil {
+			releaseWithBody(ctx, rsp, totalCancel)
+		}
+		return rsp
+	}
+}
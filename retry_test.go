@@ -0,0 +1,154 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyService returns a service which fails with the given status code for the first n requests
+func flakyService(n int32, status int, calls *int32) Service {
+	return func(req Request) Response {
+		body := ""
+		req.Decode(&body)
+		rsp := req.Response(body)
+		if atomic.AddInt32(calls, 1) <= n {
+			rsp.StatusCode = status
+		}
+		return rsp
+	}
+}
+
+func TestRetryFilter(t *testing.T) {
+	t.Parallel()
+	opts := RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond}
+
+	calls := int32(0)
+	svc := flakyService(2, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(opts))
+	rsp := svc(NewRequest(nil, "PUT", "/", "replayed"))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, int32(3), calls)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "replayed", body) // The body was replayed on each attempt
+
+	// Gives up after MaxAttempts
+	calls = 0
+	svc = flakyService(5, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(opts))
+	rsp = svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.Equal(t, int32(3), calls)
+
+	// Non-transient failures and non-idempotent requests aren't retried
+	calls = 0
+	svc = flakyService(5, http.StatusBadRequest, &calls).Filter(RetryFilter(opts))
+	svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, int32(1), calls)
+	calls = 0
+	svc = flakyService(5, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(opts))
+	svc(NewRequest(nil, "POST", "/", nil))
+	assert.Equal(t, int32(1), calls)
+
+	// Unless configured otherwise
+	calls = 0
+	opts.Retryable = func(Request) bool { return true }
+	svc = flakyService(1, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(opts))
+	rsp = svc(NewRequest(nil, "POST", "/", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestRetryFilterBudgets(t *testing.T) {
+	t.Parallel()
+	calls := int32(0)
+	slow := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		<-req.Done()
+		return Response{
+			Error: terrors.Timeout("", req.Err().Error(), nil)}
+	})
+
+	// Each attempt is bounded, and so is the total
+	svc := slow.Filter(RetryFilter(RetryOptions{
+		MaxAttempts:    100,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		AttemptTimeout: 10 * time.Millisecond,
+		Timeout:        55 * time.Millisecond}))
+	start := time.Now()
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.Error(t, rsp.Error)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+	assert.True(t, calls >= 2 && calls <= 6, "%d calls", calls)
+
+	// The request's own deadline is respected too
+	calls = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	svc = slow.Filter(RetryFilter(RetryOptions{
+		MaxAttempts:    100,
+		InitialBackoff: time.Millisecond,
+		AttemptTimeout: 10 * time.Millisecond}))
+	start = time.Now()
+	svc(NewRequest(ctx, "GET", "/", nil))
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+	assert.True(t, calls <= 4, "%d calls", calls)
+}
+
+func TestRetryFilterUnreplayableBody(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	svc := flakyService(1, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(RetryOptions{
+		InitialBackoff: time.Millisecond,
+		Retryable: func(req Request) bool {
+			return true
+		}}))
+
+	// A body which has been partly read would be replayed truncated, so the request is sent but not retried
+	req := NewRequest(nil, "POST", "/", "hello")
+	buf := make([]byte, 2)
+	req.Body.Read(buf)
+	assert.False(t, req.Rewindable())
+	rsp := svc(req)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	req = NewRequest(nil, "POST", "/", "hello")
+	assert.True(t, req.Rewindable())
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRetryFilterStreamingBody(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	svc := flakyService(1, http.StatusServiceUnavailable, &calls).Filter(RetryFilter(RetryOptions{
+		InitialBackoff: time.Millisecond,
+		Retryable: func(req Request) bool {
+			return true
+		}}))
+
+	// A streamed body can't be replayed, so the request is sent just once
+	req := NewStreamingRequest(nil, "POST", "/", strings.NewReader(`"hello"`))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -3,31 +3,121 @@ package typhon
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/labstack/echo"
 	"github.com/monzo/terrors"
 )
 
-var routerContextKey = struct{}{}
+type routerContextKeyType int
+
+const (
+	routerContextKey routerContextKeyType = iota
+	routerParamsContextKey
+	routePatternContextKey        // *string recording the pattern, for filters outside the Router
+	routeMatchedPatternContextKey // the pattern, for the matched route's service
+)
+
+// routerMethods is the set of methods to which * is expanded on registration
+var routerMethods = [...]string{"GET", "CONNECT", "DELETE", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE"}
 
 // A Router multiplexes requests to a set of Services by pattern matching on method and path, and can also extract
 // parameters from paths.
 type Router struct {
-	e    *echo.Echo
-	r    *echo.Router
-	svcs map[string]Service
-	m    *sync.RWMutex
+	// DisableMethodNotAllowed causes requests for a path which is registered only for other methods to be rejected
+	// with 404 Not Found, rather than 405 Method Not Allowed with an Allow header listing the registered methods.
+	DisableMethodNotAllowed bool
+	// DisableAutoOptions stops OPTIONS requests being answered automatically. By default, an OPTIONS request for a path
+	// which has routes registered (but not one for OPTIONS) receives a 204 No Content with an Allow header listing the
+	// methods registered for it, and OPTIONS * receives one listing the methods registered for any path.
+	DisableAutoOptions bool
+	// DisableAutoHead stops HEAD requests being served by GET routes. By default, a HEAD request for a path which has a
+	// GET route (but no HEAD route) is served by the GET route's service, with the body of its response discarded and
+	// its length reported in the Content-Length header (unless the response is streamed).
+	DisableAutoHead bool
+	// CaseInsensitive causes paths to be matched without regard to the case of ASCII letters, so /Users/42 matches
+	// /users/:id (and /users/42 matches /Users/:id). It applies to routes registered after it is set. Parameters are
+	// extracted from the path as requested, with their case preserved, and query strings are unaffected. Trailing
+	// slash normalisation applies as usual, and doesn't change the case of the path it redirects to.
+	CaseInsensitive bool
+	// RedirectTrailingSlash causes requests whose path doesn't match any route, but which would match one with a
+	// trailing slash added or removed, to be redirected to that path: with 301 Moved Permanently for GET and HEAD
+	// requests, and 308 Permanent Redirect otherwise. Paths which match a route as-is (for any method) are never
+	// redirected.
+	RedirectTrailingSlash bool
+	// RewriteTrailingSlash is like RedirectTrailingSlash, but dispatches such requests to the matching route directly
+	// (with the rewritten path) rather than redirecting them.
+	RewriteTrailingSlash bool
+	// CanonicalTrailingSlash restricts trailing slash normalisation to paths in the non-canonical form. By default
+	// either form is normalised to whichever has a matching route.
+	CanonicalTrailingSlash TrailingSlash
+
+	e       *echo.Echo
+	r       *echo.Router
+	routes  map[string]route
+	names   map[string]route
+	m       *sync.RWMutex
+	prefix  string   // prepended to patterns registered via this Router (see Group)
+	filters []Filter // applied to services registered via this Router (see Group)
+}
+
+// TrailingSlash identifies a canonical form for trailing slashes in paths.
+type TrailingSlash int
+
+const (
+	// TrailingSlashEither does not prefer either form
+	TrailingSlashEither TrailingSlash = iota
+	// TrailingSlashAlways considers paths with a trailing slash canonical
+	TrailingSlashAlways
+	// TrailingSlashNever considers paths without a trailing slash canonical
+	TrailingSlashNever
+)
+
+// RouteInfo describes a route registered with a Router.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	Name    string // Empty unless the route was registered with a name
+}
+
+type route struct {
+	RouteInfo
+	svc         Service
+	path        string                    // pattern as registered with echo (ie. without constraints)
+	constraints map[string]*regexp.Regexp // parameter name: constraint
+	wildcard    string                    // name of the parameter captured by a trailing wildcard, if any
 }
 
 // NewRouter vends a new implementation of Router
 func NewRouter() Router {
 	e := echo.New()
 	return Router{
-		e:    e,
-		r:    echo.NewRouter(e),
-		svcs: make(map[string]Service, 10),
-		m:    new(sync.RWMutex)}
+		e:      e,
+		r:      echo.NewRouter(e),
+		routes: make(map[string]route, 10),
+		names:  make(map[string]route),
+		m:      new(sync.RWMutex)}
+}
+
+// Group returns a Router which shares the receiver's routing table, but which prefixes the patterns of routes
+// registered through it with prefix and wraps their services in the given filters. The filters are applied in order,
+// so the first is the outermost. Groups may be nested, in which case prefixes are concatenated and the filters of
+// outer groups are applied outside those of inner ones.
+func (r *Router) Group(prefix string, filters ...Filter) *Router {
+	g := *r
+	g.prefix = r.prefix + strings.TrimSuffix(prefix, "/")
+	g.filters = make([]Filter, 0, len(r.filters)+len(filters))
+	g.filters = append(g.filters, r.filters...)
+	g.filters = append(g.filters, filters...)
+	return &g
 }
 
 // RouterForRequest returns a pointer to the Router that successfully dispatched the request, or nil.
@@ -42,22 +132,207 @@ func RouterForRequest(r Request) *Router {
 //
 // Method is a single HTTP method name, or * which is expanded to {OPTIONS, GET, HEAD, POST, PUT, DELETE, TRACE}.
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) Register(method, pattern string, svc Service) {
-	echoHandler := func(c echo.Context) error { return nil }
+//
+// A :param may be followed by a regular expression in parentheses, as in /orders/:id(\d+), which the whole value of
+// the parameter must match for the route to match. Requests which don't satisfy a route's constraints are treated as
+// if the route did not exist. An invalid expression panics.
+//
+// A pattern may end in a wildcard segment, written as * or *name, which captures the remainder of the path (including
+// any slashes) into the parameter "*" or "name" respectively. The wildcard must be the final segment of the pattern,
+// and only one wildcard may be registered for each method at a given prefix; violating either rule panics. Static and
+// :param routes sharing a wildcard's prefix are more specific and are always preferred over the wildcard.
+//
+// Registering a pattern which is already registered for the method, or which differs from one only in the names or
+// constraints of its parameters (as /users/:id and /users/:name do), panics: such patterns would match the same
+// paths, so which was used would depend on the order of registration.
+//
+// Any filters given wrap only this route's service. They are applied in order (so the first is the outermost), inside
+// the filters of the groups through which the route is registered; filters applied to the Router's Serve() are
+// outside both.
+func (r *Router) Register(method, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed("", method, pattern, svc, filters...)
+}
+
+// RegisterNamed is like Register, but also associates a name with the route so that paths to it can be constructed
+// with Path. A name may be shared by routes with the same pattern (for different methods); registering a name which is
+// already in use for a different pattern panics.
+func (r *Router) RegisterNamed(name, method, pattern string, svc Service, filters ...Filter) {
+	pattern = r.prefix + pattern
+	for i := len(filters) - 1; i >= 0; i-- {
+		svc = svc.Filter(filters[i])
+	}
+	for i := len(r.filters) - 1; i >= 0; i-- {
+		svc = svc.Filter(r.filters[i])
+	}
 
 	r.m.Lock()
 	defer r.m.Unlock()
 
+	if name != "" {
+		if existing, ok := r.names[name]; ok && existing.Pattern != pattern {
+			panic(fmt.Sprintf("typhon: route name %s is already registered for pattern %s", name, existing.Pattern))
+		}
+	}
+
 	if method == "*" {
 		// Expand * to the set of all known methods
-		for _, m := range [...]string{"GET", "CONNECT", "DELETE", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE"} {
-			r.r.Add(m, pattern, echoHandler)
-			r.svcs[m+pattern] = svc
+		for _, m := range routerMethods {
+			r.add(name, m, pattern, svc)
 		}
 	} else {
-		r.r.Add(method, pattern, echoHandler)
-		r.svcs[method+pattern] = svc
+		r.add(name, method, pattern, svc)
+	}
+}
+
+// add registers a route for a single method. Callers must hold the write lock.
+func (r *Router) add(name, method, pattern string, svc Service) {
+	path, constraints := parsePattern(pattern)
+	if r.CaseInsensitive {
+		// Paths are folded before they are matched, so the pattern's static segments must be too
+		path = foldPattern(path)
+	}
+	rt := route{
+		RouteInfo:   RouteInfo{Method: method, Pattern: pattern, Name: name},
+		svc:         svc,
+		path:        path,
+		constraints: constraints,
+		wildcard:    wildcardName(path)}
+	shape := routeShape(path)
+	for _, other := range r.routes {
+		if other.Method != method {
+			continue
+		}
+		switch {
+		case other.Pattern == pattern:
+			panic(fmt.Sprintf("typhon: route %s %s is already registered", method, pattern))
+		case routeShape(other.path) == shape:
+			// The patterns differ only in the names (or constraints) of their parameters, so would match the same paths
+			panic(fmt.Sprintf("typhon: route %s %s conflicts with %s %s", method, pattern, other.Method,
+				other.Pattern))
+		case rt.wildcard != "" && other.wildcard != "" && wildcardPrefix(other.path) == wildcardPrefix(path):
+			panic(fmt.Sprintf("typhon: wildcard route %s %s conflicts with %s %s", method, pattern, other.Method,
+				other.Pattern))
+		}
+	}
+
+	r.r.Add(method, path, func(c echo.Context) error { return nil })
+	r.routes[method+path] = rt
+	if name != "" {
+		r.names[name] = rt
+	}
+}
+
+// parsePattern returns the pattern with any parameter constraints removed (as understood by echo), along with the
+// compiled constraints keyed by parameter name. It panics if a constraint is malformed.
+func parsePattern(pattern string) (string, map[string]*regexp.Regexp) {
+	var constraints map[string]*regexp.Regexp
+	path := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		path = append(path, pattern[i])
+		if pattern[i] != ':' {
+			continue
+		}
+
+		j := i + 1
+		for j < len(pattern) && pattern[j] != '/' && pattern[j] != '(' {
+			j++
+		}
+		name := pattern[i+1 : j]
+		path = append(path, name...)
+		i = j - 1
+		if j == len(pattern) || pattern[j] != '(' {
+			continue
+		}
+
+		// Find the closing parenthesis, taking care of nesting and escaping within the expression
+		depth, end := 0, -1
+		for k := j; k < len(pattern) && end < 0; k++ {
+			switch pattern[k] {
+			case '\\':
+				k++
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					end = k
+				}
+			}
+		}
+		if end < 0 {
+			panic(fmt.Sprintf("typhon: unterminated constraint for parameter %s in pattern %s", name, pattern))
+		}
+		re, err := regexp.Compile("^(?:" + pattern[j+1:end] + ")$")
+		if err != nil {
+			panic(fmt.Sprintf("typhon: invalid constraint for parameter %s in pattern %s: %v", name, pattern, err))
+		}
+		if constraints == nil {
+			constraints = make(map[string]*regexp.Regexp, 1)
+		}
+		constraints[name] = re
+		i = end
+	}
+	return string(path), constraints
+}
+
+// routeShape returns the pattern (as registered with echo) with the names of its parameters and wildcard removed.
+// Patterns with the same shape match exactly the same paths.
+func routeShape(pattern string) string {
+	shape := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		shape = append(shape, pattern[i])
+		switch pattern[i] {
+		case ':':
+			for i+1 < len(pattern) && pattern[i+1] != '/' {
+				i++
+			}
+		case '*':
+			return string(shape)
+		}
+	}
+	return string(shape)
+}
+
+// wildcardPrefix returns the portion of pattern preceding its wildcard segment.
+func wildcardPrefix(pattern string) string {
+	if i := strings.IndexByte(pattern, '*'); i >= 0 {
+		return pattern[:i]
 	}
+	return pattern
+}
+
+// wildcardName returns the name of the parameter captured by the pattern's trailing wildcard, or an empty string if it
+// has none. It panics if the wildcard is not the final segment of the pattern.
+func wildcardName(pattern string) string {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return ""
+	}
+	name := pattern[i+1:]
+	if i == 0 || pattern[i-1] != '/' || strings.ContainsAny(name, "/:*") {
+		panic(fmt.Sprintf("typhon: wildcard must be the final segment of pattern %s", pattern))
+	}
+	if name == "" {
+		return "*"
+	}
+	return name
+}
+
+// Routes returns information about every route registered with the Router, ordered by pattern and then by method.
+func (r Router) Routes() []RouteInfo {
+	r.m.RLock()
+	routes := make([]RouteInfo, 0, len(r.routes))
+	for _, rt := range r.routes {
+		routes = append(routes, rt.RouteInfo)
+	}
+	r.m.RUnlock()
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
 }
 
 // lookup is the internal version of Lookup, but it extracts path parameters into the passed map (and skips it if the
@@ -68,27 +343,356 @@ func (r Router) lookup(method, path string, params map[string]string) (Service,
 	c.Reset(nil, nil)
 	c.SetPath("") // Annoyingly, this isn't done as part of Reset()
 
+	matchPath := path
+	if r.CaseInsensitive {
+		matchPath = foldPath(path)
+	}
 	r.m.RLock()
-	r.r.Find(method, path, c)
+	r.r.Find(method, matchPath, c)
 	pattern := c.Path()
 	if pattern == "" {
 		r.m.RUnlock()
 		return nil, "", false
 	}
-	svc := r.svcs[method+pattern]
+	rt := r.routes[method+pattern]
 	r.m.RUnlock()
 
-	if svc == nil {
+	if rt.svc == nil {
 		return nil, "", false
 	}
+	param := c.Param
+	if matchPath != path {
+		// Parameters are taken from the original path, so their case is preserved
+		values := patternParams(rt.path, path)
+		param = func(name string) string {
+			return values[name]
+		}
+	}
+	names := c.ParamNames()
+	if !rt.satisfiedBy(param) {
+		// Another, less specific route may also match the path (as /orders/*rest does /orders/abc when
+		// /orders/:id(\d+) doesn't), so fall back to the most specific of those whose constraints are satisfied
+		fallback, values, ok := r.fallbackRoute(method, matchPath, path, rt)
+		if !ok {
+			return nil, "", false
+		}
+		rt = fallback
+		param = func(name string) string {
+			return values[name]
+		}
+		// names aliases echo's routing tree, so mustn't be written to
+		names = make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+	}
 
 	if params != nil {
-		names := c.ParamNames()
 		for _, name := range names {
-			params[name] = c.Param(name)
+			if name == "*" {
+				params[rt.wildcard] = param(name)
+			} else {
+				params[name] = param(name)
+			}
+		}
+	}
+	return rt.svc, rt.Pattern, true
+}
+
+// satisfiedBy returns whether the parameters (as returned by param) satisfy the route's constraints
+func (rt route) satisfiedBy(param func(name string) string) bool {
+	for name, re := range rt.constraints {
+		if !re.MatchString(param(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// fallbackRoute returns the most specific route for the method other than failed which matches the path, and whose
+// constraints the path satisfies, along with its parameters (keyed as by patternParams). matchPath is the path as it is
+// matched against patterns (ie. folded, if the Router is case-insensitive).
+func (r Router) fallbackRoute(method, matchPath, path string, failed route) (route, map[string]string, bool) {
+	r.m.RLock()
+	candidates := []route{}
+	for _, rt := range r.routes {
+		if rt.Method == method && rt.path != failed.path && patternMatches(rt.path, matchPath) {
+			candidates = append(candidates, rt)
+		}
+	}
+	r.m.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return moreSpecific(candidates[i].path, candidates[j].path)
+	})
+	for _, rt := range candidates {
+		values := patternParams(rt.path, path)
+		if rt.satisfiedBy(func(name string) string { return values[name] }) {
+			return rt, values, true
+		}
+	}
+	return route{}, nil, false
+}
+
+// patternMatches returns whether the path matches pattern (as registered with echo), disregarding constraints
+func patternMatches(pattern, path string) bool {
+	i, j := 0, 0
+	for i < len(pattern) {
+		switch pattern[i] {
+		case '*':
+			return true
+		case ':':
+			end := strings.IndexByte(pattern[i:], '/')
+			if end < 0 {
+				end = len(pattern) - i
+			}
+			vEnd := strings.IndexByte(path[j:], '/')
+			if vEnd < 0 {
+				vEnd = len(path) - j
+			}
+			i, j = i+end, j+vEnd
+		default:
+			if j >= len(path) || path[j] != pattern[i] {
+				return false
+			}
+			i, j = i+1, j+1
+		}
+	}
+	return j == len(path)
+}
+
+// moreSpecific returns whether pattern a (as registered with echo) is preferred over b when both match a path: as with
+// echo, comparing their segments in turn, a static segment is preferred over a parameter, and a parameter over a
+// wildcard
+func moreSpecific(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if ra, rb := segmentRank(as[i]), segmentRank(bs[i]); ra != rb {
+			return ra < rb
+		}
+	}
+	return len(as) > len(bs)
+}
+
+func segmentRank(segment string) int {
+	switch {
+	case strings.HasPrefix(segment, ":"):
+		return 1
+	case strings.HasPrefix(segment, "*"):
+		return 2
+	}
+	return 0
+}
+
+// foldPath lower-cases the ASCII letters in path. Other characters are left alone, so that the folded path's bytes
+// line up with the original's.
+func foldPath(path string) string {
+	for i := 0; i < len(path); i++ {
+		if 'A' <= path[i] && path[i] <= 'Z' {
+			b := []byte(path)
+			for j := i; j < len(b); j++ {
+				if 'A' <= b[j] && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return path
+}
+
+// foldPattern lower-cases the ASCII letters in the static segments of pattern (as registered with echo), leaving the
+// names of its parameters alone
+func foldPattern(pattern string) string {
+	b := []byte(pattern)
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == '*':
+			return string(b)
+		case c == ':':
+			for i+1 < len(b) && b[i+1] != '/' {
+				i++
+			}
+		case 'A' <= c && c <= 'Z':
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// patternParams extracts the values of the parameters in pattern (as registered with echo) from a path which it
+// matches, keyed as echo would key them (ie. with "*" for the wildcard).
+func patternParams(pattern, path string) map[string]string {
+	params := make(map[string]string)
+	for i, j := 0, 0; i < len(pattern) && j <= len(path); {
+		switch pattern[i] {
+		case '*':
+			params["*"] = path[j:]
+			return params
+		case ':':
+			end := strings.IndexByte(pattern[i:], '/')
+			if end < 0 {
+				end = len(pattern) - i
+			}
+			vEnd := strings.IndexByte(path[j:], '/')
+			if vEnd < 0 {
+				vEnd = len(path) - j
+			}
+			params[pattern[i+1:i+end]] = path[j : j+vEnd]
+			i, j = i+end, j+vEnd
+		default:
+			i, j = i+1, j+1
+		}
+	}
+	return params
+}
+
+// Path constructs a path to the route registered with the given name, substituting the passed parameters (which are
+// pairs of parameter names and values) into its pattern. Values are escaped as necessary; for wildcard parameters, any
+// slashes in the value are preserved. An error is returned if the name is unknown, or if the parameters do not exactly
+// match those in the pattern.
+func (r Router) Path(name string, params ...string) (string, error) {
+	r.m.RLock()
+	rt, ok := r.names[name]
+	r.m.RUnlock()
+	if !ok {
+		return "", terrors.NotFound("route", fmt.Sprintf("No route named %s", name), nil)
+	}
+	if len(params)%2 != 0 {
+		return "", terrors.BadRequest("params", "Parameters must be passed as name/value pairs", map[string]string{
+			"route": name})
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	segments := strings.Split(rt.path, "/")
+	substituted := 0
+	for i, segment := range segments {
+		var param string
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			param = segment[1:]
+		case strings.HasPrefix(segment, "*"):
+			param = rt.wildcard
+		default:
+			continue
+		}
+		v, ok := values[param]
+		if !ok {
+			return "", terrors.BadRequest("missing_param", fmt.Sprintf("Missing parameter %s for route %s", param, name),
+				map[string]string{
+					"route": name,
+					"param": param})
+		}
+		if re, ok := rt.constraints[param]; ok && !re.MatchString(v) {
+			return "", terrors.BadRequest("invalid_param", fmt.Sprintf("Parameter %s does not satisfy the constraint "+
+				"for route %s", param, name), map[string]string{
+				"route": name,
+				"param": param})
+		}
+		substituted++
+		if segment[0] == '*' {
+			parts := strings.Split(v, "/")
+			for j, part := range parts {
+				parts[j] = url.PathEscape(part)
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(v)
+		}
+	}
+	if substituted != len(params)/2 {
+		return "", terrors.BadRequest("unknown_param", fmt.Sprintf("Unexpected parameters for route %s", name),
+			map[string]string{
+				"route": name})
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// allowedMethods returns the (sorted) methods for which a route matching path is registered.
+func (r Router) allowedMethods(path string) []string {
+	methods := []string{}
+	for _, m := range routerMethods {
+		if _, _, ok := r.lookup(m, path, nil); ok {
+			methods = append(methods, m)
 		}
 	}
-	return svc, pattern, true
+	sort.Strings(methods)
+	return methods
+}
+
+// registeredMethods returns the (sorted) methods for which any route is registered.
+func (r Router) registeredMethods() []string {
+	r.m.RLock()
+	seen := make(map[string]bool, len(routerMethods))
+	for _, rt := range r.routes {
+		seen[rt.Method] = true
+	}
+	r.m.RUnlock()
+	methods := make([]string, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// optionsResponse returns the automatic response to an OPTIONS request, given the methods registered for its path
+// (to which OPTIONS itself is added, as it is now answered).
+func optionsResponse(req Request, allowed []string) Response {
+	methods := append(make([]string, 0, len(allowed)+1), allowed...)
+	if i := sort.SearchStrings(methods, http.MethodOptions); i == len(methods) || methods[i] != http.MethodOptions {
+		methods = append(methods, http.MethodOptions)
+		sort.Strings(methods)
+	}
+	rsp := NewResponse(req)
+	rsp.StatusCode = http.StatusNoContent
+	rsp.Header.Set("Allow", strings.Join(methods, ", "))
+	return rsp
+}
+
+// lookupServing is like lookup, but serves HEAD requests with the GET route if there is no HEAD route (unless
+// DisableAutoHead is set)
+func (r Router) lookupServing(method, path string, params map[string]string) (Service, string, bool) {
+	svc, pattern, ok := r.lookup(method, path, params)
+	if !ok && method == http.MethodHead && !r.DisableAutoHead {
+		if svc, pattern, ok = r.lookup(http.MethodGet, path, params); ok {
+			svc = svc.Filter(headFilter)
+		}
+	}
+	return svc, pattern, ok
+}
+
+// headFilter adapts the response of a GET route's service to a HEAD request: its body is discarded, having been
+// measured (if its length isn't already known) to set Content-Length. Streaming responses are closed unread.
+func headFilter(req Request, svc Service) Response {
+	rsp := svc(req)
+	if rsp.Error != nil || rsp.Response == nil || rsp.Body == nil {
+		return rsp
+	}
+	if isStreamingRsp(rsp) {
+		rsp.Body.Close()
+		rsp.Body = &bufCloser{}
+		return rsp
+	}
+	n := rsp.ContentLength
+	if buf, ok := rsp.Body.(*bufCloser); ok {
+		n = int64(buf.Len())
+	} else if n < 0 {
+		var err error
+		if n, err = io.Copy(ioutil.Discard, rsp.Body); err != nil {
+			rsp.Error = terrors.Wrap(err, nil)
+		}
+	}
+	rsp.Body.Close()
+	rsp.Body = &bufCloser{}
+	rsp.ContentLength = n
+	if rsp.Error == nil && rsp.Header.Get("Content-Length") == "" {
+		rsp.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+	}
+	return rsp
 }
 
 // Lookup returns the Service, pattern, and extracted path parameters for the HTTP method and path.
@@ -101,73 +705,255 @@ func (r Router) Lookup(method, path string) (Service, string, map[string]string,
 // Serve returns a Service which will route inbound requests to the enclosed routes.
 func (r Router) Serve() Service {
 	return func(req Request) Response {
-		svc, _, ok := r.lookup(req.Method, req.URL.Path, nil)
+		params := map[string]string{}
+		path, escaped := routingPath(req.URL)
+		svc, pattern, ok := r.lookupServing(req.Method, path, params)
 		if !ok {
-			txt := fmt.Sprintf("No handler for %s %s", req.Method, req.URL.Path)
-			rsp := NewResponse(req)
-			rsp.Error = terrors.NotFound("no_handler", txt, nil)
-			return rsp
+			allowed := r.allowedMethods(path)
+			if req.Method == http.MethodOptions && !r.DisableAutoOptions {
+				if req.URL.Path == "*" {
+					return optionsResponse(req, r.registeredMethods())
+				} else if len(allowed) > 0 {
+					return optionsResponse(req, allowed)
+				}
+			}
+			// Trailing slash normalisation only applies to paths which don't match any route as-is
+			if alt, altOk := r.trailingSlashAlternative(path); altOk && len(allowed) == 0 {
+				if svc, pattern, ok = r.lookupServing(req.Method, alt, params); ok {
+					if !r.RewriteTrailingSlash {
+						return trailingSlashRedirect(req, alt)
+					}
+					u := *req.URL
+					u.Path, u.RawPath = alt, ""
+					if escaped {
+						u.Path, _ = url.PathUnescape(alt)
+						u.RawPath = alt
+					}
+					req.URL = &u
+				}
+			}
+			if !ok {
+				return r.unrouted(req, allowed)
+			}
+		}
+		if escaped {
+			unescapeParams(params)
+		}
+		if p, ok := req.Context.Value(routePatternContextKey).(*string); ok && *p == "" {
+			*p = pattern
 		}
 		req.Context = context.WithValue(req.Context, routerContextKey, &r)
+		req.Context = context.WithValue(req.Context, routerParamsContextKey, params)
+		req.Context = context.WithValue(req.Context, routeMatchedPatternContextKey, pattern)
 		return svc(req)
 	}
 }
 
+// withRoutePattern returns a context in which the pattern of the route that dispatches a request (if any) will be
+// recorded. This lets filters outside a Router see which route handled a request. If requests are dispatched by
+// nested Routers, the outermost route is recorded.
+func withRoutePattern(ctx context.Context) (context.Context, *string) {
+	p := new(string)
+	return context.WithValue(ctx, routePatternContextKey, p), p
+}
+
+// unrouted returns the response to a request which does not match any route, given the methods which are registered
+// for its path.
+func (r Router) unrouted(req Request, allowed []string) Response {
+	rsp := NewResponse(req)
+	if len(allowed) > 0 && !r.DisableMethodNotAllowed {
+		txt := fmt.Sprintf("Method %s not allowed for %s", req.Method, req.URL.Path)
+		rsp.Header.Set("Allow", strings.Join(allowed, ", "))
+		rsp.Error = terrors.New(ErrMethodNotAllowed, txt, nil)
+		return rsp
+	}
+	txt := fmt.Sprintf("No handler for %s %s", req.Method, req.URL.Path)
+	rsp.Error = terrors.NotFound("no_handler", txt, nil)
+	return rsp
+}
+
+// trailingSlashAlternative returns the path with its trailing slash added or removed, if trailing slash normalisation
+// is enabled and the alternative is permitted by the canonical form.
+func (r Router) trailingSlashAlternative(path string) (string, bool) {
+	if !r.RedirectTrailingSlash && !r.RewriteTrailingSlash || path == "/" || path == "" {
+		return "", false
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/"), r.CanonicalTrailingSlash != TrailingSlashAlways
+	}
+	return path + "/", r.CanonicalTrailingSlash != TrailingSlashNever
+}
+
+// trailingSlashRedirect returns a response redirecting the request to path, preserving its query string.
+func trailingSlashRedirect(req Request, path string) Response {
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return NewRedirect(req, path, http.StatusMovedPermanently)
+	}
+	return NewRedirect(req, path, http.StatusPermanentRedirect)
+}
+
 // Pattern returns the registered pattern which matches the given request.
 func (r Router) Pattern(req Request) string {
-	_, pattern, _ := r.lookup(req.Method, req.URL.Path, nil)
+	path, _ := routingPath(req.URL)
+	_, pattern, _ := r.lookupServing(req.Method, path, nil)
 	return pattern
 }
 
 // Params returns extracted path parameters, assuming the request has been routed and has captured parameters.
 func (r Router) Params(req Request) map[string]string {
-	_, _, params, _ := r.Lookup(req.Method, req.URL.Path)
+	params := map[string]string{}
+	path, escaped := routingPath(req.URL)
+	r.lookupServing(req.Method, path, params)
+	if escaped {
+		unescapeParams(params)
+	}
 	return params
 }
 
+// routingPath returns the path of the URL as it is matched against patterns. This is the decoded path, except that if
+// a segment contains an escaped slash, it (and any percent sign) stays escaped so that it doesn't split the segment in
+// two; as paths constructed by Path escape slashes in parameters, this lets them route back to the same route. In that
+// case, true is returned, and the parameters extracted from the path must be unescaped with unescapeParams.
+func routingPath(u *url.URL) (string, bool) {
+	if u.RawPath == "" {
+		return u.Path, false // The path is in its default encoding, in which slashes are never escaped
+	}
+	escaped := u.EscapedPath()
+	if !strings.Contains(escaped, "%2F") && !strings.Contains(escaped, "%2f") {
+		return u.Path, false
+	}
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		v, err := url.PathUnescape(segment)
+		if err != nil {
+			return u.Path, false
+		}
+		segments[i] = segmentEscaper.Replace(v)
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// segmentEscaper escapes the characters which routingPath leaves escaped within a segment
+var segmentEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// unescapeParams unescapes the values of parameters extracted from a path returned by routingPath
+func unescapeParams(params map[string]string) {
+	for k, v := range params {
+		if unescaped, err := url.PathUnescape(v); err == nil {
+			params[k] = unescaped
+		}
+	}
+}
+
 // Sugar
 
-// GET is shorthand for Register("GET", pattern, svc).
+// GET is shorthand for Register("GET", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) GET(pattern string, svc Service) { r.Register("GET", pattern, svc) }
+func (r *Router) GET(pattern string, svc Service, filters ...Filter) {
+	r.Register("GET", pattern, svc, filters...)
+}
 
-// CONNECT is shorthand for Register("CONNECT", pattern, svc).
+// CONNECT is shorthand for Register("CONNECT", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) CONNECT(pattern string, svc Service) { r.Register("CONNECT", pattern, svc) }
+func (r *Router) CONNECT(pattern string, svc Service, filters ...Filter) {
+	r.Register("CONNECT", pattern, svc, filters...)
+}
 
-// DELETE is shorthand for Register("DELETE", pattern, svc).
+// DELETE is shorthand for Register("DELETE", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) DELETE(pattern string, svc Service) { r.Register("DELETE", pattern, svc) }
+func (r *Router) DELETE(pattern string, svc Service, filters ...Filter) {
+	r.Register("DELETE", pattern, svc, filters...)
+}
 
-// HEAD is shorthand for Register("HEAD", pattern, svc).
+// HEAD is shorthand for Register("HEAD", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) HEAD(pattern string, svc Service) { r.Register("HEAD", pattern, svc) }
+func (r *Router) HEAD(pattern string, svc Service, filters ...Filter) {
+	r.Register("HEAD", pattern, svc, filters...)
+}
 
-// OPTIONS is shorthand for Register("OPTIONS", pattern, svc).
+// OPTIONS is shorthand for Register("OPTIONS", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) OPTIONS(pattern string, svc Service) { r.Register("OPTIONS", pattern, svc) }
+func (r *Router) OPTIONS(pattern string, svc Service, filters ...Filter) {
+	r.Register("OPTIONS", pattern, svc, filters...)
+}
 
-// PATCH is shorthand for Register("PATCH", pattern, svc).
+// PATCH is shorthand for Register("PATCH", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) PATCH(pattern string, svc Service) { r.Register("PATCH", pattern, svc) }
+func (r *Router) PATCH(pattern string, svc Service, filters ...Filter) {
+	r.Register("PATCH", pattern, svc, filters...)
+}
 
-// POST is shorthand for Register("POST", pattern, svc).
+// POST is shorthand for Register("POST", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) POST(pattern string, svc Service) { r.Register("POST", pattern, svc) }
+func (r *Router) POST(pattern string, svc Service, filters ...Filter) {
+	r.Register("POST", pattern, svc, filters...)
+}
 
-// PUT is shorthand for Register("PUT", pattern, svc).
+// PUT is shorthand for Register("PUT", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) PUT(pattern string, svc Service) { r.Register("PUT", pattern, svc) }
+func (r *Router) PUT(pattern string, svc Service, filters ...Filter) {
+	r.Register("PUT", pattern, svc, filters...)
+}
 
-// TRACE is shorthand for Register("TRACE", pattern, svc).
+// TRACE is shorthand for Register("TRACE", pattern, svc, filters...).
 //
 // Pattern syntax is as described in echo's documentation: https://echo.labstack.com/guide/routing
-func (r *Router) TRACE(pattern string, svc Service) { r.Register("TRACE", pattern, svc) }
+func (r *Router) TRACE(pattern string, svc Service, filters ...Filter) {
+	r.Register("TRACE", pattern, svc, filters...)
+}
+
+// GETNamed is shorthand for RegisterNamed(name, "GET", pattern, svc, filters...).
+func (r *Router) GETNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "GET", pattern, svc, filters...)
+}
+
+// CONNECTNamed is shorthand for RegisterNamed(name, "CONNECT", pattern, svc, filters...).
+func (r *Router) CONNECTNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "CONNECT", pattern, svc, filters...)
+}
+
+// DELETENamed is shorthand for RegisterNamed(name, "DELETE", pattern, svc, filters...).
+func (r *Router) DELETENamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "DELETE", pattern, svc, filters...)
+}
+
+// HEADNamed is shorthand for RegisterNamed(name, "HEAD", pattern, svc, filters...).
+func (r *Router) HEADNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "HEAD", pattern, svc, filters...)
+}
+
+// OPTIONSNamed is shorthand for RegisterNamed(name, "OPTIONS", pattern, svc, filters...).
+func (r *Router) OPTIONSNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "OPTIONS", pattern, svc, filters...)
+}
+
+// PATCHNamed is shorthand for RegisterNamed(name, "PATCH", pattern, svc, filters...).
+func (r *Router) PATCHNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "PATCH", pattern, svc, filters...)
+}
+
+// POSTNamed is shorthand for RegisterNamed(name, "POST", pattern, svc, filters...).
+func (r *Router) POSTNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "POST", pattern, svc, filters...)
+}
+
+// PUTNamed is shorthand for RegisterNamed(name, "PUT", pattern, svc, filters...).
+func (r *Router) PUTNamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "PUT", pattern, svc, filters...)
+}
+
+// TRACENamed is shorthand for RegisterNamed(name, "TRACE", pattern, svc, filters...).
+func (r *Router) TRACENamed(name, pattern string, svc Service, filters ...Filter) {
+	r.RegisterNamed(name, "TRACE", pattern, svc, filters...)
+}
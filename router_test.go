@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 	"testing"
 
+	"github.com/monzo/terrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,6 +37,9 @@ func routerTestHarness() (Router, []routerTestCase) {
 	router.GET("/foo", svc)
 	router.GET("/foo/:param/baz", svc)
 	router.GET("/residual/*", svc)
+	router.GET("/assets/*filepath", svc)
+	router.GET("/assets/favicon.ico", svc)
+	router.GET(`/orders/:id(\d+)`, svc)
 	router.Register("*", "/poly", svc)
 
 	cases := []routerTestCase{
@@ -93,11 +99,49 @@ func routerTestHarness() (Router, []routerTestCase) {
 			params: map[string]string{
 				"*": "r/e/s/i/d/u/a/l/"},
 		},
+		{
+			// Named residual
+			method:  http.MethodGet,
+			path:    "/assets/css/app.css",
+			status:  http.StatusOK,
+			pattern: "/assets/*filepath",
+			params: map[string]string{
+				"filepath": "css/app.css"},
+		},
+		{
+			// Static routes are preferred over residuals
+			method:  http.MethodGet,
+			path:    "/assets/favicon.ico",
+			status:  http.StatusOK,
+			pattern: "/assets/favicon.ico",
+			params:  map[string]string{},
+		},
+		{
+			// Constrained param
+			method:  http.MethodGet,
+			path:    "/orders/123",
+			status:  http.StatusOK,
+			pattern: `/orders/:id(\d+)`,
+			params: map[string]string{
+				"id": "123"},
+		},
+		{
+			// Constraint not satisfied
+			method: http.MethodGet,
+			path:   "/orders/abc",
+			status: http.StatusNotFound,
+		},
 		{
 			// Unknown poly-method
 			method: "WTAF",
 			path:   "/poly",
-			status: http.StatusNotFound,
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			// Path registered for other methods
+			method: http.MethodPost,
+			path:   "/foo",
+			status: http.StatusMethodNotAllowed,
 		}}
 
 	// Add a case per-method for the poly-method route
@@ -155,6 +199,621 @@ func TestRouterForRequest(t *testing.T) {
 	assert.Equal(t, router, *reqRouter)
 }
 
+func TestRequestParams(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	var param string
+	var paramInt int
+	var paramErr error
+	router.GET("/foo/:id", func(req Request) Response {
+		param = req.Param("id")
+		paramInt, paramErr = req.ParamInt("id")
+		return req.Response(nil)
+	})
+	svc := router.Serve()
+	ctx := context.Background()
+
+	svc(NewRequest(ctx, "GET", "/foo/42", nil))
+	assert.Equal(t, "42", param)
+	assert.Equal(t, 42, paramInt)
+	assert.NoError(t, paramErr)
+
+	svc(NewRequest(ctx, "GET", "/foo/bar", nil))
+	assert.Equal(t, "bar", param)
+	require.Error(t, paramErr)
+	assert.True(t, terrors.PrefixMatches(paramErr, terrors.ErrBadRequest))
+
+	// Unknown parameters are empty
+	req := NewRequest(ctx, "GET", "/foo/42", nil)
+	assert.Equal(t, "", req.Param("id"))
+	_, err := req.ParamInt("id")
+	assert.Error(t, err)
+}
+
+func TestRequestRoutePattern(t *testing.T) {
+	t.Parallel()
+
+	var pattern string
+	var ok bool
+	svc := func(req Request) Response {
+		pattern, ok = req.RoutePattern()
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET("/users/:id", svc)
+	router.Group("/orgs").GET("/:org/files/*path", svc)
+	serve := router.Serve()
+	ctx := context.Background()
+
+	serve(NewRequest(ctx, "GET", "/users/42", nil))
+	assert.True(t, ok)
+	assert.Equal(t, "/users/:id", pattern)
+
+	serve(NewRequest(ctx, "HEAD", "/users/42", nil))
+	assert.True(t, ok)
+	assert.Equal(t, "/users/:id", pattern)
+
+	serve(NewRequest(ctx, "GET", "/orgs/monzo/files/a/b.txt", nil))
+	assert.True(t, ok)
+	assert.Equal(t, "/orgs/:org/files/*path", pattern)
+
+	// Without a router, there is no pattern
+	pattern, ok = NewRequest(ctx, "GET", "/users/42", nil).RoutePattern()
+	assert.False(t, ok)
+	assert.Empty(t, pattern)
+	pattern, ok = Request{}.RoutePattern()
+	assert.False(t, ok)
+}
+
+func TestRouterWildcardValidation(t *testing.T) {
+	t.Parallel()
+
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET("/assets/*filepath", svc)
+	router.POST("/assets/*other", svc) // A different method is fine
+	assert.Panics(t, func() { router.GET("/assets/*other", svc) })
+	assert.Panics(t, func() { router.GET("/foo/*bar/baz", svc) })
+	assert.Panics(t, func() { router.GET("/foo*", svc) })
+}
+
+func TestRouterConflictValidation(t *testing.T) {
+	t.Parallel()
+
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET("/users/:id", svc)
+	router.GET("/users/me", svc)        // Static segments take precedence over parameters, so don't conflict
+	router.GET("/users/:id/posts", svc) // Nor do longer patterns
+	router.PUT("/users/:uid", svc)      // Nor patterns for different methods
+	router.GET(`/orders/:id(\d+)`, svc)
+	assert.PanicsWithValue(t, "typhon: route GET /users/:id is already registered", func() {
+		router.GET("/users/:id", svc)
+	})
+	assert.PanicsWithValue(t, "typhon: route GET /users/:uid conflicts with GET /users/:id", func() {
+		router.GET("/users/:uid", svc)
+	})
+	assert.Panics(t, func() { router.GET("/orders/:name([a-z]+)", svc) })
+	assert.Panics(t, func() { router.Register("*", "/users/me", svc) })
+
+	sub := router.Group("/api")
+	sub.GET("/users/:id", svc)
+	assert.Panics(t, func() { sub.GET("/users/:name", svc) })
+}
+
+func TestRouterConstraintValidation(t *testing.T) {
+	t.Parallel()
+
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GETNamed("order", `/orders/:id([0-9]{2}(?:[a-z]+)?)/items`, svc)
+	assert.Panics(t, func() { router.GET(`/foo/:id(\d+`, svc) })
+	assert.Panics(t, func() { router.GET(`/foo/:id([a-z)`, svc) })
+
+	_, pattern, params, ok := router.Lookup("GET", "/orders/12abc/items")
+	require.True(t, ok)
+	assert.Equal(t, `/orders/:id([0-9]{2}(?:[a-z]+)?)/items`, pattern)
+	assert.Equal(t, "12abc", params["id"])
+	_, _, _, ok = router.Lookup("GET", "/orders/123/items")
+	assert.False(t, ok)
+
+	p, err := router.Path("order", "id", "12")
+	require.NoError(t, err)
+	assert.Equal(t, "/orders/12/items", p)
+	_, err = router.Path("order", "id", "x")
+	assert.Error(t, err)
+}
+
+func TestRouterConstraintFallback(t *testing.T) {
+	t.Parallel()
+
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET(`/orders/:id(\d+)`, svc)
+	router.GET(`/orders/:id(\d+)/items`, svc)
+	router.GET("/orders/*rest", svc)
+
+	_, pattern, params, ok := router.Lookup("GET", "/orders/42")
+	require.True(t, ok)
+	assert.Equal(t, `/orders/:id(\d+)`, pattern)
+	assert.Equal(t, map[string]string{"id": "42"}, params)
+
+	// Paths which fail a route's constraints fall through to other routes which match them
+	_, pattern, params, ok = router.Lookup("GET", "/orders/abc")
+	require.True(t, ok)
+	assert.Equal(t, "/orders/*rest", pattern)
+	assert.Equal(t, map[string]string{"rest": "abc"}, params)
+	_, pattern, params, ok = router.Lookup("GET", "/orders/abc/items")
+	require.True(t, ok)
+	assert.Equal(t, "/orders/*rest", pattern)
+	assert.Equal(t, map[string]string{"rest": "abc/items"}, params)
+	_, pattern, params, ok = router.Lookup("GET", "/orders/42/items/1")
+	require.True(t, ok)
+	assert.Equal(t, "/orders/*rest", pattern)
+	assert.Equal(t, map[string]string{"rest": "42/items/1"}, params)
+
+	rsp := router.Serve()(NewRequest(nil, "GET", "/orders/abc", nil))
+	assert.NoError(t, rsp.Error)
+
+	// Falling back doesn't affect later lookups of the route which failed
+	_, pattern, params, ok = router.Lookup("GET", "/orders/42")
+	require.True(t, ok)
+	assert.Equal(t, `/orders/:id(\d+)`, pattern)
+	assert.Equal(t, map[string]string{"id": "42"}, params)
+
+	// ...but if none does, the path still doesn't match
+	other := NewRouter()
+	other.GET(`/orders/:id(\d+)`, svc)
+	other.GET("/orders/:id/items", svc)
+	_, _, _, ok = other.Lookup("GET", "/orders/abc")
+	assert.False(t, ok)
+}
+
+func TestRouterConstraintFallbackConcurrent(t *testing.T) {
+	t.Parallel()
+
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET(`/orders/:id(\d+)`, svc)
+	router.GET("/orders/*rest", svc)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if (i+j)%2 == 0 {
+					_, _, params, ok := router.Lookup("GET", "/orders/42")
+					assert.True(t, ok)
+					assert.Equal(t, map[string]string{"id": "42"}, params)
+				} else {
+					_, _, params, ok := router.Lookup("GET", "/orders/abc")
+					assert.True(t, ok)
+					assert.Equal(t, map[string]string{"rest": "abc"}, params)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router.GET("/foo", svc)
+	router.PUT("/foo", svc)
+	ctx := context.Background()
+
+	rsp := router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "POST", "/foo", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	assert.Equal(t, "GET, PUT", rsp.Header.Get("Allow"))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, ErrMethodNotAllowed))
+
+	router.DisableMethodNotAllowed = true
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "POST", "/foo", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Empty(t, rsp.Header.Get("Allow"))
+}
+
+func TestRouterAutoOptions(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router.GET("/foo", svc)
+	router.PUT("/foo", svc)
+	router.DELETE("/bar", svc)
+	router.GET("/custom", svc)
+	router.OPTIONS("/custom", func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Header.Set("Allow", "custom")
+		return rsp
+	})
+	ctx := context.Background()
+
+	rsp := router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "OPTIONS", "/foo", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Equal(t, "GET, OPTIONS, PUT", rsp.Header.Get("Allow"))
+
+	req := NewRequest(ctx, "OPTIONS", "/", nil)
+	req.URL.Path = "*"
+	rsp = router.Serve().Filter(ErrorFilter)(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Equal(t, "DELETE, GET, OPTIONS, PUT", rsp.Header.Get("Allow"))
+
+	// An explicit handler takes precedence
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "OPTIONS", "/custom", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "custom", rsp.Header.Get("Allow"))
+
+	// Paths with no routes are still not found
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "OPTIONS", "/baz", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+	router.DisableAutoOptions = true
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "OPTIONS", "/foo", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	assert.Equal(t, "GET, PUT", rsp.Header.Get("Allow"))
+}
+
+func TestRouterAutoHead(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	var method string
+	router.GET("/foo/:id", func(req Request) Response {
+		method = req.Method
+		rsp := req.Response(map[string]string{"id": router.Params(req)["id"]})
+		rsp.Header.Set("X-Foo", "1")
+		return rsp
+	})
+	router.GET("/stream", func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			s.Write([]byte("a"))
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	router.GET("/custom", func(req Request) Response {
+		return NewResponse(req)
+	})
+	router.HEAD("/custom", func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Header.Set("X-Custom", "1")
+		return rsp
+	})
+	ctx := context.Background()
+
+	get := router.Serve()(NewRequest(ctx, "GET", "/foo/1", nil))
+	require.NoError(t, get.Error)
+	getBody, err := get.BodyBytes(true)
+	require.NoError(t, err)
+
+	rsp := router.Serve()(NewRequest(ctx, "HEAD", "/foo/1", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "HEAD", method)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("X-Foo"))
+	assert.Equal(t, strconv.Itoa(len(getBody)), rsp.Header.Get("Content-Length"))
+	assert.Equal(t, int64(len(getBody)), rsp.ContentLength)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Empty(t, b)
+	assert.Equal(t, "/foo/:id", router.Pattern(NewRequest(ctx, "HEAD", "/foo/1", nil)))
+
+	rsp = router.Serve()(NewRequest(ctx, "HEAD", "/stream", nil))
+	require.NoError(t, rsp.Error)
+	assert.Empty(t, rsp.Header.Get("Content-Length"))
+
+	rsp = router.Serve()(NewRequest(ctx, "HEAD", "/custom", nil))
+	assert.Equal(t, "1", rsp.Header.Get("X-Custom"))
+
+	// Content-Length reaches the client, although there's no body
+	ts, err := NewTestServer(router.Serve())
+	require.NoError(t, err)
+	defer ts.Close()
+	rsp = ts.Client(NewRequest(ctx, "HEAD", "/foo/1", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, int64(len(getBody)), rsp.ContentLength)
+	assert.Equal(t, "1", rsp.Header.Get("X-Foo"))
+
+	router.DisableAutoHead = true
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "HEAD", "/foo/1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+}
+
+func TestRouterCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	router.CaseInsensitive = true
+	router.RedirectTrailingSlash = true
+	svc := func(req Request) Response {
+		return req.Response(router.Params(req))
+	}
+	router.GET("/users/:id/posts/:slug([A-Za-z-]+)", svc)
+	router.GET("/files/*path", svc)
+	router.GET("/v:version/status/", svc)
+	router.GET("/Teams/:teamID/Members", svc)
+	ctx := context.Background()
+
+	params := func(path string) map[string]string {
+		req := NewRequest(ctx, "GET", path, nil)
+		rsp := router.Serve().Filter(ErrorFilter)(req)
+		require.NoError(t, rsp.Error, path)
+		p := map[string]string{}
+		require.NoError(t, rsp.Decode(&p))
+		return p
+	}
+	assert.Equal(t, map[string]string{"id": "AbC", "slug": "Hello-World"}, params("/Users/AbC/POSTS/Hello-World"))
+	assert.Equal(t, map[string]string{"path": "Docs/README.md"}, params("/FILES/Docs/README.md"))
+	assert.Equal(t, map[string]string{"version": "2B"}, params("/V2B/Status/"))
+	// Patterns needn't be registered in lower case
+	assert.Equal(t, map[string]string{"teamID": "T1"}, params("/teams/T1/members"))
+	assert.Equal(t, map[string]string{"teamID": "T1"}, params("/TEAMS/T1/Members"))
+
+	_, _, p, ok := router.Lookup("GET", "/USERS/1/posts/x")
+	assert.True(t, ok)
+	assert.Equal(t, "1", p["id"])
+
+	// Constraints are checked against the original value
+	_, _, _, ok = router.Lookup("GET", "/users/1/posts/x1")
+	assert.False(t, ok)
+
+	// Trailing slash redirects keep the requested case
+	rsp := router.Serve()(NewRequest(ctx, "GET", "/V2/Status?q=A", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	assert.Equal(t, "/V2/Status/?q=A", rsp.Header.Get("Location"))
+
+	router.CaseInsensitive = false
+	_, _, _, ok = router.Lookup("GET", "/Users/1/posts/x")
+	assert.False(t, ok)
+}
+
+func TestRouterPath(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router.GETNamed("user", "/users/:id", svc)
+	router.PUTNamed("user", "/users/:id", svc)
+	router.GETNamed("user_file", "/users/:id/files/*path", svc)
+	assert.Panics(t, func() { router.GETNamed("user", "/people/:id", svc) })
+
+	p, err := router.Path("user", "id", "42")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/42", p)
+
+	p, err = router.Path("user", "id", "a b/c")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/a%20b%2Fc", p)
+
+	// Escaped slashes in parameters survive the round trip through a request
+	paramsOf := func(method, path string) map[string]string {
+		req := NewRequest(nil, method, path, nil)
+		assert.Equal(t, "/users/:id", router.Pattern(req), path)
+		return router.Params(req)
+	}
+	assert.Equal(t, map[string]string{"id": "a b/c"}, paramsOf("GET", p))
+	p, err = router.Path("user", "id", "50%/off")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/50%25%2Foff", p)
+	assert.Equal(t, map[string]string{"id": "50%/off"}, paramsOf("PUT", p))
+	assert.Equal(t, map[string]string{"id": "50%"}, paramsOf("GET", "/users/50%25"))
+	rsp := router.Serve()(NewRequest(nil, "GET", p, nil))
+	require.NoError(t, rsp.Error)
+	req := NewRequest(nil, "GET", "/users/4%2F2/files/a%2Fb/c", nil)
+	assert.Equal(t, map[string]string{"id": "4/2", "path": "a/b/c"}, router.Params(req))
+
+	p, err = router.Path("user_file", "id", "42", "path", "docs/a b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/42/files/docs/a%20b.txt", p)
+
+	// The generated path should route back to the same pattern
+	_, pattern, params, ok := router.Lookup("GET", "/users/42/files/docs/a%20b.txt")
+	require.True(t, ok)
+	assert.Equal(t, "/users/:id/files/*path", pattern)
+	assert.Equal(t, "42", params["id"])
+
+	_, err = router.Path("nonexistent")
+	assert.Error(t, err)
+	_, err = router.Path("user")
+	assert.Error(t, err)
+	_, err = router.Path("user", "id")
+	assert.Error(t, err)
+	_, err = router.Path("user", "id", "42", "foo", "bar")
+	assert.Error(t, err)
+
+	routes := router.Routes()
+	require.Len(t, routes, 3)
+	assert.Equal(t, RouteInfo{Method: "GET", Pattern: "/users/:id", Name: "user"}, routes[0])
+}
+
+func TestRouterGroup(t *testing.T) {
+	t.Parallel()
+
+	order := []string{}
+	filter := func(name string) Filter {
+		return func(req Request, svc Service) Response {
+			order = append(order, name)
+			return svc(req)
+		}
+	}
+
+	router := NewRouter()
+	admin := router.Group("/admin", filter("a1"), filter("a2"))
+	users := admin.Group("/users/", filter("u"))
+	users.GETNamed("user", "/:id", func(req Request) Response {
+		order = append(order, "svc")
+		return req.Response(nil)
+	})
+	router.GET("/public", func(req Request) Response {
+		order = append(order, "svc")
+		return req.Response(nil)
+	})
+
+	ctx := context.Background()
+	rsp := router.Serve()(NewRequest(ctx, "GET", "/admin/users/42", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"a1", "a2", "u", "svc"}, order)
+
+	order = order[:0]
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/public", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"svc"}, order)
+
+	p, err := router.Path("user", "id", "42")
+	require.NoError(t, err)
+	assert.Equal(t, "/admin/users/42", p)
+}
+
+func TestRouterRouteFilters(t *testing.T) {
+	t.Parallel()
+
+	order := []string{}
+	filter := func(name string) Filter {
+		return func(req Request, svc Service) Response {
+			order = append(order, name)
+			return svc(req)
+		}
+	}
+	svc := func(req Request) Response {
+		order = append(order, "svc")
+		return req.Response(nil)
+	}
+
+	router := NewRouter()
+	api := router.Group("/api", filter("g"))
+	api.GET("/limited", svc, filter("r1"), filter("r2"))
+	api.POSTNamed("create", "/limited", svc, filter("p"))
+	api.GET("/open", svc)
+	router.Register("*", "/any", svc, filter("any"))
+	serve := router.Serve().Filter(filter("router"))
+
+	ctx := context.Background()
+	rsp := serve(NewRequest(ctx, "GET", "/api/limited", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"router", "g", "r1", "r2", "svc"}, order)
+
+	order = order[:0]
+	rsp = serve(NewRequest(ctx, "POST", "/api/limited", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"router", "g", "p", "svc"}, order)
+
+	order = order[:0]
+	rsp = serve(NewRequest(ctx, "GET", "/api/open", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"router", "g", "svc"}, order)
+
+	order = order[:0]
+	rsp = serve(NewRequest(ctx, "PUT", "/any", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, []string{"router", "any", "svc"}, order)
+}
+
+func TestRouterTrailingSlash(t *testing.T) {
+	t.Parallel()
+
+	var served string
+	svc := func(req Request) Response {
+		served = req.URL.Path
+		return req.Response(nil)
+	}
+	router := NewRouter()
+	router.GET("/users", svc)
+	router.POST("/users", svc)
+	router.GET("/groups/", svc)
+	router.POST("/exact/", svc)
+	router.GET("/exact", svc)
+	ctx := context.Background()
+
+	// Disabled by default
+	rsp := router.Serve()(NewRequest(ctx, "GET", "/users/", nil))
+	assert.Error(t, rsp.Error)
+
+	router.RedirectTrailingSlash = true
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/users/?a=b", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	assert.Equal(t, "/users?a=b", rsp.Header.Get("Location"))
+	rsp = router.Serve()(NewRequest(ctx, "POST", "/users/", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rsp.StatusCode)
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/groups", nil))
+	assert.Equal(t, "/groups/", rsp.Header.Get("Location"))
+
+	// A path which is registered as-is (albeit for another method) isn't redirected
+	rsp = router.Serve().Filter(ErrorFilter)(NewRequest(ctx, "GET", "/exact/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+
+	// Canonical forms restrict the direction of normalisation
+	router.CanonicalTrailingSlash = TrailingSlashNever
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/groups", nil))
+	assert.Error(t, rsp.Error)
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/users/", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	router.CanonicalTrailingSlash = TrailingSlashAlways
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/users/", nil))
+	assert.Error(t, rsp.Error)
+
+	// Rewriting dispatches directly
+	router.CanonicalTrailingSlash = TrailingSlashEither
+	router.RedirectTrailingSlash = false
+	router.RewriteTrailingSlash = true
+	rsp = router.Serve()(NewRequest(ctx, "GET", "/users/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "/users", served)
+}
+
+func TestRouterRoutes(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter()
+	svc := func(req Request) Response {
+		return req.Response(nil)
+	}
+	router.POST("/foo", svc)
+	router.GET("/foo/:param", svc)
+	router.GET("/foo", svc)
+	router.Register("*", "/bar", svc)
+
+	expected := []RouteInfo{}
+	for _, m := range [...]string{"CONNECT", "DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE"} {
+		expected = append(expected, RouteInfo{Method: m, Pattern: "/bar"})
+	}
+	expected = append(expected,
+		RouteInfo{Method: "GET", Pattern: "/foo"},
+		RouteInfo{Method: "POST", Pattern: "/foo"},
+		RouteInfo{Method: "GET", Pattern: "/foo/:param"})
+	assert.Equal(t, expected, router.Routes())
+}
+
 func BenchmarkRouter(b *testing.B) {
 	router, cases := routerTestHarness()
 
@@ -1,54 +1,198 @@
 package typhon
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/facebookgo/httpdown"
 	log "github.com/monzo/slog"
+	"github.com/monzo/terrors"
 )
 
 const DefaultListenAddr = "127.0.0.1:0"
 
+const (
+	// DefaultStopTimeout is how long Stop waits for in-flight requests to complete before closing their connections
+	DefaultStopTimeout = 20 * time.Second
+)
+
 type Server interface {
 	httpdown.Server
 	Listener() net.Listener
 	WaitC() <-chan struct{}
+	// Shutdown stops the server from accepting new connections and waits for in-flight requests (including streaming
+	// responses) to complete. If ctx is done first, remaining connections are closed forcibly: this cancels their
+	// requests' contexts and fails further writes to their responses. The context's error is returned in that case.
+	Shutdown(ctx context.Context) error
+	// Drain marks the server as draining ahead of a shutdown, making HealthCheckService (and HealthService readiness)
+	// fail so that load balancers stop routing traffic to it. Keep-alives are disabled so clients move their
+	// connections elsewhere. The server continues to serve requests.
+	Drain()
+	// Draining returns whether Drain has been called.
+	Draining() bool
 }
 
 type server struct {
-	httpdown.Server
-	l net.Listener
+	srv        *http.Server
+	l          net.Listener
+	companions []Server // shut down along with this server
+	draining   int32
+	done       chan struct{}
+	doneOnce   sync.Once
+	err        error
 }
 
-func (s server) Listener() net.Listener {
+func (s *server) Listener() net.Listener {
 	return s.l
 }
 
-func (s server) WaitC() <-chan struct{} {
-	c := make(chan struct{}, 0)
-	go func() {
-		s.Server.Wait()
-		close(c)
-	}()
-	return c
+func (s *server) WaitC() <-chan struct{} {
+	return s.done
+}
+
+func (s *server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func (s *server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil && err != ctx.Err() {
+		return err
+	}
+	return nil
+}
+
+func (s *server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		log.Warn(nil, "Timed out waiting for requests to complete; closing connections: %v", err)
+		s.srv.Close()
+	}
+	for _, c := range s.companions {
+		c.Shutdown(ctx)
+	}
+	s.finish(nil)
+	return err
+}
+
+func (s *server) Drain() {
+	if atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		log.Info(nil, "Draining server on %v", s.l.Addr())
+		s.srv.SetKeepAlivesEnabled(false)
+	}
+	for _, c := range s.companions {
+		c.Drain()
+	}
+}
+
+func (s *server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *server) finish(err error) {
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+type serverContextKeyType struct{}
+
+var serverContextKey = serverContextKeyType{}
+
+// IsDraining returns whether the Server which received the request to which ctx belongs is draining.
+func IsDraining(ctx context.Context) bool {
+	s, ok := ctx.Value(serverContextKey).(Server)
+	return ok && s.Draining()
+}
+
+// HealthCheckService is a Service suitable for load balancer health checks. It responds with 200 OK, or with a 503
+// Service Unavailable error once the Server is draining.
+func HealthCheckService(req Request) Response {
+	if IsDraining(req) {
+		rsp := NewResponse(req)
+		rsp.Error = terrors.New(ErrServiceUnavailable+".draining", "Server is draining", nil)
+		rsp.StatusCode = http.StatusServiceUnavailable
+		return rsp
+	}
+	return req.Response(map[string]string{
+		"status": "ok"})
 }
 
 func Serve(svc Service, l net.Listener) (Server, error) {
-	downer := &httpdown.HTTP{
-		StopTimeout: 20 * time.Second,
-		KillTimeout: 25 * time.Second}
-	downerServer := downer.Serve(HttpServer(svc), l)
+	return serve(HttpServer(svc), l)
+}
+
+// ServeWithConfig is like Serve, but with the given server timeouts and limits (see ServerConfig).
+func ServeWithConfig(svc Service, l net.Listener, cfg ServerConfig) (Server, error) {
+	return serve(HttpServerWithConfig(svc, cfg), l)
+}
+
+// ServeH2C is like Serve, but also serves cleartext HTTP/2 (see H2CServer).
+func ServeH2C(svc Service, l net.Listener) (Server, error) {
+	return serve(H2CServer(svc), l)
+}
+
+func serve(srv *http.Server, l net.Listener) (Server, error) {
+	s := &server{
+		srv:  srv,
+		l:    l,
+		done: make(chan struct{})}
+	s.srv.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), serverContextKey, s)
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(l, "", "")
+		} else {
+			err = srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			log.Error(nil, "Error serving on %v: %v", l.Addr(), err)
+			s.finish(err)
+		}
+	}()
 	log.Info(nil, "Listening on %v", l.Addr())
-	return server{
-		Server: downerServer,
-		l:      l}, nil
+	return s, nil
 }
 
 func Listen(svc Service, addr string) (Server, error) {
+	return ListenNetwork(svc, "tcp", addr)
+}
+
+// ListenNetwork is like Listen, but listens on the given network: either "tcp" (or "tcp4"/"tcp6"), or "unix", in which
+// case addr is the path of the socket file. A stale socket file left behind by a previous process is removed, but not
+// one which is in use or which isn't a socket. The socket file is made accessible to its owner and group only, and is
+// removed when the server stops.
+func ListenNetwork(svc Service, network, addr string) (Server, error) {
+	var l net.Listener
+	var err error
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		l, err = listenTCP(network, addr)
+	case "unix":
+		l, err = listenUnix(addr)
+	default:
+		err = terrors.InternalService("unsupported_network", "Unsupported network", map[string]string{
+			"network": network})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Serve(svc, l)
+}
+
+func listenTCP(network, addr string) (net.Listener, error) {
 	// Determine on which address to listen, choosing in order one of:
 	// 1. The passed addr
 	// 2. PORT variable (listening on all interfaces)
@@ -62,14 +206,48 @@ func Listen(svc Service, addr string) (Server, error) {
 			addr = ":0"
 		}
 	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	l, err := net.ListenTCP("tcp", tcpAddr)
+	l, err := net.ListenTCP(network, tcpAddr)
 	if err != nil {
 		return nil, err
 	}
-	return Serve(svc, l)
+	return l, nil
+}
+
+const unixSocketMode = 0660
+
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, terrors.InternalService("not_socket", "File exists and is not a socket", map[string]string{
+				"path": path})
+		}
+		// Only remove the socket if nothing is listening on it
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, terrors.InternalService("socket_in_use", "Socket is in use", map[string]string{
+				"path": path})
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, terrors.Wrap(err, map[string]string{
+				"path": path})
+		}
+	}
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: path,
+		Net:  "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		l.Close()
+		return nil, terrors.Wrap(err, map[string]string{
+			"path": path})
+	}
+	return l, nil
 }
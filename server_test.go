@@ -0,0 +1,362 @@
+package typhon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestServerShutdownWaitsForInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		close(started)
+		<-release
+		return req.Response("done")
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+
+	f := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil).Send()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case <-s.WaitC():
+		t.Fatal("server stopped with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	rsp := f.Response()
+	require.NoError(t, rsp.Error)
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "\"done\"\n", string(b))
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, s.Wait())
+}
+
+func TestServerShutdownForceCloses(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for {
+				if _, err := s.Write([]byte("tick")); err != nil {
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+		rsp := req.Response(nil)
+		rsp.Body = s
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil).Send().Response()
+	require.NoError(t, rsp.Error)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	_, err = ioutil.ReadAll(rsp.Body)
+	assert.Error(t, err)
+}
+
+func TestServerDrain(t *testing.T) {
+	s, err := Listen(HealthCheckService, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	url := fmt.Sprintf("http://%s/healthz", s.Listener().Addr())
+
+	rsp := NewRequest(nil, "GET", url, nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.False(t, s.Draining())
+
+	s.Drain()
+	assert.True(t, s.Draining())
+	rsp = NewRequest(nil, "GET", url, nil).Send().Response()
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.True(t, rsp.Close)
+}
+
+func TestServeH2C(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(s, "%s %d\n", req.Proto, i)
+			}
+		}()
+		rsp := req.Response(nil)
+		rsp.Body = s
+		return rsp
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := ServeH2C(svc, l)
+	require.NoError(t, err)
+	defer s.Stop()
+	url := fmt.Sprintf("http://%s/", l.Addr())
+
+	// HTTP/2 with prior knowledge
+	h2 := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}}
+	defer h2.CloseIdleConnections()
+	rsp := NewRequest(nil, "GET", url, nil).SendVia(HttpService(h2)).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, 2, rsp.ProtoMajor)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0 0\nHTTP/2.0 1\nHTTP/2.0 2\n", string(b))
+
+	// Plain HTTP/1.1 continues to work
+	rsp = NewRequest(nil, "GET", url, nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, 1, rsp.ProtoMajor)
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 0\nHTTP/1.1 1\nHTTP/1.1 2\n", string(b))
+}
+
+func TestHttpServerDefaults(t *testing.T) {
+	t.Parallel()
+	// Reading requests and writing responses isn't bounded unless asked for, so long-running handlers keep working
+	srv := HttpServer(func(req Request) Response {
+		return NewResponse(req)
+	})
+	assert.Equal(t, 10*time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(t, 2*time.Minute, srv.IdleTimeout)
+	assert.Zero(t, srv.ReadTimeout)
+	assert.Zero(t, srv.WriteTimeout)
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.WriteTimeout = 100 * time.Millisecond
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+			return req.Response("too late")
+		}
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				fmt.Fprintf(s, "%d", i)
+			}
+		}()
+		rsp := req.Response(nil)
+		rsp.Body = s
+		return rsp
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := ServeWithConfig(svc, l, cfg)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// A stream which outlasts the WriteTimeout is not cut off, as each chunk is written promptly
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/stream", l.Addr()), nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "01234", string(b))
+
+	// But a buffered response which isn't written in time is
+	rsp = NewRequest(nil, "GET", fmt.Sprintf("http://%s/slow", l.Addr()), nil).SendVia(BareClient).Response()
+	assert.Error(t, rsp.Error)
+}
+
+// closeNotifyingBody is a non-streaming response body which signals when it is closed
+type closeNotifyingBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b *closeNotifyingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestServerBodyWriteTimeout(t *testing.T) {
+	logs := captureLogs(t)
+	cfg := DefaultServerConfig()
+	cfg.WriteTimeout = 0
+	cfg.BodyWriteTimeout = 100 * time.Millisecond
+	body := &closeNotifyingBody{
+		Reader: bytes.NewReader(make([]byte, 64<<20)),
+		closed: make(chan struct{})}
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/large":
+			rsp := req.Response(nil)
+			rsp.Body = body
+			return rsp
+		case "/stream":
+			s := Streamer()
+			go func() {
+				defer s.Close()
+				s.Write([]byte("streamed"))
+			}()
+			rsp := req.Response(nil)
+			rsp.Body = s
+			return rsp
+		}
+		return req.Response(nil)
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := ServeWithConfig(svc, l, cfg)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// A client which never reads its response is cut off
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("GET /large HTTP/1.1\r\nHost: test\r\n\r\n"))
+	require.NoError(t, err)
+	select {
+	case <-body.closed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "response body was not abandoned")
+	}
+	logs.Lock() // the error is logged before the body is closed
+	found := false
+	for _, ev := range logs.events {
+		if strings.HasPrefix(ev.Message, "Error copying response body") {
+			found = true
+			assert.Equal(t, slog.InfoSeverity, ev.Severity)
+		}
+	}
+	logs.Unlock()
+	assert.True(t, found)
+
+	// The deadline doesn't carry over to later (streaming) responses on a kept-alive connection
+	rt := NewRoundTripper(DefaultClientConfig())
+	defer rt.(*http.Transport).CloseIdleConnections()
+	client := HttpService(rt)
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/small", l.Addr()), nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	_, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	time.Sleep(2 * cfg.BodyWriteTimeout)
+	rsp = NewRequest(nil, "GET", fmt.Sprintf("http://%s/stream", l.Addr()), nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(b))
+}
+
+func TestServerReadHeaderTimeout(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.ReadHeaderTimeout = 50 * time.Millisecond
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := ServeWithConfig(HealthCheckService, l, cfg)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n")) // headers never completed
+	require.NoError(t, err)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	start := time.Now()
+	_, err = ioutil.ReadAll(c)
+	assert.NoError(t, err) // the server closed the connection
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	t.Parallel()
+	big := strings.Repeat("x", 2<<20)
+	serve := func(cfg ServerConfig) string {
+		l, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		s, err := ServeWithConfig(HealthCheckService, l, cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Stop() })
+		return fmt.Sprintf("http://%s/", l.Addr())
+	}
+
+	// By default, http.DefaultMaxHeaderBytes applies
+	req := NewRequest(nil, "GET", serve(DefaultServerConfig()), nil)
+	req.Header.Set("X-Big", big)
+	rsp := req.SendVia(BareClient).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rsp.StatusCode)
+	rsp.Body.Close()
+
+	cfg := DefaultServerConfig()
+	cfg.MaxHeaderBytes = 4 << 20
+	req = NewRequest(nil, "GET", serve(cfg), nil)
+	req.Header.Set("X-Big", big)
+	rsp = req.SendVia(BareClient).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "typhon-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "typhon.sock")
+
+	// A stale socket is removed
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	s, err := ListenNetwork(HealthCheckService, "unix", path)
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	rsp := NewRequest(nil, "GET", "http://typhon/", nil).SendVia(UnixSocketService(path)).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// One which is in use isn't
+	_, err = ListenNetwork(HealthCheckService, "unix", path)
+	assert.Error(t, err)
+
+	require.NoError(t, s.Stop())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Nor is a file which isn't a socket
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+	_, err = ListenNetwork(HealthCheckService, "unix", path)
+	assert.Error(t, err)
+}
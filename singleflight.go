@@ -0,0 +1,190 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// DefaultSingleFlightTimeout bounds how long a shared request may take, if SingleFlightOptions doesn't say otherwise
+const DefaultSingleFlightTimeout = 30 * time.Second
+
+// SingleFlightOptions configures SingleFlightFilterWithOptions
+type SingleFlightOptions struct {
+	// Timeout bounds each shared request, unless the first request's context has an earlier deadline. If zero,
+	// DefaultSingleFlightTimeout is used.
+	Timeout time.Duration
+}
+
+// SingleFlightFilter returns a Filter which ensures that only one request per key (as returned by keyFn) is passed to
+// the service at a time. Requests arriving while one with the same key is in flight wait for its response, and each
+// receives a copy of it. Requests for which keyFn returns "" are passed through as normal.
+//
+// The shared request is sent with the context of the first, but without its cancellation, so a caller which gives up
+// waiting doesn't affect the others. It keeps the first request's deadline, and is bounded by
+// DefaultSingleFlightTimeout in any case, so that a service which hangs can't hold up later callers for the key
+// forever. Streaming responses can't be shared: only the first caller receives one, and the rest send their own
+// requests. As only the first request's body is sent, this is intended for safe requests.
+func SingleFlightFilter(keyFn func(Request) string) Filter {
+	return SingleFlightFilterWithOptions(keyFn, SingleFlightOptions{})
+}
+
+// SingleFlightFilterWithOptions is like SingleFlightFilter, but with the given options
+func SingleFlightFilterWithOptions(keyFn func(Request) string, opts SingleFlightOptions) Filter {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultSingleFlightTimeout
+	}
+	flights := &flightGroup{}
+	return func(req Request, svc Service) Response {
+		key := keyFn(req)
+		if key == "" {
+			return svc(req)
+		}
+		f, leader := flights.join(key)
+		if leader {
+			parent := context.Context(context.Background())
+			deadline := time.Now().Add(opts.Timeout)
+			if req.Context != nil {
+				parent = context.WithoutCancel(req.Context)
+				if d, ok := req.Deadline(); ok && d.Before(deadline) {
+					deadline = d
+				}
+			}
+			ctx, cancel := context.WithDeadline(parent, deadline)
+			shared := req
+			shared.Context = ctx
+			go func() {
+				result := &sharedResponse{}
+				defer func() {
+					if v := recover(); v != nil {
+						cancel()
+						slog.Error(shared, "Recovered from panic in %v: %v\n%s", shared, v, debug.Stack())
+						result = &sharedResponse{
+							rsp: Response{
+								Error: terrors.InternalService("panic", fmt.Sprintf("Panic serving request: %v", v), nil)}}
+					}
+					flights.finish(key, f, result)
+				}()
+				result.rsp = svc(shared)
+				result.buffer()
+				if result.streaming {
+					releaseWithBody(ctx, result.rsp, cancel)
+				} else {
+					cancel()
+				}
+			}()
+		}
+
+		var done <-chan struct{}
+		if req.Context != nil {
+			done = req.Done()
+		}
+		select {
+		case <-f.done:
+		case <-done:
+			if leader {
+				// Nobody else may take a streaming response, so it must not be left open
+				go func() {
+					<-f.done
+					if result := f.val.(*sharedResponse); result.streaming {
+						result.rsp.Body.Close()
+					}
+				}()
+			}
+			return Response{
+				Error: terrors.Wrap(req.Err(), nil)}
+		}
+		result := f.val.(*sharedResponse)
+		switch {
+		case !result.streaming:
+			return result.copyFor(req)
+		case leader:
+			return result.rsp
+		default:
+			return svc(req)
+		}
+	}
+}
+
+// sharedResponse is the result of a request shared by SingleFlightFilter
+type sharedResponse struct {
+	rsp       Response
+	body      []byte
+	streaming bool
+}
+
+// buffer reads the response body into memory so it can be shared, unless the response is streaming
+func (r *sharedResponse) buffer() {
+	if r.rsp.Response == nil || r.rsp.Body == nil {
+		return
+	}
+	if isStreamingRsp(r.rsp) {
+		r.streaming = true
+		return
+	}
+	b, err := r.rsp.BodyBytes(true)
+	if err != nil {
+		r.rsp = Response{
+			Error: terrors.Wrap(err, nil)}
+		return
+	}
+	r.body = b
+}
+
+// copyFor returns a copy of the (non-streaming) shared response, as a response to req
+func (r *sharedResponse) copyFor(req Request) Response {
+	if r.rsp.Response == nil {
+		return Response{
+			Request: &req,
+			Error:   r.rsp.Error}
+	}
+	rsp := NewResponse(req)
+	rsp.Error = r.rsp.Error
+	rsp.StatusCode = r.rsp.StatusCode
+	rsp.Header = cloneHeader(r.rsp.Header)
+	rsp.ContentLength = r.rsp.ContentLength
+	rsp.Write(r.body)
+	return rsp
+}
+
+// flightGroup tracks calls in flight by key, so that concurrent callers for the same key can share the work of one
+type flightGroup struct {
+	mtx     sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done chan struct{} // closed once val has been set
+	val  interface{}
+}
+
+// join returns the flight in progress for key, or starts one (in which case leader is true, and the caller MUST call
+// finish once done)
+func (g *flightGroup) join(key string) (f *flight, leader bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f = &flight{
+		done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// finish completes the flight for key with the given value, releasing anyone waiting on it
+func (g *flightGroup) finish(key string, f *flight, val interface{}) {
+	g.mtx.Lock()
+	delete(g.flights, key)
+	g.mtx.Unlock()
+	f.val = val
+	close(f.done)
+}
@@ -0,0 +1,136 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlightFilter(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-req.Done():
+			return Response{
+				Error: req.Err()}
+		}
+		rsp := req.Response("ok")
+		rsp.Header.Set("X-Shared", "yes")
+		return rsp
+	}).Filter(SingleFlightFilter(func(req Request) string {
+		return req.URL.Path
+	}))
+
+	// The first caller gives up, which doesn't affect the others
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan Response)
+	go func() {
+		first <- svc(NewRequest(ctx, "GET", "/a", nil))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/a", nil))
+			assert.NoError(t, rsp.Error)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Equal(t, "yes", rsp.Header.Get("X-Shared"))
+			body := ""
+			assert.NoError(t, rsp.Decode(&body))
+			assert.Equal(t, "ok", body)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Error(t, (<-first).Error)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Once complete, the next request is sent afresh
+	require.NoError(t, svc(NewRequest(context.Background(), "GET", "/a", nil)).Error)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSingleFlightFilterStreaming(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		s := Streamer()
+		go func() {
+			s.Write([]byte("streamed"))
+			s.Close()
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	}).Filter(SingleFlightFilter(func(req Request) string {
+		return req.URL.Path
+	}))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/stream", nil))
+			b, err := rsp.BodyBytes(true)
+			assert.NoError(t, err)
+			assert.Equal(t, "streamed", string(b))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// Each follower sent its own request
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestSingleFlightFilterDeadline(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		<-req.Done() // hangs until the shared request's deadline
+		return Response{
+			Error: req.Err()}
+	}).Filter(SingleFlightFilterWithOptions(func(req Request) string {
+		return req.URL.Path
+	}, SingleFlightOptions{
+		Timeout: 20 * time.Millisecond}))
+
+	// Without a deadline (or even a context) of its own, the shared request is bounded by the timeout...
+	start := time.Now()
+	rsp := svc(Request{
+		Request: NewRequest(nil, "GET", "/a", nil).Request})
+	assert.Error(t, rsp.Error)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// ...and otherwise the leader's deadline is kept, but not its cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var deadline time.Time
+	svc = Service(func(req Request) Response {
+		deadline, _ = req.Deadline()
+		return NewResponse(req)
+	}).Filter(SingleFlightFilter(func(req Request) string {
+		return req.URL.Path
+	}))
+	require.NoError(t, svc(NewRequest(ctx, "GET", "/b", nil)).Error)
+	expected, _ := ctx.Deadline()
+	assert.Equal(t, expected, deadline)
+}
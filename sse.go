@@ -0,0 +1,127 @@
+package typhon
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// An SSEEvent is a server-sent event. Only Data is required.
+type SSEEvent struct {
+	// ID sets the client's last event ID, which it sends in the Last-Event-ID header when reconnecting
+	ID string
+	// Event is the event's type; if empty, clients treat it as "message"
+	Event string
+	Data  string
+	// Retry, if positive, sets how long the client waits before reconnecting if the connection is lost
+	Retry time.Duration
+}
+
+// An SSEStreamer sends server-sent events to a client. It is safe for concurrent use.
+type SSEStreamer struct {
+	m      sync.Mutex
+	w      io.WriteCloser
+	done   chan struct{}
+	closer sync.Once
+}
+
+// SSE turns the response into a stream of server-sent events (in the text/event-stream format), returning an
+// SSEStreamer through which events are sent. The stream ends when the streamer is closed, or when the request's
+// context is done. If heartbeat is positive, a comment is sent whenever that long passes without an event, to stop
+// proxies closing the connection for inactivity.
+func (r *Response) SSE(heartbeat time.Duration) *SSEStreamer {
+	body := Streamer()
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", "text/event-stream")
+	r.Header.Set("Cache-Control", "no-cache")
+	r.Header.Set("X-Accel-Buffering", "no") // stop nginx buffering the stream
+	r.Header.Del("Content-Length")
+
+	s := &SSEStreamer{
+		w:    body,
+		done: make(chan struct{})}
+	var ctxDone <-chan struct{}
+	if r.Request != nil && r.Request.Context != nil {
+		ctxDone = r.Request.Done()
+	}
+	go s.run(ctxDone, heartbeat)
+	return s
+}
+
+func (s *SSEStreamer) run(ctxDone <-chan struct{}, heartbeat time.Duration) {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctxDone:
+			s.Close()
+			return
+		case <-s.done:
+			return
+		case <-tick:
+			s.write([]byte(":\n\n"))
+		}
+	}
+}
+
+// Send writes an event to the stream. It returns an error if the stream has ended, or if the event's ID or type
+// contain newlines.
+func (s *SSEStreamer) Send(ev SSEEvent) error {
+	if strings.ContainsAny(ev.ID, "\r\n") || strings.ContainsAny(ev.Event, "\r\n") {
+		return terrors.InternalService("invalid_event", "Event ID and type must not contain newlines", nil)
+	}
+	buf := &bytes.Buffer{}
+	if ev.ID != "" {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		buf.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(int64(ev.Retry/time.Millisecond), 10) + "\n")
+	}
+	data := strings.Replace(strings.Replace(ev.Data, "\r\n", "\n", -1), "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return s.write(buf.Bytes())
+}
+
+func (s *SSEStreamer) write(p []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	select {
+	case <-s.done:
+		return terrors.InternalService("stream_closed", "Event stream has ended", nil)
+	default:
+	}
+	_, err := s.w.Write(p)
+	return err
+}
+
+// Done returns a channel which is closed when the stream ends
+func (s *SSEStreamer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream
+func (s *SSEStreamer) Close() error {
+	s.closer.Do(func() {
+		close(s.done)
+	})
+	return s.w.Close() // unblocks any write in progress
+}
@@ -0,0 +1,67 @@
+package typhon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSE(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		sse := rsp.SSE(0)
+		go func() {
+			defer sse.Close()
+			sse.Send(SSEEvent{Data: "hello"})
+			sse.Send(SSEEvent{ID: "2", Event: "update", Data: "line 1\nline 2", Retry: 3 * time.Second})
+		}()
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", rsp.Header.Get("Cache-Control"))
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\nid: 2\nevent: update\nretry: 3000\ndata: line 1\ndata: line 2\n\n", string(b))
+}
+
+func TestSSEHeartbeatAndCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rsp := NewResponse(NewRequest(ctx, "GET", "/", nil))
+	sse := rsp.SSE(10 * time.Millisecond)
+
+	r := bufio.NewReader(rsp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ":\n", line)
+
+	cancel()
+	select {
+	case <-sse.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream didn't end when the context was cancelled")
+	}
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "", strings.Trim(string(rest), ":\n"))
+	assert.Error(t, sse.Send(SSEEvent{Data: "too late"}))
+}
+
+func TestSSEInvalidEvent(t *testing.T) {
+	rsp := NewResponse(NewRequest(nil, "GET", "/", nil))
+	sse := rsp.SSE(0)
+	defer sse.Close()
+	assert.Error(t, sse.Send(SSEEvent{Event: "bad\nevent", Data: "x"}))
+}
@@ -0,0 +1,75 @@
+package typhon
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// StatsVar is the name under which the counters maintained by StatsFilter are published with expvar
+const StatsVar = "typhon"
+
+var (
+	statsRequests = new(expvar.Int)
+	statsErrors   = new(expvar.Int)
+	statsInFlight = new(expvar.Int)
+	statsStatuses = new(expvar.Map).Init()
+	statsClasses  [5]*expvar.Int // 1xx to 5xx
+	stats         = new(expvar.Map).Init()
+	statsPublish  sync.Once
+)
+
+func init() {
+	for i := range statsClasses {
+		statsClasses[i] = new(expvar.Int)
+		statsStatuses.Set(strconv.Itoa(i+1)+"xx", statsClasses[i])
+	}
+	stats.Set("requests", statsRequests)
+	stats.Set("errors", statsErrors)
+	stats.Set("in_flight", statsInFlight)
+	stats.Set("responses", statsStatuses)
+}
+
+func publishStats() {
+	statsPublish.Do(func() {
+		expvar.Publish(StatsVar, stats)
+	})
+}
+
+// StatsFilter maintains counters of the requests it sees, which are published with expvar (so appear in
+// /debug/vars, if the expvar handler is served) and served by StatsHandler: the total number of requests, the number
+// in flight, the number whose responses have errors, and the number of responses in each status class (2xx, 4xx,
+// etc.). A request stops being in flight once the service returns its response, even if its body is then streamed.
+//
+// The counters are shared by all uses of the filter, so it should be applied once per server.
+func StatsFilter(req Request, svc Service) Response {
+	publishStats()
+	statsRequests.Add(1)
+	statsInFlight.Add(1)
+	defer statsInFlight.Add(-1)
+	rsp := svc(req)
+
+	status := 0
+	if rsp.Error != nil {
+		statsErrors.Add(1)
+		status = ErrorStatusCode(rsp.Error)
+	} else if rsp.Response != nil {
+		status = rsp.StatusCode
+	}
+	if class := status / 100; class >= 1 && class <= len(statsClasses) {
+		statsClasses[class-1].Add(1)
+	}
+	return rsp
+}
+
+// StatsHandler returns a Service which responds with the counters maintained by StatsFilter, as JSON
+func StatsHandler() Service {
+	publishStats()
+	return func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Header.Set("Content-Type", "application/json")
+		rsp.Header.Set("Cache-Control", "no-store")
+		rsp.Write([]byte(stats.String()))
+		return rsp
+	}
+}
@@ -0,0 +1,67 @@
+package typhon
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type statsSnapshot struct {
+	Requests  int64            `json:"requests"`
+	Errors    int64            `json:"errors"`
+	InFlight  int64            `json:"in_flight"`
+	Responses map[string]int64 `json:"responses"`
+}
+
+func readStats(t *testing.T) statsSnapshot {
+	rsp := StatsHandler()(NewRequest(nil, "GET", "/stats", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	s := statsSnapshot{}
+	require.NoError(t, rsp.Decode(&s))
+	return s
+}
+
+// Not parallel: the counters are global
+func TestStatsFilter(t *testing.T) {
+	before := readStats(t)
+	inFlight := make(chan statsSnapshot, 1)
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/missing":
+			return Response{
+				Error: terrors.NotFound("", "Not found", nil)}
+		case "/fail":
+			rsp := NewResponse(req)
+			rsp.StatusCode = http.StatusBadGateway
+			return rsp
+		}
+		inFlight <- readStats(t)
+		return NewResponse(req)
+	}).Filter(StatsFilter)
+
+	svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, before.InFlight+1, (<-inFlight).InFlight)
+	svc(NewRequest(nil, "GET", "/missing", nil))
+	svc(NewRequest(nil, "GET", "/fail", nil))
+
+	after := readStats(t)
+	assert.Equal(t, before.Requests+3, after.Requests)
+	assert.Equal(t, before.Errors+1, after.Errors)
+	assert.Equal(t, before.InFlight, after.InFlight)
+	assert.Equal(t, before.Responses["2xx"]+1, after.Responses["2xx"])
+	assert.Equal(t, before.Responses["4xx"]+1, after.Responses["4xx"])
+	assert.Equal(t, before.Responses["5xx"]+1, after.Responses["5xx"])
+
+	// The same counters are published with expvar
+	v := expvar.Get(StatsVar)
+	require.NotNil(t, v)
+	published := statsSnapshot{}
+	require.NoError(t, json.Unmarshal([]byte(v.String()), &published))
+	assert.Equal(t, after, published)
+}
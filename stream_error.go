@@ -0,0 +1,74 @@
+package typhon
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/terrors/proto"
+)
+
+// StreamErrorTrailer is the HTTP trailer in which HttpHandler sends the error which ended a streaming response, if
+// any: a stream without it (or with it empty) completed cleanly. Its value is the JSON-serialised error, without its
+// stack trace.
+const StreamErrorTrailer = "X-Stream-Error"
+
+// An ErrorCloser is a stream which can be ended with an error, such as one returned by Streamer.
+type ErrorCloser interface {
+	CloseWithError(err error) error
+}
+
+func encodeStreamError(err error) string {
+	m := terrors.Marshal(terrors.Wrap(err, nil).(*terrors.Error))
+	m.Stack = nil
+	b, jerr := json.Marshal(m)
+	if jerr != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func decodeStreamError(v string) error {
+	tp := &terrorsproto.Error{}
+	if err := json.Unmarshal([]byte(v), tp); err != nil {
+		return errors.New(v)
+	}
+	return terrors.Unmarshal(tp)
+}
+
+// errRecordingReader records the first error (other than EOF) returned by the underlying reader
+type errRecordingReader struct {
+	io.Reader
+	err error
+}
+
+func (r *errRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// trailerErrorReader wraps the body of a streaming response, returning the error carried in its StreamErrorTrailer (if
+// any) in place of EOF
+type trailerErrorReader struct {
+	io.ReadCloser
+	rsp *http.Response
+}
+
+func (r *trailerErrorReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if v := r.rsp.Trailer.Get(StreamErrorTrailer); v != "" {
+			err = decodeStreamError(v)
+		}
+	}
+	return n, err
+}
+
+func (r *trailerErrorReader) unwrapBody() io.ReadCloser {
+	return r.ReadCloser
+}
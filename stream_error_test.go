@@ -0,0 +1,53 @@
+package typhon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamErrorTrailer(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			s.Write([]byte("partial"))
+			if req.URL.Path == "/fail" {
+				s.(ErrorCloser).CloseWithError(terrors.InternalService("db", "Lost connection", nil))
+			} else {
+				s.Close()
+			}
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	gz, err := Listen(svc.Filter(GzipFilter), "localhost:0")
+	require.NoError(t, err)
+	defer gz.Stop()
+
+	for _, srv := range []Server{s, gz} {
+		rsp := NewRequest(nil, "GET", fmt.Sprintf("http://%s/ok", srv.Listener().Addr()), nil).Send().Response()
+		require.NoError(t, rsp.Error)
+		b, err := ioutil.ReadAll(rsp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "partial", string(b))
+
+		rsp = NewRequest(nil, "GET", fmt.Sprintf("http://%s/fail", srv.Listener().Addr()), nil).Send().Response()
+		require.NoError(t, rsp.Error)
+		b, err = ioutil.ReadAll(rsp.Body)
+		assert.Equal(t, "partial", string(b))
+		require.Error(t, err)
+		assert.True(t, terrors.PrefixMatches(err, "internal_service.db"), err.Error())
+		assert.Equal(t, "Lost connection", err.(*terrors.Error).Message)
+		if srv == s {
+			assert.Contains(t, rsp.Trailer.Get(StreamErrorTrailer), `"code":"internal_service.db"`)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package typhon
+
+import "time"
+
+// TeeOptions configures TeeWithOptions
+type TeeOptions struct {
+	// Timeout bounds each request to a secondary. If zero, DefaultMirrorTimeout is used.
+	Timeout time.Duration
+	// Compare, if set, is called with each request along with a copy of the primary's response and the secondaries'
+	// responses (in the order the secondaries were given), once all of them are available: for example, to alert when
+	// they disagree. It is called in its own goroutine, and the secondaries' response bodies are closed when it returns.
+	// As with MirrorOptions.Compare, the primary's response is buffered so that it can be copied, and streaming
+	// responses are not compared.
+	Compare func(req Request, primary Response, secondaries []Response)
+}
+
+// Tee returns a Service which sends each request to all of the given services, and responds with the primary's
+// response: for example, to write to both the old and the new service during a migration. Every request is sent to
+// each secondary (MirrorFilter suits sending only a sample of them to a single service). The secondaries are called in
+// the background, each bounded by its own timeout, and their responses are discarded unless TeeOptions.Compare is set.
+// As the request body must be read by each service, it is buffered; a request whose body can't be replayed (see
+// Request.Rewindable) goes to the primary alone.
+func Tee(primary Service, secondaries ...Service) Service {
+	return TeeWithOptions(TeeOptions{}, primary, secondaries...)
+}
+
+// TeeWithOptions is like Tee, but with the given options
+func TeeWithOptions(opts TeeOptions, primary Service, secondaries ...Service) Service {
+	if primary == nil {
+		panic("typhon: tee primary must not be nil")
+	}
+	for _, s := range secondaries {
+		if s == nil {
+			panic("typhon: tee secondaries must not be nil")
+		}
+	}
+	secondaries = append([]Service(nil), secondaries...)
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultMirrorTimeout
+	}
+	return func(req Request) Response {
+		return mirror(req, primary, secondaries, opts.Timeout, opts.Compare)
+	}
+}
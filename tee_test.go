@@ -0,0 +1,124 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+	type comparison struct {
+		primaryBody     string
+		secondaryBodies []string
+	}
+	compared := make(chan comparison, 1)
+	echo := func(prefix string) Service {
+		return func(req Request) Response {
+			b, err := req.BodyBytes(true)
+			require.NoError(t, err)
+			return req.Response(prefix + string(b))
+		}
+	}
+	svc := TeeWithOptions(TeeOptions{
+		Compare: func(req Request, primary Response, secondaries []Response) {
+			c := comparison{}
+			assert.NoError(t, primary.Decode(&c.primaryBody))
+			for _, rsp := range secondaries {
+				var body string
+				assert.NoError(t, rsp.Decode(&body))
+				c.secondaryBodies = append(c.secondaryBodies, body)
+			}
+			compared <- c
+		}}, echo("old: "), echo("new: "), echo("other: "))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rsp := svc(NewRequest(ctx, "PUT", "/", "body"))
+	cancel() // Cancelling the original request doesn't cancel the secondaries'
+	require.NoError(t, rsp.Error)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "old: \"body\"\n", body)
+
+	select {
+	case c := <-compared:
+		assert.Equal(t, comparison{
+			primaryBody:     "old: \"body\"\n",
+			secondaryBodies: []string{"new: \"body\"\n", "other: \"body\"\n"}}, c)
+	case <-time.After(time.Second):
+		t.Fatal("Responses weren't compared")
+	}
+}
+
+func TestTeeIsolatesSecondaries(t *testing.T) {
+	t.Parallel()
+	compared := make(chan []Response, 1)
+	svc := TeeWithOptions(TeeOptions{
+		Timeout: 50 * time.Millisecond,
+		Compare: func(req Request, primary Response, secondaries []Response) {
+			compared <- secondaries
+		}},
+		func(req Request) Response {
+			return req.Response("primary")
+		},
+		func(req Request) Response {
+			panic("boom")
+		},
+		func(req Request) Response {
+			<-req.Done() // The secondary's own timeout applies
+			return Response{
+				Error: terrors.Wrap(req.Err(), nil)}
+		})
+
+	start := time.Now()
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "the primary's response waited for the secondaries")
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "primary", body)
+
+	select {
+	case secondaries := <-compared:
+		require.Len(t, secondaries, 2)
+		assert.True(t, terrors.PrefixMatches(secondaries[0].Error, terrors.ErrInternalService))
+		assert.Error(t, secondaries[1].Error)
+	case <-time.After(time.Second):
+		t.Fatal("Responses weren't compared")
+	}
+}
+
+func TestTeeStreamingRequest(t *testing.T) {
+	t.Parallel()
+	var secondaryCalls int32
+	svc := Tee(func(req Request) Response {
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusCreated
+		rsp.Write(b)
+		return rsp
+	}, func(req Request) Response {
+		atomic.AddInt32(&secondaryCalls, 1)
+		return NewResponse(req)
+	})
+
+	// Streamed bodies can't be replayed, so they're sent only to the primary
+	req := NewStreamingRequest(nil, "POST", "/", strings.NewReader("upload"))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "upload", string(b))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&secondaryCalls))
+}
@@ -4,26 +4,50 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/monzo/slog"
 	"github.com/monzo/terrors"
 	"github.com/monzo/terrors/proto"
 )
 
+const (
+	// ErrBadGateway is the terrors code used when a proxy fails to get a response from the service it forwards to
+	ErrBadGateway = "bad_gateway"
+	// ErrBodyTooLarge is the terrors code used when a request's body exceeds the size permitted by the service
+	ErrBodyTooLarge = terrors.ErrBadRequest + ".body_too_large"
+	// ErrMethodNotAllowed is the terrors code used when a request's method is not supported for its path
+	ErrMethodNotAllowed = "method_not_allowed"
+	// ErrRateLimited is the terrors code used when a request is refused because a rate limit has been exceeded
+	ErrRateLimited = "rate_limited"
+	// ErrServiceUnavailable is the terrors code used when a service is (perhaps temporarily) unable to handle requests
+	ErrServiceUnavailable = "service_unavailable"
+)
+
 var (
 	mapTerr2Status = map[string]int{
+		ErrBadGateway:                 http.StatusBadGateway,
 		terrors.ErrBadRequest:         http.StatusBadRequest,
+		ErrBodyTooLarge:               http.StatusRequestEntityTooLarge,
 		terrors.ErrBadResponse:        http.StatusNotAcceptable,
 		terrors.ErrForbidden:          http.StatusForbidden,
 		terrors.ErrInternalService:    http.StatusInternalServerError,
+		ErrMethodNotAllowed:           http.StatusMethodNotAllowed,
 		terrors.ErrNotFound:           http.StatusNotFound,
 		terrors.ErrPreconditionFailed: http.StatusPreconditionFailed,
+		ErrRateLimited:                http.StatusTooManyRequests,
+		ErrServiceUnavailable:         http.StatusServiceUnavailable,
 		terrors.ErrTimeout:            http.StatusGatewayTimeout,
 		terrors.ErrUnauthorized:       http.StatusUnauthorized,
 	}
 	mapStatus2Terr map[int]string
+	errorStatusM   sync.RWMutex // protects mapTerr2Status and mapStatus2Terr
 )
 
 func init() {
@@ -33,25 +57,78 @@ func init() {
 	}
 }
 
+// RegisterErrorStatus maps errors with the given terrors code to an HTTP status code, so that services can respond to
+// their own (domain-specific) errors with statuses like 409 or 422. The mapping also applies to errors whose codes are
+// more specific (eg. "conflict.version" if code is "conflict"), and a mapping for a more specific code takes precedence
+// over one for a less specific code (including the built-in mappings). Mappings are typically registered at init time.
+func RegisterErrorStatus(code string, status int) {
+	errorStatusM.Lock()
+	defer errorStatusM.Unlock()
+	mapTerr2Status[code] = status
+	if _, ok := mapStatus2Terr[status]; !ok {
+		mapStatus2Terr[status] = code
+	}
+}
+
 // ErrorStatusCode returns an HTTP status code for the error
 func ErrorStatusCode(err error) int {
 	code := terrors.Wrap(err, nil).(*terrors.Error).Code
-	if c, ok := mapTerr2Status[strings.SplitN(code, ".", 2)[0]]; ok {
-		return c
+	errorStatusM.RLock()
+	defer errorStatusM.RUnlock()
+	for {
+		if c, ok := mapTerr2Status[code]; ok {
+			return c
+		}
+		i := strings.LastIndex(code, ".")
+		if i < 0 {
+			return http.StatusInternalServerError
+		}
+		code = code[:i]
 	}
-	return http.StatusInternalServerError
 }
 
 // terr2StatusCode converts HTTP status codes to a roughly equivalent terrors' code
 func status2TerrCode(code int) string {
+	errorStatusM.RLock()
+	defer errorStatusM.RUnlock()
 	if c, ok := mapStatus2Terr[code]; ok {
 		return c
 	}
 	return terrors.ErrInternalService
 }
 
-// ErrorFilter serialises and de-serialises response errors
+// ErrorFilterOptions configures how ErrorFilterWithOptions serialises errors.
+type ErrorFilterOptions struct {
+	// IncludeStack includes errors' stack traces in responses, and their params in plain text and HTML responses (JSON
+	// responses always include params, as Typhon clients need them to reconstruct errors). This is useful for
+	// debugging during development, but leaks implementation details so it must not be enabled in production.
+	IncludeStack bool
+}
+
+// IncludeErrorStacksEnv is the environment variable which, if set to a true value (eg. "1" or "true"), makes
+// ErrorFilter include stack traces in error responses; see ErrorFilterOptions.IncludeStack.
+const IncludeErrorStacksEnv = "TYPHON_INCLUDE_ERROR_STACKS"
+
+var defaultErrorFilterOptions ErrorFilterOptions
+
+func init() {
+	defaultErrorFilterOptions.IncludeStack, _ = strconv.ParseBool(os.Getenv(IncludeErrorStacksEnv))
+}
+
+// ErrorFilter serialises and de-serialises response errors. Stack traces are omitted from serialised errors unless
+// the IncludeErrorStacksEnv environment variable is set.
 func ErrorFilter(req Request, svc Service) Response {
+	return errorFilter(req, svc, defaultErrorFilterOptions)
+}
+
+// ErrorFilterWithOptions is like ErrorFilter, but serialises errors according to the given options.
+func ErrorFilterWithOptions(opts ErrorFilterOptions) Filter {
+	return func(req Request, svc Service) Response {
+		return errorFilter(req, svc, opts)
+	}
+}
+
+func errorFilter(req Request, svc Service, opts ErrorFilterOptions) Response {
 	// If the request contains an error, short-circuit and return that directly
 	var rsp Response
 	if req.err != nil {
@@ -76,9 +153,8 @@ func ErrorFilter(req Request, svc Service) Response {
 			}
 			rsp.Body = &bufCloser{}
 			terr := terrors.Wrap(rsp.Error, nil).(*terrors.Error)
-			rsp.Encode(terrors.Marshal(terr))
 			rsp.StatusCode = ErrorStatusCode(terr)
-			rsp.Header.Set("Terror", "1")
+			renderError(&rsp, req.Header.Get("Accept"), terr, opts)
 		}
 	} else if rsp.StatusCode >= 400 && rsp.StatusCode <= 599 {
 		// There is an error in the underlying response; unmarshal
@@ -108,3 +184,45 @@ func ErrorFilter(req Request, svc Service) Response {
 
 	return rsp
 }
+
+// errorMediaTypes are the formats in which ErrorFilter can render errors, in order of preference
+var errorMediaTypes = []string{"application/json", "text/plain", "text/html"}
+
+// renderError serialises the error into the response body in the format most acceptable to the client. Errors are
+// rendered as JSON (which Typhon clients will unmarshal back into errors) unless the client prefers plain text or HTML
+// (as browsers do), and also if nothing offered is acceptable.
+func renderError(rsp *Response, accept string, terr *terrors.Error, opts ErrorFilterOptions) {
+	rsp.Header.Add("Vary", "Accept")
+	i, _ := negotiateMediaType(accept, errorMediaTypes)
+	switch errorMediaTypes[i] {
+	case "text/plain":
+		rsp.encode(func(w io.Writer, _ interface{}) error {
+			msg := terr.Error()
+			if opts.IncludeStack {
+				msg = terr.VerboseString()
+			}
+			_, err := fmt.Fprintln(w, msg)
+			return err
+		}, "text/plain; charset=utf-8", nil)
+	case "text/html":
+		status := fmt.Sprintf("%d %s", rsp.StatusCode, http.StatusText(rsp.StatusCode))
+		rsp.encode(func(w io.Writer, _ interface{}) error {
+			details := ""
+			if opts.IncludeStack {
+				details = fmt.Sprintf("<pre>Params: %s\n%s</pre>\n", html.EscapeString(fmt.Sprintf("%+v", terr.Params)),
+					html.EscapeString(terr.StackString()))
+			}
+			_, err := fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n"+
+				"<p>%s</p>\n<p><code>%s</code></p>\n%s</body>\n</html>\n", status, status,
+				html.EscapeString(terr.Message), html.EscapeString(terr.Code), details)
+			return err
+		}, "text/html; charset=utf-8", nil)
+	default:
+		m := terrors.Marshal(terr)
+		if !opts.IncludeStack {
+			m.Stack = nil
+		}
+		rsp.encode(encodeJSON, "application/json", m)
+		rsp.Header.Set("Terror", "1")
+	}
+}
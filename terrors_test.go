@@ -0,0 +1,103 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorStatusCode(t *testing.T) {
+	cases := map[error]int{
+		terrors.NotFound("thing", "", nil):               http.StatusNotFound,
+		terrors.BadRequest("missing_param", "", nil):     http.StatusBadRequest,
+		terrors.New("made_up", "", nil):                  http.StatusInternalServerError,
+		terrors.New(ErrRateLimited+".by_host", "", nil):  http.StatusTooManyRequests,
+		terrors.New("test_conflict", "", nil):            http.StatusInternalServerError,
+		terrors.BadRequest("test_validation", "", nil):   http.StatusBadRequest,
+		terrors.BadRequest("test_validation.x", "", nil): http.StatusBadRequest}
+	for err, status := range cases {
+		assert.Equal(t, status, ErrorStatusCode(err), err.Error())
+	}
+}
+
+func TestRegisterErrorStatus(t *testing.T) {
+	RegisterErrorStatus("test_conflict", http.StatusConflict)
+	RegisterErrorStatus(terrors.ErrBadRequest+".test_validation", http.StatusUnprocessableEntity)
+	defer func() {
+		errorStatusM.Lock()
+		defer errorStatusM.Unlock()
+		delete(mapTerr2Status, "test_conflict")
+		delete(mapTerr2Status, terrors.ErrBadRequest+".test_validation")
+		delete(mapStatus2Terr, http.StatusConflict)
+		delete(mapStatus2Terr, http.StatusUnprocessableEntity)
+	}()
+
+	assert.Equal(t, http.StatusConflict, ErrorStatusCode(terrors.New("test_conflict", "", nil)))
+	assert.Equal(t, http.StatusConflict, ErrorStatusCode(terrors.New("test_conflict.version", "", nil)))
+	assert.Equal(t, http.StatusUnprocessableEntity, ErrorStatusCode(terrors.BadRequest("test_validation", "", nil)))
+	assert.Equal(t, http.StatusUnprocessableEntity, ErrorStatusCode(terrors.BadRequest("test_validation.email", "", nil)))
+	assert.Equal(t, http.StatusBadRequest, ErrorStatusCode(terrors.BadRequest("other", "", nil)))
+	assert.Equal(t, "test_conflict", status2TerrCode(http.StatusConflict))
+
+	// Responses are rendered using the mapping
+	svc := Service(func(req Request) Response {
+		return Response{Error: terrors.New("test_conflict", "Conflict", nil)}
+	}).Filter(ErrorFilter)
+	rsp := svc(NewRequest(nil, "PUT", "/", nil))
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode)
+}
+
+func TestErrorFilterNegotiation(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		return Response{Error: terrors.NotFound("widget", "Widget <3> not found", map[string]string{
+			"id": "3"})}
+	}).Filter(ErrorFilter)
+
+	cases := []struct {
+		accept, contentType, body string
+	}{
+		{"", "application/json", `"code":"not_found.widget"`},
+		{"*/*", "application/json", `"params":{"id":"3"}`},
+		{"application/json, text/plain;q=0.5", "application/json", `"message":"Widget \u003c3\u003e not found"`},
+		{"text/plain", "text/plain; charset=utf-8", "not_found.widget: Widget <3> not found\n"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html; charset=utf-8",
+			"<h1>404 Not Found</h1>\n<p>Widget &lt;3&gt; not found</p>"},
+		{"image/png", "application/json", `"code":"not_found.widget"`}}
+	for _, c := range cases {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("Accept", c.accept)
+		rsp := svc(req)
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode, c.accept)
+		assert.Equal(t, c.contentType, rsp.Header.Get("Content-Type"), c.accept)
+		assert.Equal(t, "Accept", rsp.Header.Get("Vary"))
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), c.body, c.accept)
+		assert.Equal(t, int64(len(b)), rsp.ContentLength)
+	}
+}
+
+func TestErrorFilterIncludeStack(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		return Response{Error: terrors.NotFound("widget", "Widget not found", map[string]string{
+			"id": "3"})}
+	})
+
+	for _, accept := range []string{"application/json", "text/plain", "text/html"} {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		rsp := svc.Filter(ErrorFilter)(req)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "terrors_test.go", accept)
+
+		rsp = svc.Filter(ErrorFilterWithOptions(ErrorFilterOptions{IncludeStack: true}))(req)
+		b, err = rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), "terrors_test.go", accept)
+		assert.Contains(t, string(b), "id", accept)
+	}
+}
@@ -0,0 +1,132 @@
+package typhon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A TestServer is a server listening on a free port on the loopback interface, for use in tests. It is the Typhon
+// equivalent of httptest.Server.
+type TestServer struct {
+	// URL is the base URL of the server, of the form http://127.0.0.1:54321 (or https:// for TLS servers)
+	URL string
+	// Client is a Service which sends requests to the server, regardless of the scheme and host of their URLs, so
+	// requests may be constructed with just a path. For TLS servers, it trusts the server's certificate. Error
+	// responses are decoded by ErrorFilter.
+	Client Service
+	// Certificate is the self-signed certificate served by TLS servers, or nil
+	Certificate *x509.Certificate
+	// Server is the underlying Server
+	Server Server
+
+	transport *http.Transport
+}
+
+// NewTestServer starts a TestServer serving svc over plain HTTP
+func NewTestServer(svc Service) (*TestServer, error) {
+	s, err := Listen(svc, "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newTestServer(s, "http", ClientConfig{})
+}
+
+// NewTLSTestServer starts a TestServer serving svc over HTTPS, with a freshly generated self-signed certificate
+func NewTLSTestServer(svc Service) (*TestServer, error) {
+	cert, err := testServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	s, err := ListenTLS(svc, "127.0.0.1:0", TLSConfig{
+		Certificate: cert})
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	ts, err := newTestServer(s, "https", ClientConfig{
+		TLSConfig: &tls.Config{
+			RootCAs: roots}})
+	if err != nil {
+		return nil, err
+	}
+	ts.Certificate = cert.Leaf
+	return ts, nil
+}
+
+func newTestServer(s Server, scheme string, cfg ClientConfig) (*TestServer, error) {
+	base, err := url.Parse(scheme + "://" + s.Listener().Addr().String())
+	if err != nil {
+		s.Stop()
+		return nil, terrors.Wrap(err, nil)
+	}
+	transport := NewRoundTripper(cfg).(*http.Transport)
+	client := HttpService(transport)
+	ts := &TestServer{
+		URL:       base.String(),
+		Server:    s,
+		transport: transport}
+	ts.Client = Service(func(req Request) Response {
+		if req.URL != nil {
+			u := *req.URL
+			u.Scheme, u.Host = base.Scheme, base.Host
+			req.URL = &u
+		}
+		return client(req)
+	}).Filter(ErrorFilter)
+	return ts, nil
+}
+
+// Close stops the server, waiting for in-flight requests to complete, and closes the Client's idle connections
+func (s *TestServer) Close() error {
+	err := s.Server.Stop()
+	s.transport.CloseIdleConnections()
+	return err
+}
+
+// testServerCertificate generates a self-signed certificate valid for the loopback interface
+func testServerCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Typhon test server"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf}, nil
+}
@@ -0,0 +1,62 @@
+package typhon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestServer(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/fail" {
+			return Response{Error: terrors.Forbidden("nope", "Nope", nil)}
+		}
+		return req.Response(req.URL.Path)
+	}).Filter(ErrorFilter)
+
+	for _, tc := range []struct {
+		name   string
+		start  func(Service) (*TestServer, error)
+		scheme string
+	}{
+		{"HTTP", NewTestServer, "http://"},
+		{"TLS", NewTLSTestServer, "https://"}} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ts, err := tc.start(svc)
+			require.NoError(t, err)
+			defer ts.Close()
+			assert.True(t, strings.HasPrefix(ts.URL, tc.scheme+"127.0.0.1:"), ts.URL)
+
+			rsp := NewRequest(context.Background(), "GET", "/foo", nil).SendVia(ts.Client).Response()
+			require.NoError(t, rsp.Error)
+			var path string
+			require.NoError(t, rsp.Decode(&path))
+			assert.Equal(t, "/foo", path)
+
+			rsp = NewRequest(context.Background(), "GET", ts.URL+"/fail", nil).SendVia(ts.Client).Response()
+			assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrForbidden))
+			assert.Equal(t, tc.name == "TLS", ts.Certificate != nil)
+		})
+	}
+}
+
+func TestTestServerUntrustedClient(t *testing.T) {
+	t.Parallel()
+	ts, err := NewTLSTestServer(Service(func(req Request) Response {
+		return req.Response("ok")
+	}))
+	require.NoError(t, err)
+	defer ts.Close()
+
+	// The default client doesn't trust the server's certificate
+	client := HttpService(NewRoundTripper(ClientConfig{}))
+	rsp := NewRequest(context.Background(), "GET", ts.URL, nil).SendVia(client).Response()
+	assert.Error(t, rsp.Error)
+}
@@ -0,0 +1,63 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// TimeoutFilter returns a Filter which limits the time the service may take to respond to d. The service is passed a
+// context with that deadline; if it hasn't returned a response when the deadline passes, the context is cancelled and
+// the request fails with a timeout error (a 504 Gateway Timeout), and any response the service returns later is
+// discarded. If the request's own context is cancelled first, it fails with that error instead.
+//
+// Once the service has returned a response, it stands: a streaming response's status and headers may already have
+// been sent, so they can't be replaced. The deadline continues to apply to a streamed body, though, so if the stream
+// is still going when it passes, the context is cancelled and the body closed, and the client sees the stream end
+// early (with an error, if the client is Typhon).
+func TimeoutFilter(d time.Duration) Filter {
+	return func(req Request, svc Service) Response {
+		parent := req.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(parent, d)
+		req.Context = ctx
+
+		done := make(chan Response, 1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					slog.Error(req, "Recovered from panic in %v: %v\n%s", req, v, debug.Stack())
+					done <- Response{
+						Error: terrors.InternalService("panic", fmt.Sprintf("Panic serving request: %v", v), nil)}
+				}
+			}()
+			done <- svc(req)
+		}()
+
+		select {
+		case rsp := <-done:
+			releaseWithBody(ctx, rsp, cancel)
+			return rsp
+		case <-ctx.Done():
+			cancel()
+			// The service may still return a response, which nobody will read
+			go func() {
+				if rsp := <-done; rsp.Response != nil && rsp.Body != nil {
+					rsp.Body.Close()
+				}
+			}()
+			if parent.Err() != nil {
+				return Response{
+					Error: terrors.Wrap(parent.Err(), nil)}
+			}
+			return Response{
+				Error: terrors.Timeout("handler", fmt.Sprintf("Request timed out after %v", d), nil)}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutFilter(t *testing.T) {
+	t.Parallel()
+	cancelled := make(chan struct{}, 2)
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/slow" {
+			<-req.Done()
+			cancelled <- struct{}{}
+			time.Sleep(10 * time.Millisecond)
+			return req.Response("late")
+		}
+		_, ok := req.Deadline()
+		assert.True(t, ok)
+		return req.Response("fast")
+	}).Filter(TimeoutFilter(20 * time.Millisecond))
+
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "fast", body)
+
+	start := time.Now()
+	rsp = svc.Filter(ErrorFilter)(NewRequest(nil, "GET", "/slow", nil))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, "timeout.handler"))
+	assert.Equal(t, http.StatusGatewayTimeout, rsp.StatusCode)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("The service's context wasn't cancelled")
+	}
+
+	// Cancellation by the caller is reported as such
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rsp = svc(NewRequest(ctx, "GET", "/slow", nil))
+	require.Error(t, rsp.Error)
+	assert.False(t, terrors.PrefixMatches(rsp.Error, "timeout.handler"))
+}
+
+func TestTimeoutFilterPanic(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		panic("boom")
+	}).Filter(TimeoutFilter(time.Second))
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, "internal_service.panic"))
+}
+
+func TestTimeoutFilterStreaming(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			s.Write([]byte("a"))
+			<-req.Done()
+		}()
+		rsp := NewResponse(req)
+		rsp.Body = s
+		return rsp
+	}).Filter(TimeoutFilter(20 * time.Millisecond))
+
+	// The response stands, but the body is cut off at the deadline
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ioutil.ReadAll(rsp.Body)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The stream wasn't cut off at the deadline")
+	}
+}
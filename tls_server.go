@@ -0,0 +1,220 @@
+package typhon
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// TLSConfig configures a server which serves HTTPS.
+type TLSConfig struct {
+	// CertFile and KeyFile are paths to a PEM-encoded certificate (chain) and private key. They are ignored if
+	// Certificate is set.
+	CertFile, KeyFile string
+	// Certificate is an in-memory certificate to serve.
+	Certificate *tls.Certificate
+	// ReloadOnSIGHUP causes the certificate to be reloaded from CertFile and KeyFile when the process receives a
+	// SIGHUP. If reloading fails, the previous certificate continues to be served.
+	ReloadOnSIGHUP bool
+	// RedirectAddr, if set, is an address on which ListenTLS additionally serves plain HTTP, redirecting all requests
+	// to HTTPS with a 301 Moved Permanently.
+	RedirectAddr string
+	// Config, if set, is used as the basis of the server's TLS configuration. Otherwise, modern defaults are used
+	// (see DefaultServerTLSConfig).
+	Config *tls.Config
+}
+
+// DefaultServerTLSConfig returns a TLS configuration with modern defaults for servers: TLS 1.2 or later, with only
+// forward-secret AEAD cipher suites.
+func DefaultServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}}
+}
+
+// HttpServerTLS is like HttpServer, but the returned server is configured to serve HTTPS (including HTTP/2) using the
+// given configuration. It should be started with ServeTLS; alternatively, see ListenTLS.
+func HttpServerTLS(svc Service, cfg TLSConfig) (*http.Server, error) {
+	certs, err := newCertificateHolder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := DefaultServerTLSConfig()
+	if cfg.Config != nil {
+		tlsConfig = cfg.Config.Clone()
+	}
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = certs.get
+	if !stringsContain(tlsConfig.NextProtos, "h2") {
+		tlsConfig.NextProtos = append([]string{"h2"}, tlsConfig.NextProtos...)
+	}
+	if !stringsContain(tlsConfig.NextProtos, "http/1.1") {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, "http/1.1")
+	}
+
+	srv := HttpServer(svc)
+	srv.TLSConfig = tlsConfig
+	if cfg.ReloadOnSIGHUP {
+		srv.RegisterOnShutdown(certs.reloadOnSIGHUP(cfg))
+	}
+	return srv, nil
+}
+
+// ServeTLS is like Serve, but serves HTTPS according to the passed configuration. cfg.RedirectAddr is ignored.
+func ServeTLS(svc Service, l net.Listener, cfg TLSConfig) (Server, error) {
+	srv, err := HttpServerTLS(svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return serve(srv, l)
+}
+
+// ListenTLS is like Listen, but serves HTTPS according to the passed configuration. If cfg.RedirectAddr is set, a
+// companion server listening there redirects plain HTTP requests to HTTPS; it is shut down along with the returned
+// Server.
+func ListenTLS(svc Service, addr string, cfg TLSConfig) (Server, error) {
+	l, err := listenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ServeTLS(svc, l, cfg)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if cfg.RedirectAddr == "" {
+		return s, nil
+	}
+
+	rl, err := listenTCP("tcp", cfg.RedirectAddr)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	redirect, err := Serve(HTTPSRedirectService(port), rl)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+	s.(*server).companions = append(s.(*server).companions, redirect)
+	return s, nil
+}
+
+// HTTPSRedirectService returns a Service which redirects all requests to the same URL on HTTPS, at the given port. If
+// port is empty or "443", the port is omitted from the target URL.
+func HTTPSRedirectService(port string) Service {
+	return func(req Request) Response {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusMovedPermanently
+		rsp.Header.Set("Location", "https://"+host+req.URL.RequestURI())
+		return rsp
+	}
+}
+
+// certificateHolder holds the certificate being served, which may be replaced when reloaded
+type certificateHolder struct {
+	sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertificateHolder(cfg TLSConfig) (*certificateHolder, error) {
+	if cfg.Certificate != nil {
+		return &certificateHolder{
+			cert: cfg.Certificate}, nil
+	}
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &certificateHolder{
+		cert: cert}, nil
+}
+
+func loadCertificate(cfg TLSConfig) (*tls.Certificate, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, terrors.InternalService("missing_certificate", "No certificate configured", nil)
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, terrors.Wrap(err, map[string]string{
+			"cert_file": cfg.CertFile,
+			"key_file":  cfg.KeyFile})
+	}
+	return &cert, nil
+}
+
+func (h *certificateHolder) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	h.RLock()
+	defer h.RUnlock()
+	return h.cert, nil
+}
+
+func (h *certificateHolder) reload(cfg TLSConfig) error {
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		return err
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.cert = cert
+	return nil
+}
+
+// reloadOnSIGHUP starts reloading the certificate whenever a SIGHUP is received, returning a function which stops it
+func (h *certificateHolder) reloadOnSIGHUP(cfg TLSConfig) func() {
+	sigs := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				if err := h.reload(cfg); err != nil {
+					log.Error(nil, "Error reloading TLS certificate: %v", err)
+				} else {
+					log.Info(nil, "Reloaded TLS certificate from %s", cfg.CertFile)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(stop)
+		})
+	}
+}
+
+func stringsContain(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package typhon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "typhon-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := testCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Typhon Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign}, nil)
+	serverTmpl := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "server"},
+			DNSNames:    []string{"localhost"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	}
+	testCert(t, dir, "server", serverTmpl(), &ca)
+
+	svc := Service(func(req Request) Response {
+		return req.Response(req.Proto)
+	})
+	s, err := ListenTLS(svc, "localhost:0", TLSConfig{
+		CertFile:       filepath.Join(dir, "server.crt"),
+		KeyFile:        filepath.Join(dir, "server.key"),
+		ReloadOnSIGHUP: true,
+		RedirectAddr:   "localhost:0"})
+	require.NoError(t, err)
+	defer s.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	var served *x509.Certificate
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs: roots,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				served = cs.PeerCertificates[0]
+				return nil
+			}}}
+	defer transport.CloseIdleConnections()
+	client := HttpService(transport)
+	url := fmt.Sprintf("https://localhost:%d/foo?bar=baz", s.Listener().Addr().(*net.TCPAddr).Port)
+
+	rsp := NewRequest(nil, "GET", url, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, 2, rsp.ProtoMajor)
+	rsp.Body.Close()
+	firstSerial := served.SerialNumber
+
+	t.Run("Redirect", func(t *testing.T) {
+		redirect := s.(*server).companions[0]
+		port := redirect.Listener().Addr().(*net.TCPAddr).Port
+		rsp := NewRequest(nil, "GET", fmt.Sprintf("http://localhost:%d/foo?bar=baz", port), nil).
+			SendVia(HttpService(&http.Transport{})).Response()
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+		assert.Equal(t, url, rsp.Header.Get("Location"))
+	})
+
+	t.Run("ReloadOnSIGHUP", func(t *testing.T) {
+		testCert(t, dir, "server", serverTmpl(), &ca)
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		for i := 0; i < 50; i++ {
+			transport.CloseIdleConnections()
+			rsp := NewRequest(nil, "GET", url, nil).SendVia(client).Response()
+			require.NoError(t, rsp.Error)
+			rsp.Body.Close()
+			if served.SerialNumber.Cmp(firstSerial) != 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("certificate was not reloaded")
+	})
+}
+
+func TestHttpServerTLSMissingCertificate(t *testing.T) {
+	_, err := HttpServerTLS(Service(BareClient), TLSConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package typhon
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/monzo/terrors"
+)
+
+// WebSocketOptions configures Request.UpgradeWebSocket.
+type WebSocketOptions struct {
+	// Subprotocols are the application protocols supported by the server, in order of preference. The first one also
+	// requested by the client (in the Sec-WebSocket-Protocol header) is selected; see websocket.Conn.Subprotocol.
+	Subprotocols []string
+	// CheckOrigin returns whether the request's Origin header is acceptable. If nil, only requests whose Origin (if
+	// any) matches their Host are accepted, which protects against cross-site WebSocket hijacking.
+	CheckOrigin func(req Request) bool
+	// ReadBufferSize and WriteBufferSize are the sizes of the connection's I/O buffers. If zero, 4KB is used.
+	ReadBufferSize, WriteBufferSize int
+	// EnableCompression negotiates per-message compression with clients which support it
+	EnableCompression bool
+}
+
+type hijackContextKeyType struct{}
+
+var hijackContextKey = hijackContextKeyType{}
+
+// hijackableWriter is the ResponseWriter for a request served by HttpHandler, made available to the service so that
+// it can take over the connection. Once it has, HttpHandler doesn't write a response.
+type hijackableWriter struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, terrors.InternalService("not_hijackable", "Connection does not support hijacking", nil)
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+func withHijackableWriter(ctx context.Context, w *hijackableWriter) context.Context {
+	return context.WithValue(ctx, hijackContextKey, w)
+}
+
+// UpgradeWebSocket upgrades the request's connection to a WebSocket, completing the handshake. It may only be called
+// on requests received by HttpHandler, over HTTP/1.1, and must be called before the Service returns. Once the
+// connection has been upgraded, the Response returned by the Service is discarded (it may be the zero Response). If
+// the handshake fails, an error is returned which should be returned in the Service's Response as normal.
+func (r Request) UpgradeWebSocket(opts WebSocketOptions) (*websocket.Conn, error) {
+	w, ok := r.Value(hijackContextKey).(*hijackableWriter)
+	if !ok || w.hijacked {
+		return nil, terrors.InternalService("not_upgradable", "Request was not received by HttpHandler", nil)
+	}
+	var handshakeErr error
+	u := &websocket.Upgrader{
+		ReadBufferSize:    opts.ReadBufferSize,
+		WriteBufferSize:   opts.WriteBufferSize,
+		Subprotocols:      opts.Subprotocols,
+		EnableCompression: opts.EnableCompression,
+		Error: func(_ http.ResponseWriter, _ *http.Request, status int, reason error) {
+			code := terrors.ErrBadRequest
+			if status == http.StatusForbidden {
+				code = terrors.ErrForbidden
+			}
+			handshakeErr = terrors.New(code+".websocket_handshake", reason.Error(), nil)
+		}}
+	if opts.CheckOrigin != nil {
+		u.CheckOrigin = func(*http.Request) bool {
+			return opts.CheckOrigin(r)
+		}
+	}
+	conn, err := u.Upgrade(w, &r.Request, nil)
+	if handshakeErr != nil {
+		return nil, handshakeErr
+	} else if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	return conn, nil
+}
@@ -0,0 +1,68 @@
+package typhon
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeWebSocket(t *testing.T) {
+	svc := Service(func(req Request) Response {
+		conn, err := req.UpgradeWebSocket(WebSocketOptions{
+			Subprotocols: []string{"echo.v2", "echo.v1"},
+			CheckOrigin: func(req Request) bool {
+				return req.Header.Get("Origin") != "http://evil.example"
+			}})
+		if err != nil {
+			return Response{Error: err}
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				typ, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(typ, append([]byte(conn.Subprotocol()+": "), msg...))
+			}
+		}()
+		return Response{}
+	}).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop()
+	url := fmt.Sprintf("ws://%s/", s.Listener().Addr())
+
+	dialer := &websocket.Dialer{
+		Subprotocols: []string{"echo.v1"}}
+	conn, rsp, err := dialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, rsp.StatusCode)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "echo.v1: hello", string(msg))
+
+	// Disallowed origins are refused with a normal error response
+	_, rsp, err = dialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	assert.Error(t, err)
+	require.NotNil(t, rsp)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+	// As are requests which aren't WebSocket handshakes at all
+	r := NewRequest(nil, "GET", fmt.Sprintf("http://%s/", s.Listener().Addr()), nil).
+		SendVia(Service(BareClient).Filter(ErrorFilter)).Response()
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+	assert.True(t, terrors.PrefixMatches(r.Error, terrors.ErrBadRequest+".websocket_handshake"))
+}
+
+func TestUpgradeWebSocketWithoutHandler(t *testing.T) {
+	_, err := NewRequest(nil, "GET", "/", nil).UpgradeWebSocket(WebSocketOptions{})
+	assert.Error(t, err)
+}
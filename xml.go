@@ -0,0 +1,123 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/monzo/terrors"
+)
+
+// xmlContentType is the Content-Type set on XML-encoded bodies
+const xmlContentType = "application/xml; charset=utf-8"
+
+// isXMLContentType returns whether the given Content-Type header denotes an XML body
+func isXMLContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// decodeXML de-serialises the XML document b into v. The charset parameter of the Content-Type takes precedence over
+// any encoding in the XML declaration (as RFC 7303 requires); UTF-8, US-ASCII and ISO-8859-1 are supported.
+func decodeXML(b []byte, contentType string, v interface{}) error {
+	_, params, _ := mime.ParseMediaType(contentType)
+	var dec *xml.Decoder
+	if cs := params["charset"]; cs != "" {
+		r, err := xmlCharsetReader(cs, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		dec = xml.NewDecoder(r)
+		dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+			return input, nil // already converted
+		}
+	} else {
+		dec = xml.NewDecoder(bytes.NewReader(b))
+		dec.CharsetReader = xmlCharsetReader
+	}
+	return dec.Decode(v)
+}
+
+// xmlCharsetReader converts input in the named charset to UTF-8
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso_8859-1", "latin1", "latin-1":
+		b, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(b))
+		for _, c := range b {
+			out = utf8.AppendRune(out, rune(c))
+		}
+		return bytes.NewReader(out), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+// EncodeXML serialises the passed object as XML into the body (and sets appropriate headers).
+func (r *Request) EncodeXML(v interface{}) {
+	r.encode(encodeXML, xmlContentType, v)
+}
+
+// DecodeXML de-serialises the XML body into the passed object. If the body is not XML (according to its
+// Content-Type), a bad request error is returned.
+func (r Request) DecodeXML(v interface{}) error {
+	ct := r.Header.Get("Content-Type")
+	if !isXMLContentType(ct) {
+		return terrors.BadRequest("invalid_content_type", "Request body is not XML", map[string]string{
+			"content_type": ct})
+	}
+	b, err := r.BodyBytes(true)
+	if err == nil {
+		err = decodeXML(b, ct, v)
+	}
+	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+}
+
+// ResponseXML constructs a new Response to the request, and if non-nil, encodes the given body into it as XML.
+func (r Request) ResponseXML(body interface{}) Response {
+	rsp := NewResponse(r)
+	if body != nil {
+		rsp.EncodeXML(body)
+	}
+	return rsp
+}
+
+// EncodeXML serialises the passed object as XML into the body (and sets appropriate headers).
+func (r *Response) EncodeXML(v interface{}) {
+	r.encode(encodeXML, xmlContentType, v)
+}
+
+// DecodeXML de-serialises the XML body into the passed object. If the body is not XML (according to its
+// Content-Type), a bad response error is returned.
+func (r *Response) DecodeXML(v interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	} else if r.Response == nil {
+		r.Error = terrors.InternalService("", "Response has no body", nil)
+		return r.Error
+	}
+	ct := r.Header.Get("Content-Type")
+	if !isXMLContentType(ct) {
+		r.Error = terrors.BadResponse("invalid_content_type", "Response body is not XML", map[string]string{
+			"content_type": ct})
+		return r.Error
+	}
+	b, err := r.BodyBytes(true)
+	if err == nil {
+		err = decodeXML(b, ct, v)
+	}
+	r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+	return r.Error
+}
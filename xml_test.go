@@ -0,0 +1,80 @@
+package typhon
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type xmlTestPayment struct {
+	XMLName xml.Name `xml:"payment"`
+	ID      string   `xml:"id,attr"`
+	Payee   string   `xml:"payee"`
+	Amount  int      `xml:"amount"`
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	t.Parallel()
+	payment := xmlTestPayment{
+		ID:     "p_1",
+		Payee:  "Zoë",
+		Amount: 100}
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.EncodeXML(payment)
+	assert.Equal(t, "application/xml; charset=utf-8", req.Header.Get("Content-Type"))
+	assert.True(t, req.ContentLength > 0)
+	decoded := xmlTestPayment{}
+	require.NoError(t, req.DecodeXML(&decoded))
+	assert.Equal(t, payment.Payee, decoded.Payee)
+
+	rsp := req.ResponseXML(payment)
+	assert.Equal(t, "application/xml; charset=utf-8", rsp.Header.Get("Content-Type"))
+	decoded = xmlTestPayment{}
+	require.NoError(t, rsp.DecodeXML(&decoded))
+	assert.Equal(t, "p_1", decoded.ID)
+	assert.Equal(t, 100, decoded.Amount)
+}
+
+func TestXMLCharsets(t *testing.T) {
+	t.Parallel()
+	latin1 := "<payment id=\"p_1\"><payee>Zo\xeb</payee></payment>"
+	cases := []struct {
+		contentType, body string
+	}{
+		{"application/xml; charset=ISO-8859-1", latin1},
+		{"text/xml", `<?xml version="1.0" encoding="ISO-8859-1"?>` + latin1},
+		// The Content-Type takes precedence over the declaration
+		{"application/soap+xml; charset=iso-8859-1", `<?xml version="1.0" encoding="UTF-8"?>` + latin1}}
+	for _, c := range cases {
+		// Both directly, and through the XML codec
+		for _, decode := range []func(Request, interface{}) error{Request.DecodeXML, Request.Decode} {
+			req := NewRequest(nil, "POST", "/", nil)
+			req.Write([]byte(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			decoded := xmlTestPayment{}
+			require.NoError(t, decode(req, &decoded), c.contentType)
+			assert.Equal(t, "Zoë", decoded.Payee, c.contentType)
+		}
+	}
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Write([]byte(`<payment/>`))
+	req.Header.Set("Content-Type", "application/xml; charset=shift_jis")
+	err := req.DecodeXML(&xmlTestPayment{})
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadRequest))
+}
+
+func TestXMLContentTypeMismatch(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "POST", "/", map[string]string{"a": "b"})
+	err := req.DecodeXML(&xmlTestPayment{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_request.invalid_content_type"))
+
+	rsp := req.Response(map[string]string{"a": "b"})
+	err = rsp.DecodeXML(&xmlTestPayment{})
+	assert.True(t, terrors.PrefixMatches(err, "bad_response.invalid_content_type"))
+}